@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Amzza0x00/go-impacket/pkg"
+	"github.com/Amzza0x00/go-impacket/pkg/common"
+	DCERPCv5 "github.com/Amzza0x00/go-impacket/pkg/dcerpc/v5"
+	"github.com/Amzza0x00/go-impacket/pkg/smb/smb2"
+	"github.com/Amzza0x00/go-impacket/pkg/util"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 通过srvsvc、wkssvc枚举目标的服务器信息、共享、会话、登录用户、连接以及打开的文件，用于定位管理员登录位置
+
+var (
+	ip       string
+	user     string
+	domain   string
+	password string
+	hash     string
+	port     int
+	thread   int
+	debug    bool
+)
+
+func init() {
+	flag.StringVar(&ip, "ip", "", "目标ip或ip段")
+	flag.StringVar(&user, "user", "", "用户名")
+	flag.StringVar(&domain, "domain", "", "域名")
+	flag.StringVar(&password, "pass", "", "密码")
+	flag.StringVar(&hash, "hash", "", "哈希")
+	flag.IntVar(&port, "port", 445, "目标端口")
+	flag.IntVar(&thread, "t", 50, "线程数量")
+	flag.BoolVar(&debug, "debug", false, "开启调试信息")
+	flag.Parse()
+	fmt.Println(pkg.BANNER)
+	if flag.NFlag() < 3 {
+		log.Fatalln("Usage: netview -ip 172.20.10.* -user administrator -hash 32ed87bdb5fdc5e9cba88547376818d4")
+	}
+	if ip == "" {
+		log.Fatalln("目标地址为空")
+	}
+}
+
+func main() {
+	ips, err := util.IpParse(ip)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	c := make(chan struct{}, thread)
+	for _, i := range ips {
+		wg.Add(1)
+		c <- struct{}{}
+		go func(ip string) {
+			defer func() {
+				<-c
+				wg.Done()
+			}()
+			out := netview(ip)
+			if out == "" {
+				return
+			}
+			// 单个目标的结果一次性输出，避免多个目标的输出交错
+			mu.Lock()
+			fmt.Print(out)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+}
+
+// 枚举单个目标，返回需要输出的内容，连接或者登录失败时返回空
+func netview(ip string) string {
+	options := common.ClientOptions{
+		Host:     ip,
+		Port:     port,
+		Domain:   domain,
+		User:     user,
+		Password: password,
+		Hash:     hash,
+	}
+	session, err := smb2.NewSession(options, debug)
+	if err != nil {
+		if debug {
+			log.Printf("[-] Login failed [%s]: %s\n", ip, err)
+		}
+		return ""
+	}
+	defer session.Close()
+	rpc := &DCERPCv5.SMBClient{Client: session}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[*] %s\n", ip)
+	if info, err := rpc.NetrServerGetInfo(101); err != nil {
+		fmt.Fprintf(&b, "    [-] NetrServerGetInfo: %s\n", err)
+	} else {
+		role := "Workstation"
+		if info.IsDomainController() {
+			role = "Domain Controller"
+		} else if info.Type&DCERPCv5.SV_TYPE_SERVER_NT != 0 {
+			role = "Server"
+		}
+		fmt.Fprintf(&b, "    Name: %s  Version: %d.%d  Role: %s  Comment: %s\n", info.Name, info.VersionMajor, info.VersionMinor, role, info.Comment)
+	}
+	shares, err := rpc.NetrShareEnum(1)
+	if err != nil {
+		fmt.Fprintf(&b, "    [-] NetrShareEnum: %s\n", err)
+	}
+	for _, share := range shares {
+		fmt.Fprintf(&b, "    [+] Share: %-16s %-8s %s\n", share.Name, share.TypeName(), share.Remark)
+	}
+	// 10级别普通用户即可查询，失败时降级为0级别
+	sessions, err := rpc.NetrSessionEnum("", "", 10)
+	if err != nil {
+		sessions, err = rpc.NetrSessionEnum("", "", 0)
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "    [-] NetrSessionEnum: %s\n", err)
+	}
+	for _, s := range sessions {
+		fmt.Fprintf(&b, "    [+] Session: %-24s %-24s active %ds idle %ds\n", s.ClientName, s.UserName, s.Time, s.IdleTime)
+	}
+	// 会话枚举只能看到网络登录，交互式登录需要通过wkssvc查询
+	users, err := rpc.NetrWkstaUserEnum(1)
+	if err != nil {
+		if debug {
+			fmt.Fprintf(&b, "    [-] NetrWkstaUserEnum: %s\n", err)
+		}
+	}
+	for _, u := range users {
+		fmt.Fprintf(&b, "    [+] LoggedOn: %s\\%s  LogonServer: %s\n", u.LogonDomain, u.UserName, u.LogonServer)
+	}
+	for _, share := range shares {
+		if share.Type&DCERPCv5.STYPE_MASK == DCERPCv5.STYPE_IPC {
+			continue
+		}
+		conns, err := rpc.NetrConnectionEnum(share.Name, 1)
+		if err != nil {
+			if debug {
+				fmt.Fprintf(&b, "    [-] NetrConnectionEnum [%s]: %s\n", share.Name, err)
+			}
+			continue
+		}
+		for _, conn := range conns {
+			fmt.Fprintf(&b, "    [+] Connection: %-16s %-24s %-24s opens %d\n", share.Name, conn.NetName, conn.UserName, conn.NumOpens)
+		}
+	}
+	files, err := rpc.NetrFileEnum("", "", 3)
+	if err != nil {
+		if debug {
+			fmt.Fprintf(&b, "    [-] NetrFileEnum: %s\n", err)
+		}
+	}
+	for _, f := range files {
+		fmt.Fprintf(&b, "    [+] File: %-24s %s\n", f.UserName, f.PathName)
+	}
+	return b.String()
+}
@@ -1,34 +1,76 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/Amzza0x00/go-impacket/pkg"
 	"github.com/Amzza0x00/go-impacket/pkg/common"
 	DCERPCv5 "github.com/Amzza0x00/go-impacket/pkg/dcerpc/v5"
 	"github.com/Amzza0x00/go-impacket/pkg/util"
+	"io"
 	"log"
+	"net"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+// 通过IObjectExporter的ServerAlive2匿名获取目标的全部网络地址，用于发现多网卡主机以及隐藏的网段
+
 var (
-	ip     string
-	thread int
-	debug  bool
+	ip      string
+	thread  int
+	timeout time.Duration
+	output  string
+	debug   bool
+	// banner输出，json以及csv格式时输出到stderr，保证stdout只有结果
+	info io.Writer = os.Stdout
 )
 
 func init() {
 	flag.StringVar(&ip, "ip", "172.20.10.*", "目标ip或ip段")
 	flag.IntVar(&thread, "t", 2000, "线程数量")
+	flag.DurationVar(&timeout, "timeout", 3*time.Second, "连接以及读取超时时间")
+	flag.StringVar(&output, "o", "text", "输出格式，text、json或csv")
 	flag.BoolVar(&debug, "debug", false, "开启调试信息")
 	flag.Parse()
-	fmt.Println(pkg.BANNER)
+	if output != "text" && output != "json" && output != "csv" {
+		log.Fatalln("不支持的输出格式: " + output)
+	}
+	if output != "text" {
+		info = os.Stderr
+	}
+	fmt.Fprintln(info, pkg.BANNER)
 	if flag.NFlag() < 1 {
 		log.Fatalln("Usage: oxidfind -ip 172.20.10.*")
 	}
 }
 
+// 地址类型
+const (
+	addrIPv4    = "ipv4"
+	addrIPv6    = "ipv6"
+	addrNetBIOS = "netbios"
+)
+
+type address struct {
+	Address string `json:"address"`
+	Type    string `json:"type"`
+}
+
+// 单个主机的扫描结果，同一主机的多个ip都响应时只保留第一个，其他记录在Aliases中
+type hostResult struct {
+	Host      string    `json:"host"`
+	Hostname  string    `json:"hostname"`
+	Addresses []address `json:"addresses"`
+	Aliases   []string  `json:"aliases,omitempty"`
+	index     int       // 在扫描列表中的位置，用于排序输出
+}
+
 func main() {
 	ips, err := util.IpParse(ip)
 	if err != nil {
@@ -36,43 +78,157 @@ func main() {
 		os.Exit(1)
 	}
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	results := []*hostResult{}
+	seen := make(map[string]*hostResult)
 	c := make(chan struct{}, thread)
 	for _, i := range ips {
-		options := common.ClientOptions{
-			Host: i,
-			Port: 135,
-		}
 		wg.Add(1)
+		c <- struct{}{}
 		go func(ip string) {
-			c <- struct{}{}
-			defer wg.Done()
-			session, err := DCERPCv5.NewTCPSession(options, debug)
-			if err != nil {
-				if debug {
-					log.Printf("[-] Connect failed [%s]: %s\n", ip, err)
-				}
-				return
-			}
-			rpc, _ := DCERPCv5.TCPTransport()
-			rpc.Client = session.Client
-			err = rpc.RpcBindIOXIDResolver(1)
-			if err != nil {
-				rpc.Debug("[-]", err)
-				return
-			}
-			address, err := rpc.ServerAlive2Request(2)
-			if err != nil {
-				rpc.Debug("[-]", err)
+			defer func() {
+				<-c
+				wg.Done()
+			}()
+			res := oxidfind(ip)
+			if res == nil {
 				return
 			}
-			fmt.Printf("[*] %s is alive\n", ip)
-			for _, i := range address {
-				if i != "" {
-					fmt.Printf("[+] NetworkAddr: %s\n", i)
+			mu.Lock()
+			defer mu.Unlock()
+			// 多网卡主机在每个网段都会响应，按主机名以及地址集合去重
+			// 没有主机名以及地址时无法判断是否为同一主机，不去重
+			if key := res.key(); key != "" {
+				if first, ok := seen[key]; ok {
+					first.Aliases = append(first.Aliases, ip)
+					if output == "text" {
+						fmt.Printf("[*] %s is the same host as %s\n", ip, first.Host)
+					}
+					return
 				}
+				seen[key] = res
+			}
+			results = append(results, res)
+			if output == "text" {
+				printText(res)
 			}
-			<-c
 		}(i)
 	}
 	wg.Wait()
+	// 扫描顺序不确定，按扫描列表中的位置排序，同一主机保留位置最靠前的ip
+	position := make(map[string]int, len(ips))
+	for index, i := range ips {
+		position[i] = index
+	}
+	for _, r := range results {
+		hosts := append([]string{r.Host}, r.Aliases...)
+		sort.Slice(hosts, func(i, j int) bool {
+			return position[hosts[i]] < position[hosts[j]]
+		})
+		r.Host, r.Aliases, r.index = hosts[0], hosts[1:], position[hosts[0]]
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].index < results[j].index
+	})
+	switch output {
+	case "json":
+		out, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Println(string(out))
+	case "csv":
+		printCSV(results)
+	}
+}
+
+// 查询单个目标，连接或者请求失败时返回空
+func oxidfind(ip string) *hostResult {
+	options := common.ClientOptions{
+		Host: ip,
+		Port: 135,
+	}
+	session, err := DCERPCv5.NewTCPSessionTimeout(options, timeout, debug)
+	if err != nil {
+		if debug {
+			log.Printf("[-] Connect failed [%s]: %s\n", ip, err)
+		}
+		return nil
+	}
+	defer session.Close()
+	if err = session.RpcBindIOXIDResolver(1); err != nil {
+		session.Debug("[-]", err)
+		return nil
+	}
+	_, bindings, err := session.ServerAlive2Request(2)
+	if err != nil {
+		session.Debug("[-]", err)
+		return nil
+	}
+	res := &hostResult{Host: ip}
+	dup := make(map[string]bool)
+	for _, b := range bindings.StringBindings {
+		// 只关心ncacn_ip_tcp绑定中的网络地址
+		if b.TowerId != DCERPCv5.EPM_PROTOCOL_TCP {
+			continue
+		}
+		addr := b.NetworkAddr
+		if addr == "" || dup[addr] {
+			continue
+		}
+		dup[addr] = true
+		t := addressType(addr)
+		if t == addrNetBIOS && res.Hostname == "" {
+			res.Hostname = addr
+		}
+		res.Addresses = append(res.Addresses, address{Address: addr, Type: t})
+	}
+	return res
+}
+
+// 按地址格式分类，ipv6地址可能带有%区域标识
+func addressType(addr string) string {
+	host, _, _ := strings.Cut(addr, "%")
+	parsed := net.ParseIP(host)
+	switch {
+	case parsed == nil:
+		return addrNetBIOS
+	case parsed.To4() != nil:
+		return addrIPv4
+	}
+	return addrIPv6
+}
+
+// 主机名以及排序后的地址集合，都为空时返回空
+func (r *hostResult) key() string {
+	if r.Hostname == "" && len(r.Addresses) == 0 {
+		return ""
+	}
+	addrs := make([]string, 0, len(r.Addresses))
+	for _, a := range r.Addresses {
+		addrs = append(addrs, strings.ToLower(a.Address))
+	}
+	sort.Strings(addrs)
+	return strings.ToLower(r.Hostname) + "|" + strings.Join(addrs, ",")
+}
+
+func printText(r *hostResult) {
+	if r.Hostname != "" {
+		fmt.Printf("[*] %s is alive, hostname: %s\n", r.Host, r.Hostname)
+	} else {
+		fmt.Printf("[*] %s is alive\n", r.Host)
+	}
+	for _, a := range r.Addresses {
+		fmt.Printf("[+] NetworkAddr: %s (%s)\n", a.Address, a.Type)
+	}
+}
+
+// 每个地址一行
+func printCSV(results []*hostResult) {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"host", "hostname", "address", "type", "aliases"})
+	for _, r := range results {
+		aliases := strings.Join(r.Aliases, ";")
+		for _, a := range r.Addresses {
+			w.Write([]string{r.Host, r.Hostname, a.Address, a.Type, aliases})
+		}
+	}
+	w.Flush()
 }
@@ -76,8 +76,8 @@ func main() {
 		serviceName = service
 	}
 	rpc, _ := DCERPCv5.SMBTransport()
-	rpc.Client = *session
+	rpc.Client = session
 	// 创建服务并启动
-	servicename, _, _ := rpc.ServiceInstall(serviceName, file, path)
+	servicename, _ := rpc.ServiceInstall(serviceName, file, path)
 	fmt.Printf("[+] Service name is [%s]\n", servicename)
 }
@@ -4,19 +4,23 @@ import (
 	"flag"
 	"fmt"
 	"github.com/Amzza0x00/go-impacket/pkg"
-	"github.com/Amzza0x00/go-impacket/pkg/common"
 	DCERPCv5 "github.com/Amzza0x00/go-impacket/pkg/dcerpc/v5"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
 	"log"
 )
 
+// 通过epmapper枚举目标注册的rpc端点，按接口分组输出
+
 var (
 	ip    string
+	port  int
 	debug bool
 )
 
 func init() {
 	flag.StringVar(&ip, "ip", "16.16.16.227", "目标ip")
-	flag.BoolVar(&debug, "debug", true, "开启调试信息")
+	flag.IntVar(&port, "port", 135, "epmapper端口")
+	flag.BoolVar(&debug, "debug", false, "开启调试信息")
 	flag.Parse()
 	fmt.Println(pkg.BANNER)
 	if flag.NFlag() < 1 {
@@ -24,24 +28,61 @@ func init() {
 	}
 }
 
+// 同一接口的全部端点
+type endpointGroup struct {
+	uuid       string
+	version    string
+	annotation string
+	bindings   []string
+}
+
 func main() {
-	options := common.ClientOptions{
-		Host: ip,
-		Port: 135,
-	}
-	session, err := DCERPCv5.NewTCPSession(options, debug)
+	rpc, err := DCERPCv5.OpenEpmapper(ip, port, debug)
 	if err != nil {
-		fmt.Printf("[-] Connect failed [%s]: %s\n", ip, err)
+		fmt.Printf("[-] Bind epmapper failed [%s]: %s\n", ip, err)
 		return
 	}
-	rpc, _ := DCERPCv5.TCPTransport()
-	rpc.Client = session.Client
-	err = rpc.RpcBindEpmapper(1)
+	defer rpc.Close()
+	entries, err := rpc.EPMLookupRequest()
 	if err != nil {
-		return
+		fmt.Printf("[-] EPM lookup failed [%s]: %s\n", ip, err)
+		// 已经获取的端点仍然输出
 	}
-	_, err = rpc.EPMLookupRequest(1)
-	if err != nil {
-		return
+	var groups []*endpointGroup
+	index := make(map[string]*endpointGroup)
+	for _, entry := range entries {
+		if entry.Tower == nil {
+			continue
+		}
+		t := entry.Tower
+		version := fmt.Sprintf("v%d.%d", t.VersionMajor, t.VersionMinor)
+		key := t.InterfaceUUID + " " + version
+		group, ok := index[key]
+		if !ok {
+			group = &endpointGroup{uuid: t.InterfaceUUID, version: version}
+			index[key] = group
+			groups = append(groups, group)
+		}
+		if group.annotation == "" {
+			group.annotation = entry.Annotation
+		}
+		group.bindings = append(group.bindings, t.Binding.String())
+	}
+	for _, group := range groups {
+		protocol, ok := ms.KnownInterfaces[group.uuid]
+		if !ok {
+			protocol = "N/A"
+		}
+		fmt.Printf("Protocol: %s\n", protocol)
+		if group.annotation != "" {
+			fmt.Printf("Annotation: %s\n", group.annotation)
+		}
+		fmt.Printf("UUID    : %s %s\n", group.uuid, group.version)
+		fmt.Println("Bindings:")
+		for _, binding := range group.bindings {
+			fmt.Printf("          %s\n", binding)
+		}
+		fmt.Println()
 	}
+	fmt.Printf("[*] Received %d endpoints, %d interfaces\n", len(entries), len(groups))
 }
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Amzza0x00/go-impacket/pkg"
+	"github.com/Amzza0x00/go-impacket/pkg/common"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc"
+	DCERPCv5 "github.com/Amzza0x00/go-impacket/pkg/dcerpc/v5"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// 对指定的字符串绑定逐个绑定常见接口，发现epmapper中没有注册的接口，可选通过fault码探测已实现的opnum
+
+var (
+	binding    string
+	user       string
+	domain     string
+	password   string
+	hash       string
+	authLevel  int
+	bruteOpnum bool
+	opnumMax   int
+	output     string
+	debug      bool
+	// banner以及诊断信息，json格式时输出到stderr，保证stdout只有json
+	info io.Writer = os.Stdout
+)
+
+func init() {
+	flag.StringVar(&binding, "binding", "", "字符串绑定，如ncacn_ip_tcp:16.16.16.227[135]、ncacn_np:16.16.16.227[\\pipe\\srvsvc]")
+	flag.StringVar(&user, "user", "", "用户名")
+	flag.StringVar(&domain, "domain", "", "域名")
+	flag.StringVar(&password, "pass", "", "密码")
+	flag.StringVar(&hash, "hash", "", "哈希")
+	flag.IntVar(&authLevel, "auth-level", DCERPCv5.RPC_C_AUTHN_LEVEL_NONE, "rpc认证级别，1为不认证，2为connect，5为integrity，6为privacy")
+	flag.BoolVar(&bruteOpnum, "brute-opnums", false, "探测已实现的opnum，会使用空参数实际调用接口方法")
+	flag.IntVar(&opnumMax, "opnum-max", 64, "探测的最大opnum")
+	flag.StringVar(&output, "o", "text", "输出格式，text或json")
+	flag.BoolVar(&debug, "debug", false, "开启调试信息")
+	flag.Parse()
+	if output != "text" && output != "json" {
+		log.Fatalln("不支持的输出格式: " + output)
+	}
+	if output == "json" {
+		info = os.Stderr
+	}
+	fmt.Fprintln(info, pkg.BANNER)
+	if binding == "" {
+		log.Fatalln("Usage: rpcmap -binding ncacn_ip_tcp:16.16.16.227[135]")
+	}
+}
+
+// 发现的接口
+type iface struct {
+	UUID     string `json:"uuid"`
+	Version  string `json:"version"`
+	Protocol string `json:"protocol"`
+	Bound    bool   `json:"bound"` // alter_context绑定成功
+	Mgmt     bool   `json:"mgmt"`  // inq_if_ids返回了此接口
+	Opnums   []int  `json:"opnums,omitempty"`
+}
+
+type result struct {
+	Binding    string   `json:"binding"`
+	Mgmt       bool     `json:"mgmt"` // 服务端支持inq_if_ids
+	Interfaces []*iface `json:"interfaces"`
+}
+
+func main() {
+	b, err := DCERPCv5.ParseStringBinding(binding)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// 未注册的接口无法通过epmapper解析端口
+	if b.ProtocolSequence == DCERPCv5.ProtSeqTCP && b.Endpoint == "" {
+		log.Fatalln("ncacn_ip_tcp需要指定端口")
+	}
+	opt := common.ClientOptions{
+		User:     user,
+		Domain:   domain,
+		Password: password,
+		Hash:     hash,
+	}
+	transport, err := DCERPCv5.NewTransport(binding, opt, debug)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err = transport.Connect(); err != nil {
+		log.Fatalln(err)
+	}
+	defer transport.Close()
+	rpc := DCERPCv5.NewRPCClient(transport, debug)
+	if err = rpc.SetAuth(opt, uint8(authLevel)); err != nil {
+		log.Fatalln(err)
+	}
+	res, err := rpcmap(rpc, b.String())
+	if err != nil {
+		fmt.Fprintf(info, "[-] %s\n", err)
+		// 已经发现的接口仍然输出
+	}
+	if output == "json" {
+		out, _ := json.MarshalIndent(res, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	printText(res)
+}
+
+// 先绑定mgmt查询注册的接口，再通过alter_context逐个绑定常见接口以及mgmt返回的接口
+func rpcmap(rpc *DCERPCv5.RPCClient, binding string) (*result, error) {
+	res := &result{Binding: binding}
+	var found []*iface
+	index := make(map[string]*iface)
+	add := func(uuid string, version uint32) *iface {
+		key := fmt.Sprintf("%s v%d.%d", uuid, uint16(version), uint16(version>>16))
+		if i, ok := index[key]; ok {
+			return i
+		}
+		protocol, ok := ms.KnownInterfaces[uuid]
+		if !ok {
+			protocol = "N/A"
+		}
+		i := &iface{UUID: uuid, Version: key[len(uuid)+1:], Protocol: protocol}
+		index[key] = i
+		found = append(found, i)
+		return i
+	}
+	var candidates []ms.KnownInterface
+	var rejected *DCERPCv5.ContextRejectedError
+	// 第一次绑定建立关联，mgmt被拒绝时关联仍然可用
+	err := rpc.Bind(ms.MGMT_UUID, ms.MGMT_VERSION)
+	switch {
+	case err == nil:
+		ids, err := rpc.InqIfIds()
+		if err != nil {
+			fmt.Fprintf(info, "[-] inq_if_ids failed: %s\n", err)
+			break
+		}
+		res.Mgmt = true
+		for _, id := range ids {
+			add(id.UUID, id.Version).Mgmt = true
+			candidates = append(candidates, ms.KnownInterface{UUID: id.UUID, Version: id.Version})
+		}
+	case errors.As(err, &rejected):
+	default:
+		return res, err
+	}
+	candidates = append(candidates, ms.KnownInterfaceList...)
+	for _, c := range candidates {
+		i := add(c.UUID, c.Version)
+		if i.Bound {
+			continue
+		}
+		alt, err := rpc.AlterContext(c.UUID, c.Version)
+		if errors.As(err, &rejected) {
+			continue
+		}
+		if err != nil {
+			return collect(res, found), err
+		}
+		i.Bound = true
+		if bruteOpnum {
+			if i.Opnums, err = probeOpnums(alt); err != nil {
+				return collect(res, found), err
+			}
+		}
+	}
+	return collect(res, found), nil
+}
+
+// 只保留绑定成功或者mgmt返回的接口
+func collect(res *result, found []*iface) *result {
+	for _, i := range found {
+		if i.Bound || i.Mgmt {
+			res.Interfaces = append(res.Interfaces, i)
+		}
+	}
+	return res
+}
+
+// 使用空参数调用每个opnum，nca_s_op_rng_error表示opnum不存在，其他fault或者正常响应表示已实现
+func probeOpnums(rpc *DCERPCv5.RPCClient) ([]int, error) {
+	var opnums []int
+	var fault *DCERPCv5.FaultError
+	for op := 0; op <= opnumMax; op++ {
+		_, err := rpc.Call(uint16(op), nil)
+		if errors.As(err, &fault) {
+			if fault.Status == dcerpc.NCA_S_OP_RNG_ERROR {
+				continue
+			}
+		} else if err != nil {
+			return opnums, err
+		}
+		opnums = append(opnums, op)
+	}
+	return opnums, nil
+}
+
+func printText(res *result) {
+	if res.Mgmt {
+		fmt.Println("[*] MGMT inq_if_ids supported")
+	}
+	for _, i := range res.Interfaces {
+		fmt.Printf("Protocol: %s\n", i.Protocol)
+		fmt.Printf("UUID    : %s %s\n", i.UUID, i.Version)
+		var source []string
+		if i.Bound {
+			source = append(source, "bind")
+		}
+		if i.Mgmt {
+			source = append(source, "mgmt")
+		}
+		fmt.Printf("Found by: %s\n", strings.Join(source, ", "))
+		if bruteOpnum && i.Bound {
+			fmt.Printf("Opnums  : %s\n", opnumRanges(i.Opnums))
+		}
+		fmt.Println()
+	}
+	fmt.Printf("[*] Found %d interfaces on %s\n", len(res.Interfaces), res.Binding)
+}
+
+// 连续的opnum合并输出，如0-5, 7
+func opnumRanges(opnums []int) string {
+	if len(opnums) == 0 {
+		return "none"
+	}
+	var parts []string
+	for i := 0; i < len(opnums); {
+		j := i
+		for j+1 < len(opnums) && opnums[j+1] == opnums[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprintf("%d", opnums[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", opnums[i], opnums[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Amzza0x00/go-impacket/pkg"
+	"github.com/Amzza0x00/go-impacket/pkg/common"
+	DCERPCv5 "github.com/Amzza0x00/go-impacket/pkg/dcerpc/v5"
+	"github.com/Amzza0x00/go-impacket/pkg/smb/smb2"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 交互式smb客户端，用于浏览共享目录以及上传/下载文件
+
+var (
+	user     string
+	domain   string
+	password string
+	hash     string
+	target   string
+	port     int
+	share    string
+	debug    bool
+)
+
+// 共享枚举失败时依次尝试的常见共享
+var commonShares = []string{"ADMIN$", "C$", "D$", "IPC$", "NETLOGON", "SYSVOL", "print$", "Users"}
+
+func init() {
+	flag.StringVar(&user, "user", "", "用户名")
+	flag.StringVar(&domain, "domain", "", "域名")
+	flag.StringVar(&password, "pass", "", "密码")
+	flag.StringVar(&hash, "hash", "", "哈希")
+	flag.StringVar(&target, "target", "", "目标地址")
+	flag.IntVar(&port, "port", 445, "目标端口")
+	flag.StringVar(&share, "share", "", "登录后直接连接的共享")
+	flag.BoolVar(&debug, "debug", false, "开启调试信息")
+	flag.Parse()
+	fmt.Println(pkg.BANNER)
+	if flag.NFlag() < 3 {
+		log.Fatalln("Usage: smbclient -target 172.20.10.2 -user administrator -hash 32ed87bdb5fdc5e9cba88547376818d4")
+	}
+	if target == "" {
+		log.Fatalln("目标地址为空")
+	}
+}
+
+type shell struct {
+	session *smb2.Client
+	share   *smb2.Share
+	cwd     string //当前目录，io/fs路径格式，共享根目录为.
+}
+
+func main() {
+	options := common.ClientOptions{
+		Host:     target,
+		Port:     port,
+		Domain:   domain,
+		User:     user,
+		Password: password,
+		Hash:     hash,
+	}
+	session, err := smb2.NewSession(options, debug)
+	if err != nil {
+		fmt.Printf("[-] Login failed [%s]: %s\n", target, err)
+		os.Exit(0)
+	}
+	defer session.Close()
+	if session.IsAuthenticated {
+		fmt.Printf("[+] Login successful [%s]\n", target)
+	}
+	sh := &shell{session: session, cwd: "."}
+	if share != "" {
+		if err = sh.use([]string{share}); err != nil {
+			fmt.Printf("[-] %s\n", err)
+		}
+	}
+	fmt.Println("Type help for list of commands")
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print(sh.prompt())
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		args := splitArgs(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			return
+		}
+		if err = sh.run(args[0], args[1:]); err != nil {
+			fmt.Printf("[-] %s\n", err)
+		}
+	}
+}
+
+func (sh *shell) prompt() string {
+	if sh.share == nil {
+		return "# "
+	}
+	dir := ""
+	if sh.cwd != "." {
+		dir = strings.ReplaceAll(sh.cwd, "/", "\\")
+	}
+	return sh.share.GetName() + "\\" + dir + "> "
+}
+
+func (sh *shell) run(cmd string, args []string) error {
+	switch cmd {
+	case "help", "?":
+		sh.help()
+		return nil
+	case "shares":
+		return sh.shares()
+	case "use":
+		return sh.use(args)
+	}
+	// 以下命令需要先连接共享
+	if sh.share == nil {
+		return errors.New("No share selected, use <share> first")
+	}
+	switch cmd {
+	case "ls", "dir":
+		return sh.ls(args)
+	case "cd":
+		return sh.cd(args)
+	case "pwd":
+		fmt.Println("\\" + strings.ReplaceAll(strings.TrimPrefix(sh.cwd, "."), "/", "\\"))
+		return nil
+	case "get":
+		return sh.get(args)
+	case "put":
+		return sh.put(args)
+	case "mget":
+		return sh.mget(args)
+	case "rm", "del":
+		return sh.each(args, sh.share.Remove)
+	case "mkdir":
+		return sh.each(args, sh.share.Mkdir)
+	case "rmdir":
+		return sh.each(args, sh.share.Rmdir)
+	case "cat":
+		return sh.cat(args)
+	case "info":
+		return sh.info(args)
+	}
+	return errors.New("Unknown command: " + cmd)
+}
+
+func (sh *shell) help() {
+	fmt.Println(` shares                     列出可以访问的共享
+ use {share}                连接共享
+ ls [path]                  列出目录
+ cd {path}                  切换目录
+ pwd                        显示当前目录
+ get {file} [local]         下载文件
+ put {local} [file]         上传文件
+ mget {pattern}             下载当前目录下所有匹配的文件
+ rm {file}                  删除文件
+ mkdir {dir}                创建目录
+ rmdir {dir}                删除空目录
+ cat {file}                 显示文件内容
+ info {path}                显示文件信息
+ exit                       退出`)
+}
+
+// 通过srvsvc枚举共享，失败时依次尝试连接常见共享
+func (sh *shell) shares() error {
+	rpc := &DCERPCv5.SMBClient{Client: sh.session}
+	shares, err := rpc.NetrShareEnum(1)
+	if err == nil {
+		for _, s := range shares {
+			fmt.Printf("%-16s %-8s %s\n", s.Name, s.TypeName(), s.Remark)
+		}
+		return nil
+	}
+	fmt.Printf("[-] NetrShareEnum failed: %s\n", err)
+	for _, name := range commonShares {
+		// 当前正在使用的共享不需要重复连接
+		if sh.share != nil && strings.EqualFold(sh.share.GetName(), name) {
+			fmt.Println(sh.share.GetName())
+			continue
+		}
+		treeId, err := sh.session.TreeConnect(name)
+		if err != nil {
+			continue
+		}
+		fmt.Println(name)
+		sh.session.TreeDisconnectId(treeId)
+	}
+	return nil
+}
+
+func (sh *shell) use(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: use <share>")
+	}
+	s, err := sh.session.TreeConnectShare(args[0])
+	if err != nil {
+		return err
+	}
+	// 重新连接同一个共享时也要断开旧的树连接
+	if sh.share != nil {
+		sh.share.Close()
+	}
+	sh.share = s
+	sh.cwd = "."
+	return nil
+}
+
+// 将用户输入的路径转换为共享内的绝对路径，支持\以及/分隔符
+func (sh *shell) resolve(p string) string {
+	p = strings.ReplaceAll(p, "\\", "/")
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join(sh.cwd, p)
+	}
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func (sh *shell) ls(args []string) error {
+	dir := sh.cwd
+	if len(args) > 0 {
+		dir = sh.resolve(args[0])
+	}
+	entries, err := sh.share.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		attr := "A"
+		if info.IsDir() {
+			attr = "D"
+		}
+		fmt.Printf("%s %12d  %s  %s\n", attr, info.Size(), info.ModTime().Local().Format("2006-01-02 15:04:05"), info.Name())
+	}
+	return nil
+}
+
+func (sh *shell) cd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: cd <path>")
+	}
+	dir := sh.resolve(args[0])
+	info, err := sh.share.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(args[0] + " is not a directory")
+	}
+	sh.cwd = dir
+	return nil
+}
+
+// 下载远程文件到本地
+func (sh *shell) download(remote, local string) error {
+	f, err := sh.share.OpenFile(remote, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	out, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	n, err := sh.session.ReadFile(sh.share.GetTreeId(), f.GetFileId(), out)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[+] Downloaded %s (%d bytes)\n", local, n)
+	return nil
+}
+
+func (sh *shell) get(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("Usage: get <file> [local]")
+	}
+	remote := sh.resolve(args[0])
+	local := path.Base(remote)
+	if len(args) == 2 {
+		local = args[1]
+	}
+	return sh.download(remote, local)
+}
+
+func (sh *shell) put(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("Usage: put <local> [file]")
+	}
+	in, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	remote := sh.resolve(filepath.Base(args[0]))
+	if len(args) == 2 {
+		remote = sh.resolve(args[1])
+	}
+	f, err := sh.share.Create(remote)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := sh.session.WriteFile(sh.share.GetTreeId(), f.GetFileId(), in)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[+] Uploaded %s (%d bytes)\n", remote, n)
+	return nil
+}
+
+// 下载当前目录下匹配的文件，不包含子目录
+func (sh *shell) mget(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: mget <pattern>")
+	}
+	if _, err := path.Match(args[0], ""); err != nil {
+		return err
+	}
+	entries, err := sh.share.ReadDir(sh.cwd)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if ok, _ := path.Match(args[0], entry.Name()); !ok {
+			continue
+		}
+		if err = sh.download(path.Join(sh.cwd, entry.Name()), entry.Name()); err != nil {
+			fmt.Printf("[-] %s\n", err)
+		}
+	}
+	return nil
+}
+
+func (sh *shell) each(args []string, fn func(string) error) error {
+	if len(args) == 0 {
+		return errors.New("Missing path")
+	}
+	for _, arg := range args {
+		if err := fn(sh.resolve(arg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sh *shell) cat(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: cat <file>")
+	}
+	f, err := sh.share.OpenFile(sh.resolve(args[0]), os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = sh.session.ReadFile(sh.share.GetTreeId(), f.GetFileId(), os.Stdout)
+	return err
+}
+
+func (sh *shell) info(args []string) error {
+	p := sh.cwd
+	if len(args) > 0 {
+		p = sh.resolve(args[0])
+	}
+	info, err := sh.share.Stat(p)
+	if err != nil {
+		return err
+	}
+	fi := info.Sys().(*smb2.FileInfo)
+	fmt.Printf("Name:           %s\n", p)
+	fmt.Printf("Size:           %d\n", fi.EndOfFile)
+	fmt.Printf("AllocationSize: %d\n", fi.AllocationSize)
+	fmt.Printf("Attributes:     0x%08x\n", fi.FileAttributes)
+	fmt.Printf("Mode:           %s\n", fi.Mode())
+	fmt.Printf("CreationTime:   %s\n", fi.CreationTime.Local())
+	fmt.Printf("LastAccessTime: %s\n", fi.LastAccessTime.Local())
+	fmt.Printf("LastWriteTime:  %s\n", fi.LastWriteTime.Local())
+	fmt.Printf("ChangeTime:     %s\n", fi.ChangeTime.Local())
+	return nil
+}
+
+// 按空白分割命令行参数，双引号内的空白不分割
+func splitArgs(line string) []string {
+	var args []string
+	var cur strings.Builder
+	quoted, inArg := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args
+}
@@ -5,14 +5,19 @@ package common
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha512"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"github.com/Amzza0x00/go-impacket/pkg/smb"
 	"io"
 	"log"
 	"net"
 	"runtime/debug"
+	"sync"
 	"time"
 )
 
@@ -22,14 +27,32 @@ type Client struct {
 	IsAuthenticated   bool
 	debug             bool
 	securityMode      uint16
-	messageId         uint64
 	sessionId         uint64
 	conn              net.Conn
 	dialect           uint16
 	options           *ClientOptions
-	trees             map[string]uint32
+	sessionKey        []byte // 认证后得到的会话密钥
+	signingKey        []byte // 由会话密钥派生的签名密钥
+	signingAlgorithm  uint16
+	preauthHash       []byte // smb3.1.1预认证完整性哈希
+	cipherId          uint16 // 协商的加密算法，0表示不支持加密
+	encryptionKey     []byte // 客户端到服务端加密密钥
+	decryptionKey     []byte // 服务端到客户端解密密钥
+	encryptSession    bool
+	multiCredit       bool // 是否支持多信用请求
+	maxTransactSize   uint32
+	maxReadSize       uint32
+	maxWriteSize      uint32
+	timeout           time.Duration // tcp读取超时时间
+	state             *connState
 }
 
+// 每个信用对应的负载大小
+const CreditPayloadSize = 65536
+
+// 单次读写请求的最大负载，多信用请求受服务端MaxReadSize/MaxWriteSize限制
+const MaxChunkSize = 8 * 1024 * 1024
+
 // 连接参数
 type ClientOptions struct {
 	Host        string
@@ -39,6 +62,10 @@ type ClientOptions struct {
 	User        string
 	Password    string
 	Hash        string
+	// 要求整个会话加密，服务端不支持时会话建立失败
+	RequireEncryption bool
+	// 要求加密的共享名，连接这些共享后对应树的请求都会加密
+	EncryptShares []string
 }
 
 func (c *Client) Debug(msg string, err error) {
@@ -51,50 +78,566 @@ func (c *Client) Debug(msg string, err error) {
 }
 
 func (c *Client) SMBSend(req interface{}) (res []byte, err error) {
-	buf, err := encoder.Marshal(req)
+	call, err := c.SMBPost(req)
 	if err != nil {
-		c.Debug("", err)
 		return nil, err
 	}
-	b := new(bytes.Buffer)
-	if err = binary.Write(b, binary.BigEndian, uint32(len(buf))); err != nil {
+	return call.Wait()
+}
+
+// 等待响应的请求
+type Call struct {
+	MessageId uint64
+	data      []byte
+	err       error
+	done      chan struct{}
+}
+
+// 等待最终响应，异步请求的中间响应不会返回
+func (call *Call) Wait() ([]byte, error) {
+	<-call.done
+	return call.data, call.err
+}
+
+// 收到最终响应后关闭
+func (call *Call) Done() <-chan struct{} {
+	return call.done
+}
+
+func (call *Call) finish(data []byte, err error) {
+	call.data = data
+	call.err = err
+	close(call.done)
+}
+
+// 发送请求但不等待响应，MessageId由客户端统一分配，可以在多个goroutine中并发调用
+func (c *Client) SMBPost(req interface{}) (*Call, error) {
+	calls, err := c.SMBPostCompound([]interface{}{req}, false)
+	if err != nil {
+		return nil, err
+	}
+	return calls[0], nil
+}
+
+// 发送复合请求并等待所有响应，返回每个请求对应的响应
+func (c *Client) SMBSendCompound(reqs []interface{}, related bool) ([][]byte, error) {
+	calls, err := c.SMBPostCompound(reqs, related)
+	if err != nil {
+		return nil, err
+	}
+	res := make([][]byte, len(calls))
+	for i, call := range calls {
+		if res[i], err = call.Wait(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// 将多个请求通过NextCommand串联后一次发送，每个请求需要8字节对齐
+// related为true时后续请求设置SMB2_FLAGS_RELATED_OPERATIONS，沿用前一个请求的SessionId、TreeId以及FileId
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/46dd4182-62d3-4e30-9fe5-e2ec124edca1
+func (c *Client) SMBPostCompound(reqs []interface{}, related bool) ([]*Call, error) {
+	if len(reqs) == 0 {
+		return nil, errors.New("Empty compound request")
+	}
+	if c.state == nil {
+		return nil, errors.New("Client is not connected")
+	}
+	msgs := make([][]byte, len(reqs))
+	for i, req := range reqs {
+		buf, err := encoder.Marshal(req)
+		if err != nil {
+			c.Debug("", err)
+			return nil, err
+		}
+		if len(buf) < smb.SMB2HeaderSize {
+			return nil, errors.New("Message too short")
+		}
+		if i < len(reqs)-1 {
+			buf = append(buf, make([]byte, (8-len(buf)%8)%8)...)
+			binary.LittleEndian.PutUint32(buf[smb.SMB2NextCommandOffset:], uint32(len(buf)))
+		}
+		if related && i > 0 {
+			flags := binary.LittleEndian.Uint32(buf[smb.SMB2FlagsOffset:])
+			binary.LittleEndian.PutUint32(buf[smb.SMB2FlagsOffset:], flags|smb.SMB2_FLAGS_RELATED_OPERATIONS)
+		}
+		msgs[i] = buf
+	}
+	st := c.state
+	st.mu.Lock()
+	st.startReader(c)
+	calls := make([]*Call, len(msgs))
+	var consumed uint16
+	for i, buf := range msgs {
+		charge, err := c.consumeCredits(buf)
+		if err != nil {
+			// 归还已经扣除的信用
+			st.credits += consumed
+			st.mu.Unlock()
+			c.Debug("", err)
+			return nil, err
+		}
+		consumed += charge
+		// 多信用请求占用连续的MessageId
+		calls[i] = &Call{MessageId: st.messageId, done: make(chan struct{})}
+		binary.LittleEndian.PutUint64(buf[smb.SMB2MessageIdOffset:], st.messageId)
+		st.messageId += uint64(charge)
+	}
+	buf, err := c.sealCompound(msgs)
+	if err != nil {
+		st.mu.Unlock()
 		c.Debug("", err)
-		return
+		return nil, err
 	}
-	c.Debug("Raw:\n"+hex.Dump(append(b.Bytes(), buf...)), nil)
-	rw := bufio.NewReadWriter(bufio.NewReader(c.conn), bufio.NewWriter(c.conn))
-	if _, err = rw.Write(append(b.Bytes(), buf...)); err != nil {
+	for _, call := range calls {
+		st.pending[call.MessageId] = call
+	}
+	st.mu.Unlock()
+
+	if err = c.writeMessage(buf); err != nil {
 		c.Debug("", err)
-		return
+		st.mu.Lock()
+		for _, call := range calls {
+			delete(st.pending, call.MessageId)
+		}
+		st.mu.Unlock()
+		return nil, err
 	}
-	rw.Flush()
-	var size uint32
-	if err = binary.Read(rw, binary.BigEndian, &size); err != nil {
+	return calls, nil
+}
+
+// 复合请求整体加密，或者对每个请求单独签名
+func (c *Client) sealCompound(msgs [][]byte) ([]byte, error) {
+	if len(msgs) == 1 {
+		return c.sealRequest(msgs[0])
+	}
+	buf := bytes.Join(msgs, nil)
+	if c.shouldEncrypt(msgs[0]) {
+		c.Debug("Raw:\n"+hex.Dump(buf), nil)
+		sessionId := binary.LittleEndian.Uint64(msgs[0][smb.SMB2SessionIdOffset:])
+		return smb.EncryptMessage(c.cipherId, c.encryptionKey, sessionId, buf)
+	}
+	o := 0
+	for _, msg := range msgs {
+		// 签名覆盖包含填充在内的整个请求
+		if err := c.signRequest(buf[o : o+len(msg)]); err != nil {
+			return nil, err
+		}
+		o += len(msg)
+	}
+	return buf, nil
+}
+
+// 按NextCommand拆分复合响应
+func splitCompound(buf []byte) ([][]byte, error) {
+	var msgs [][]byte
+	for {
+		if len(buf) < smb.SMB2HeaderSize {
+			return nil, errors.New("Message size invalid")
+		}
+		next := binary.LittleEndian.Uint32(buf[smb.SMB2NextCommandOffset:])
+		if next == 0 {
+			return append(msgs, buf), nil
+		}
+		if next < smb.SMB2HeaderSize || uint64(next) > uint64(len(buf)) {
+			return nil, errors.New("Invalid compound response")
+		}
+		msgs = append(msgs, buf[:next])
+		buf = buf[next:]
+	}
+}
+
+// 发送不需要响应的请求，如CANCEL，不消耗信用也不占用新的MessageId
+func (c *Client) SMBSendOnly(req interface{}) error {
+	buf, err := encoder.Marshal(req)
+	if err != nil {
 		c.Debug("", err)
-		return
+		return err
 	}
-	if size > 0x00FFFFFF {
-		return nil, errors.New("Invalid NetBIOS Session message")
+	if c.state == nil {
+		return errors.New("Client is not connected")
 	}
-	data := make([]byte, size)
-	l, err := io.ReadFull(rw, data)
+	c.state.mu.Lock()
+	buf, err = c.sealRequest(buf)
+	c.state.mu.Unlock()
 	if err != nil {
 		c.Debug("", err)
+		return err
+	}
+	return c.writeMessage(buf)
+}
+
+// 对请求进行加密或签名
+func (c *Client) sealRequest(buf []byte) ([]byte, error) {
+	if c.shouldEncrypt(buf) {
+		c.Debug("Raw:\n"+hex.Dump(buf), nil)
+		sessionId := binary.LittleEndian.Uint64(buf[smb.SMB2SessionIdOffset:])
+		return smb.EncryptMessage(c.cipherId, c.encryptionKey, sessionId, buf)
+	}
+	if err := c.signRequest(buf); err != nil {
 		return nil, err
 	}
-	if uint32(l) != size {
-		return nil, errors.New("Message size invalid")
+	c.updatePreauthHash(buf)
+	return buf, nil
+}
+
+// 添加NetBIOS会话头后发送消息
+func (c *Client) writeMessage(buf []byte) error {
+	b := new(bytes.Buffer)
+	if err := binary.Write(b, binary.BigEndian, uint32(len(buf))); err != nil {
+		return err
 	}
+	b.Write(buf)
+	c.Debug("Raw:\n"+hex.Dump(b.Bytes()), nil)
+	c.state.writeMu.Lock()
+	defer c.state.writeMu.Unlock()
+	_, err := c.state.conn.Write(b.Bytes())
+	return err
+}
+
+// 连接级别的共享状态，Client按值复制后仍然指向同一连接状态
+type connState struct {
+	conn           net.Conn
+	writeMu        sync.Mutex // 保证消息完整写入连接
+	mu             sync.Mutex // 保护以下字段以及会话密钥等状态的读取
+	cond           *sync.Cond // 信用不足时等待服务端授予
+	started        bool
+	err            error // 读取协程退出的原因，之后的请求都会失败
+	messageId      uint64
+	credits        uint16            // 服务端授予的可用信用数
+	pending        map[uint64]*Call  // 等待响应的请求
+	asyncIds       map[uint64]uint64 // 等待中的异步请求，MessageId到AsyncId
+	trees          map[string]uint32
+	encryptedTrees map[uint32]bool
+}
+
+func newConnState(conn net.Conn) *connState {
+	st := &connState{
+		conn: conn,
+		// 协商请求前服务端默认授予1个信用
+		credits:        1,
+		pending:        make(map[uint64]*Call),
+		asyncIds:       make(map[uint64]uint64),
+		trees:          make(map[string]uint32),
+		encryptedTrees: make(map[uint32]bool),
+	}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+// 首次发送smb请求时启动后台读取协程，调用方需持有mu
+func (st *connState) startReader(c *Client) {
+	if st.started {
+		return
+	}
+	st.started = true
+	go st.readLoop(c)
+}
+
+// 后台读取协程，按MessageId将响应分发给等待的请求
+func (st *connState) readLoop(c *Client) {
+	for {
+		data, err := st.readFrame()
+		if err != nil {
+			st.fail(err)
+			return
+		}
+		st.mu.Lock()
+		encrypted := smb.IsTransformMessage(data)
+		if encrypted {
+			data, err = c.decryptResponse(data)
+		}
+		var msgs [][]byte
+		if err == nil {
+			msgs, err = splitCompound(data)
+		}
+		if err != nil {
+			// 无法解密或解析时不能确定对应的请求
+			st.mu.Unlock()
+			c.Debug("", err)
+			st.fail(err)
+			return
+		}
+		for _, msg := range msgs {
+			var verr error
+			if !encrypted {
+				// 加密的响应不再单独签名
+				c.updatePreauthHash(msg)
+				if verr = c.VerifyResponse(msg); verr != nil {
+					c.Debug("Raw:\n"+hex.Dump(msg), verr)
+				}
+			}
+			st.dispatch(c, msg, verr)
+		}
+		st.mu.Unlock()
+	}
+}
+
+// 分发响应，调用方需持有mu
+func (st *connState) dispatch(c *Client, data []byte, err error) {
 	//protID := data[0:4]
 	//switch string(protID) {
 	//default:
 	//	return nil, errors.New("Protocol Not Implemented")
 	//case ProtocolSMB:
 	//}
-	c.messageId++
+	messageId := binary.LittleEndian.Uint64(data[smb.SMB2MessageIdOffset:])
+	if messageId == smb.SMB2UnsolicitedMessageId {
+		// 服务端主动发送的oplock/lease中断通知，忽略
+		c.Debug("Ignoring unsolicited notification", nil)
+		return
+	}
+	call, ok := st.pending[messageId]
+	if !ok {
+		c.Debug(fmt.Sprintf("Ignoring response for unknown MessageId %d", messageId), nil)
+		return
+	}
+	st.grantCredits(data)
+	if err == nil && isInterimResponse(data) {
+		// 异步中间响应，记录AsyncId后继续等待最终响应
+		asyncId := binary.LittleEndian.Uint64(data[smb.SMB2AsyncIdOffset:])
+		c.Debug(fmt.Sprintf("Received interim response, AsyncId 0x%x", asyncId), nil)
+		st.asyncIds[messageId] = asyncId
+		return
+	}
+	delete(st.pending, messageId)
+	delete(st.asyncIds, messageId)
+	if err != nil {
+		call.finish(nil, err)
+		return
+	}
+	call.finish(data, nil)
+}
+
+// 连接出错时结束所有等待中的请求
+func (st *connState) fail(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.err = err
+	for messageId, call := range st.pending {
+		call.finish(nil, err)
+		delete(st.pending, messageId)
+	}
+	st.cond.Broadcast()
+}
+
+// 读取一个NetBIOS会话消息
+func (st *connState) readFrame() ([]byte, error) {
+	var size uint32
+	if err := binary.Read(st.conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > 0x00FFFFFF {
+		return nil, errors.New("Invalid NetBIOS Session message")
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(st.conn, data); err != nil {
+		return nil, err
+	}
 	return data, nil
 }
 
+// 解密响应
+func (c *Client) decryptResponse(data []byte) ([]byte, error) {
+	if c.decryptionKey == nil {
+		return nil, errors.New("Received encrypted message without a decryption key")
+	}
+	data, err := smb.DecryptMessage(c.cipherId, c.decryptionKey, data)
+	if err != nil {
+		return nil, err
+	}
+	c.Debug("Decrypted:\n"+hex.Dump(data), nil)
+	return data, nil
+}
+
+// 判断是否为异步操作的中间响应
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/8a1ddb6c-7c49-4f56-b5ac-95f1d4ad6dab
+func isInterimResponse(buf []byte) bool {
+	flags := binary.LittleEndian.Uint32(buf[smb.SMB2FlagsOffset:])
+	status := binary.LittleEndian.Uint32(buf[smb.SMB2StatusOffset:])
+	return flags&smb.SMB2_FLAGS_ASYNC_COMMAND != 0 && status == ms.STATUS_PENDING
+}
+
+// 获取正在等待的异步请求的AsyncId，服务端尚未返回中间响应时返回false
+func (c *Client) GetAsyncId(messageId uint64) (uint64, bool) {
+	if c.state == nil {
+		return 0, false
+	}
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	asyncId, ok := c.state.asyncIds[messageId]
+	return asyncId, ok
+}
+
+// 扣除请求消耗的信用，返回请求占用的MessageId数量
+// 信用不足时等待其他请求的响应授予信用，调用方需持有mu
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/dc517c41-646c-4bc9-8ed8-7b2c7ee8c5e8
+func (c *Client) consumeCredits(buf []byte) (uint16, error) {
+	st := c.state
+	charge := binary.LittleEndian.Uint16(buf[smb.SMB2CreditChargeOffset:])
+	if c.dialect == smb.SMB2_0_2_Dialect {
+		// smb2.0.2不支持多信用请求，CreditCharge必须为0
+		binary.LittleEndian.PutUint16(buf[smb.SMB2CreditChargeOffset:], 0)
+		charge = 1
+	} else if charge == 0 {
+		charge = 1
+	}
+	for charge > st.credits {
+		if st.err != nil {
+			return 0, st.err
+		}
+		// 没有等待中的请求时不会再有信用授予
+		if len(st.pending) == 0 {
+			return 0, errors.New("Not enough credits for request")
+		}
+		st.cond.Wait()
+	}
+	if st.err != nil {
+		return 0, st.err
+	}
+	// 请求的信用数不能小于本次消耗
+	if binary.LittleEndian.Uint16(buf[smb.SMB2CreditRequestOffset:]) < charge {
+		binary.LittleEndian.PutUint16(buf[smb.SMB2CreditRequestOffset:], charge)
+	}
+	st.credits -= charge
+	return charge, nil
+}
+
+// 记录响应中服务端授予的信用，调用方需持有mu
+func (st *connState) grantCredits(buf []byte) {
+	granted := binary.LittleEndian.Uint16(buf[smb.SMB2CreditRequestOffset:])
+	if uint32(st.credits)+uint32(granted) > 0xFFFF {
+		st.credits = 0xFFFF
+	} else {
+		st.credits += granted
+	}
+	st.cond.Broadcast()
+}
+
+// 计算指定负载大小的请求需要消耗的信用数
+func (c *Client) CreditCharge(size uint32) uint16 {
+	if !c.multiCredit || size == 0 {
+		return 1
+	}
+	return uint16((size-1)/CreditPayloadSize + 1)
+}
+
+// 单次读请求的最大长度
+func (c *Client) MaxReadChunk() uint32 {
+	return c.chunkSize(c.maxReadSize)
+}
+
+// 单次QUERY_DIRECTORY/QUERY_INFO请求的最大输出长度
+func (c *Client) MaxTransactChunk() uint32 {
+	return c.chunkSize(c.maxTransactSize)
+}
+
+// 单次写请求的最大长度
+func (c *Client) MaxWriteChunk() uint32 {
+	return c.chunkSize(c.maxWriteSize)
+}
+
+func (c *Client) chunkSize(max uint32) uint32 {
+	size := uint32(CreditPayloadSize)
+	if c.multiCredit {
+		size = MaxChunkSize
+		// 受当前可用信用数限制
+		if credits := c.GetCredits(); credits > 0 && uint32(credits)*CreditPayloadSize < size {
+			size = uint32(credits) * CreditPayloadSize
+		}
+	}
+	if max != 0 && max < size {
+		size = max
+	}
+	return size
+}
+
+// 会话开启签名后，对携带SessionId的请求进行签名
+// smb3.1.1的TREE_CONNECT请求无论是否要求签名都必须签名
+func (c *Client) signRequest(buf []byte) error {
+	if c.signingKey == nil || len(buf) < smb.SMB2HeaderSize {
+		return nil
+	}
+	if binary.LittleEndian.Uint64(buf[smb.SMB2SessionIdOffset:]) == 0 {
+		return nil
+	}
+	command := binary.LittleEndian.Uint16(buf[smb.SMB2CommandOffset:])
+	if !c.IsSigningRequired && !(c.dialect == smb.SMB3_1_1_Dialect && command == smb.SMB2_TREE_CONNECT) {
+		return nil
+	}
+	return smb.SignMessage(c.signingAlgorithm, c.signingKey, buf)
+}
+
+// 判断请求是否需要加密，会话建立完成后按会话或树连接的加密要求处理
+func (c *Client) shouldEncrypt(buf []byte) bool {
+	if c.encryptionKey == nil || len(buf) < smb.SMB2HeaderSize {
+		return false
+	}
+	if binary.LittleEndian.Uint64(buf[smb.SMB2SessionIdOffset:]) == 0 {
+		return false
+	}
+	switch binary.LittleEndian.Uint16(buf[smb.SMB2CommandOffset:]) {
+	case smb.SMB2_NEGOTIATE, smb.SMB2_SESSION_SETUP:
+		return false
+	}
+	if c.encryptSession {
+		return true
+	}
+	// 异步请求头中没有TreeId，存在加密的树连接时一律加密
+	if binary.LittleEndian.Uint32(buf[smb.SMB2FlagsOffset:])&smb.SMB2_FLAGS_ASYNC_COMMAND != 0 {
+		return len(c.state.encryptedTrees) > 0
+	}
+	return c.state.encryptedTrees[binary.LittleEndian.Uint32(buf[smb.SMB2TreeIdOffset:])]
+}
+
+// 计算smb3.1.1预认证完整性哈希
+// 覆盖协商请求/响应以及会话建立过程中除最后一个成功响应之外的所有消息
+func (c *Client) updatePreauthHash(buf []byte) {
+	if len(buf) < smb.SMB2HeaderSize || (c.dialect != 0 && c.dialect != smb.SMB3_1_1_Dialect) {
+		return
+	}
+	isResponse := binary.LittleEndian.Uint32(buf[smb.SMB2FlagsOffset:])&smb.SMB2_FLAGS_SERVER_TO_REDIR != 0
+	switch binary.LittleEndian.Uint16(buf[smb.SMB2CommandOffset:]) {
+	case smb.SMB2_NEGOTIATE:
+		if !isResponse {
+			c.preauthHash = make([]byte, sha512.Size)
+		}
+	case smb.SMB2_SESSION_SETUP:
+		if isResponse && binary.LittleEndian.Uint32(buf[smb.SMB2StatusOffset:]) != ms.STATUS_MORE_PROCESSING_REQUIRED {
+			return
+		}
+	default:
+		return
+	}
+	h := sha512.New()
+	h.Write(c.preauthHash)
+	h.Write(buf)
+	c.preauthHash = h.Sum(nil)
+}
+
+func (c *Client) GetPreauthHash() []byte {
+	return c.preauthHash
+}
+
+// 校验响应签名，签名不一致或要求签名的会话收到未签名的响应时返回错误
+func (c *Client) VerifyResponse(buf []byte) error {
+	if c.signingKey == nil || len(buf) < smb.SMB2HeaderSize {
+		return nil
+	}
+	flags := binary.LittleEndian.Uint32(buf[smb.SMB2FlagsOffset:])
+	if flags&smb.SMB2_FLAGS_SIGNED == 0 {
+		status := binary.LittleEndian.Uint32(buf[smb.SMB2StatusOffset:])
+		sessionId := binary.LittleEndian.Uint64(buf[smb.SMB2SessionIdOffset:])
+		// 异步中间响应不签名
+		if c.IsSigningRequired && sessionId != 0 && status != ms.STATUS_PENDING {
+			return errors.New("Received unsigned response on a session that requires signing")
+		}
+		return nil
+	}
+	return smb.VerifyMessage(c.signingAlgorithm, c.signingKey, buf)
+}
+
 //func (c *Client) TCPSend(req interface{}) (res []byte, err error) {
 //	buf, err := encoder.Marshal(req)
 //	if err != nil {
@@ -120,53 +663,67 @@ func (c *Client) TCPSend(req interface{}) (res []byte, err error) {
 		c.Debug("", err)
 		return nil, err
 	}
+	if err = c.TCPWrite(buf); err != nil {
+		return nil, err
+	}
+	return c.TCPRead()
+}
+
+// 发送数据但不读取响应，用于发送请求的非最后一个分片
+func (c *Client) TCPWrite(buf []byte) error {
 	c.Debug("Raw:\n"+hex.Dump(buf), nil)
 	rw := bufio.NewReadWriter(bufio.NewReader(c.conn), bufio.NewWriter(c.conn))
-	if _, err = rw.Write(buf); err != nil {
+	if _, err := rw.Write(buf); err != nil {
 		c.Debug("", err)
-		return nil, err
+		return err
 	}
-	if err = rw.Flush(); err != nil {
+	if err := rw.Flush(); err != nil {
 		c.Debug("", err)
-		return nil, err
+		return err
 	}
+	return nil
+}
 
-	var responseData bytes.Buffer
-	responseBuffer := make([]byte, 4096)
-
-	for {
-		c.conn.SetReadDeadline(time.Now().Add(time.Second * 5)) // 设置读取操作的超时时间为5秒
-
-		n, err := c.conn.Read(responseBuffer)
-		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				// 超时，认为没有更多响应
-				break
-			} else if err == io.EOF {
-				// 服务器关闭连接，认为没有更多响应
-				break
-			}
-			c.Debug("", err)
-			return nil, err
-		}
-
-		responseData.Write(responseBuffer[:n])
+// DCERPC PDU通用头大小，FragLength位于第8字节
+const (
+	pduHeaderSize       = 16
+	pduFragLengthOffset = 8
+)
 
-		// 根据结束条件判断是否继续接收响应
-		// if shouldStop(responseData.Bytes()) {
-		//     break
-		// }
+// tcp读取单个PDU的默认超时时间
+const DefaultTCPTimeout = 5 * time.Second
 
-		// 动态调整缓冲区大小，扩展为当前大小的两倍
-		if responseData.Len() >= len(responseBuffer) {
-			newBuffer := make([]byte, responseData.Len()*2)
-			copy(newBuffer, responseData.Bytes())
-			responseBuffer = newBuffer
-		}
+// 读取一个完整的DCERPC PDU，先读取16字节通用头，再按FragLength读取剩余部分
+func (c *Client) TCPRead() (res []byte, err error) {
+	timeout := c.timeout
+	if timeout <= 0 {
+		timeout = DefaultTCPTimeout
+	}
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	header := make([]byte, pduHeaderSize)
+	if _, err = io.ReadFull(c.conn, header); err != nil {
+		c.Debug("", err)
+		return nil, err
+	}
+	fragLength := int(binary.LittleEndian.Uint16(header[pduFragLengthOffset:]))
+	if fragLength < pduHeaderSize {
+		return nil, errors.New("Invalid rpc fragment length")
+	}
+	res = make([]byte, fragLength)
+	copy(res, header)
+	if _, err = io.ReadFull(c.conn, res[pduHeaderSize:]); err != nil {
+		c.Debug("", err)
+		return nil, err
 	}
+	c.Debug("Raw:\n"+hex.Dump(res), nil)
+	return res, nil
+}
 
-	c.messageId++
-	return responseData.Bytes(), nil
+// 设置tcp读取单个PDU的超时时间
+func (c *Client) WithTimeout(timeout time.Duration) *Client {
+	c.timeout = timeout
+	return c
 }
 
 func (c *Client) WithDebug(debug bool) *Client {
@@ -174,6 +731,10 @@ func (c *Client) WithDebug(debug bool) *Client {
 	return c
 }
 
+func (c *Client) GetDebug() bool {
+	return c.debug
+}
+
 func (c *Client) WithSecurityMode(securityMode uint16) *Client {
 	c.securityMode = securityMode
 	return c
@@ -183,8 +744,14 @@ func (c *Client) GetSecurityMode() uint16 {
 	return c.securityMode
 }
 
+// 获取下一个可用的MessageId，实际发送时由SMBPost重新分配
 func (c *Client) GetMessageId() uint64 {
-	return c.messageId
+	if c.state == nil {
+		return 0
+	}
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	return c.state.messageId
 }
 
 func (c *Client) WithSessionId(sessionId uint64) *Client {
@@ -202,6 +769,7 @@ func (c *Client) GetConn() net.Conn {
 
 func (c *Client) WithConn(conn net.Conn) *Client {
 	c.conn = conn
+	c.state = newConnState(conn)
 	return c
 }
 
@@ -210,6 +778,101 @@ func (c *Client) WithDialect(dialect uint16) *Client {
 	return c
 }
 
+// 获取协商后的协议版本
+func (c *Client) GetDialect() uint16 {
+	return c.dialect
+}
+
+func (c *Client) WithSessionKey(sessionKey []byte) *Client {
+	c.sessionKey = sessionKey
+	return c
+}
+
+func (c *Client) GetSessionKey() []byte {
+	return c.sessionKey
+}
+
+// 设置签名密钥以及签名算法，密钥为空时关闭签名
+func (c *Client) WithSigningKey(signingKey []byte, algorithm uint16) *Client {
+	c.signingKey = signingKey
+	c.signingAlgorithm = algorithm
+	return c
+}
+
+// 设置协商的加密算法
+func (c *Client) WithCipher(cipherId uint16) *Client {
+	c.cipherId = cipherId
+	return c
+}
+
+func (c *Client) GetCipher() uint16 {
+	return c.cipherId
+}
+
+// 设置加密、解密密钥
+func (c *Client) WithEncryptionKeys(encryptionKey, decryptionKey []byte) *Client {
+	c.encryptionKey = encryptionKey
+	c.decryptionKey = decryptionKey
+	return c
+}
+
+// 开启/关闭整个会话的加密
+func (c *Client) WithSessionEncryption(encrypt bool) *Client {
+	c.encryptSession = encrypt
+	return c
+}
+
+func (c *Client) IsSessionEncrypted() bool {
+	return c.encryptSession
+}
+
+// 开启/关闭指定树连接的加密
+func (c *Client) WithTreeEncryption(treeId uint32, encrypt bool) *Client {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	if encrypt {
+		c.state.encryptedTrees[treeId] = true
+	} else {
+		delete(c.state.encryptedTrees, treeId)
+	}
+	return c
+}
+
+// 设置协商响应中服务端允许的最大请求大小
+func (c *Client) WithMaxSizes(maxTransactSize, maxReadSize, maxWriteSize uint32) *Client {
+	c.maxTransactSize = maxTransactSize
+	c.maxReadSize = maxReadSize
+	c.maxWriteSize = maxWriteSize
+	return c
+}
+
+func (c *Client) GetMaxTransactSize() uint32 {
+	return c.maxTransactSize
+}
+
+func (c *Client) GetMaxReadSize() uint32 {
+	return c.maxReadSize
+}
+
+func (c *Client) GetMaxWriteSize() uint32 {
+	return c.maxWriteSize
+}
+
+// 开启/关闭多信用请求
+func (c *Client) WithMultiCredit(multiCredit bool) *Client {
+	c.multiCredit = multiCredit
+	return c
+}
+
+func (c *Client) GetCredits() uint16 {
+	if c.state == nil {
+		return 0
+	}
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	return c.state.credits
+}
+
 func (c *Client) WithOptions(clientOptions *ClientOptions) *Client {
 	c.options = clientOptions
 	return c
@@ -220,12 +883,52 @@ func (c *Client) GetOptions() *ClientOptions {
 }
 
 func (c *Client) WithTrees(trees map[string]uint32) *Client {
-	c.trees = trees
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.trees = make(map[string]uint32)
+	for name, treeId := range trees {
+		c.state.trees[name] = treeId
+	}
 	return c
 }
 
+// 返回当前树连接的副本
 func (c *Client) GetTrees() map[string]uint32 {
-	return c.trees
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	trees := make(map[string]uint32)
+	for name, treeId := range c.state.trees {
+		trees[name] = treeId
+	}
+	return trees
+}
+
+// 记录树连接
+func (c *Client) WithTree(name string, treeId uint32) *Client {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	c.state.trees[name] = treeId
+	return c
+}
+
+// 移除树连接
+func (c *Client) RemoveTree(name string) *Client {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	delete(c.state.trees, name)
+	return c
+}
+
+// 按树id移除树连接，同名共享已经重新连接时保留新的连接
+func (c *Client) RemoveTreeId(treeId uint32) *Client {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+	for name, id := range c.state.trees {
+		if id == treeId {
+			delete(c.state.trees, name)
+		}
+	}
+	return c
 }
 
 func (c *Client) Close() error {
@@ -0,0 +1,345 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"github.com/Amzza0x00/go-impacket/pkg/smb"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 构造smb2消息头，payload追加在消息头之后
+func testHeader(command uint16, charge, credits uint16, payload []byte) []byte {
+	buf := make([]byte, smb.SMB2HeaderSize, smb.SMB2HeaderSize+len(payload))
+	copy(buf, smb.ProtocolSMB2)
+	binary.LittleEndian.PutUint16(buf[4:], smb.SMB2HeaderSize)
+	binary.LittleEndian.PutUint16(buf[smb.SMB2CreditChargeOffset:], charge)
+	binary.LittleEndian.PutUint16(buf[smb.SMB2CommandOffset:], command)
+	binary.LittleEndian.PutUint16(buf[smb.SMB2CreditRequestOffset:], credits)
+	return append(buf, payload...)
+}
+
+// 服务端响应，granted为授予的信用数
+func testResponse(messageId uint64, granted uint16, flags, status uint32, payload []byte) []byte {
+	buf := testHeader(smb.SMB2_ECHO, 1, granted, payload)
+	binary.LittleEndian.PutUint32(buf[smb.SMB2StatusOffset:], status)
+	binary.LittleEndian.PutUint32(buf[smb.SMB2FlagsOffset:], flags|smb.SMB2_FLAGS_SERVER_TO_REDIR)
+	binary.LittleEndian.PutUint64(buf[smb.SMB2MessageIdOffset:], messageId)
+	return buf
+}
+
+func TestCreditCharge(t *testing.T) {
+	tests := []struct {
+		multiCredit bool
+		size        uint32
+		want        uint16
+	}{
+		{false, 1 << 20, 1},
+		{true, 0, 1},
+		{true, 1, 1},
+		{true, CreditPayloadSize, 1},
+		{true, CreditPayloadSize + 1, 2},
+		{true, MaxChunkSize, 128},
+	}
+	for _, tt := range tests {
+		c := &Client{multiCredit: tt.multiCredit}
+		if got := c.CreditCharge(tt.size); got != tt.want {
+			t.Errorf("CreditCharge(%d) multiCredit=%v = %d, want %d", tt.size, tt.multiCredit, got, tt.want)
+		}
+	}
+}
+
+func TestChunkSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		multiCredit bool
+		credits     uint16
+		max         uint32
+		want        uint32
+	}{
+		{"single credit", false, 100, 0, CreditPayloadSize},
+		{"single credit server limit", false, 100, 4096, 4096},
+		{"limited by credits", true, 3, 0, 3 * CreditPayloadSize},
+		{"max chunk", true, 1000, 0, MaxChunkSize},
+		{"server limit", true, 1000, 1 << 20, 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{multiCredit: tt.multiCredit, state: newConnState(nil)}
+			c.state.credits = tt.credits
+			if got := c.chunkSize(tt.max); got != tt.want {
+				t.Errorf("chunkSize(%d) = %d, want %d", tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsumeCredits(t *testing.T) {
+	tests := []struct {
+		name        string
+		dialect     uint16
+		charge      uint16
+		request     uint16
+		wantCharge  uint16
+		wantRequest uint16
+	}{
+		{"single", smb.SMB2_1_Dialect, 1, 1, 1, 1},
+		{"zero charge", smb.SMB2_1_Dialect, 0, 1, 1, 1},
+		{"multi credit raises request", smb.SMB2_1_Dialect, 3, 1, 3, 3},
+		{"smb2.0.2", smb.SMB2_0_2_Dialect, 3, 1, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{dialect: tt.dialect, state: newConnState(nil)}
+			c.state.credits = 10
+			buf := testHeader(smb.SMB2_ECHO, tt.charge, tt.request, nil)
+			charge, err := c.consumeCredits(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if charge != tt.wantCharge || c.state.credits != 10-tt.wantCharge {
+				t.Errorf("charge = %d, credits = %d", charge, c.state.credits)
+			}
+			if got := binary.LittleEndian.Uint16(buf[smb.SMB2CreditRequestOffset:]); got != tt.wantRequest {
+				t.Errorf("CreditRequest = %d, want %d", got, tt.wantRequest)
+			}
+			if tt.dialect == smb.SMB2_0_2_Dialect && binary.LittleEndian.Uint16(buf[smb.SMB2CreditChargeOffset:]) != 0 {
+				t.Error("CreditCharge not cleared for smb2.0.2")
+			}
+		})
+	}
+	// 信用不足并且没有等待中的请求时直接失败
+	c := &Client{dialect: smb.SMB2_1_Dialect, state: newConnState(nil)}
+	if _, err := c.consumeCredits(testHeader(smb.SMB2_ECHO, 2, 2, nil)); err == nil {
+		t.Error("request exceeding credits with nothing pending succeeded")
+	}
+	c.state.err = io.EOF
+	if _, err := c.consumeCredits(testHeader(smb.SMB2_ECHO, 1, 1, nil)); err != io.EOF {
+		t.Errorf("err = %v, want connection error", err)
+	}
+}
+
+func TestGrantCredits(t *testing.T) {
+	st := newConnState(nil)
+	st.grantCredits(testResponse(0, 5, 0, 0, nil))
+	if st.credits != 6 {
+		t.Errorf("credits = %d, want 6", st.credits)
+	}
+	st.grantCredits(testResponse(0, 0xFFFF, 0, 0, nil))
+	if st.credits != 0xFFFF {
+		t.Errorf("credits = %d, want 0xFFFF", st.credits)
+	}
+}
+
+func TestIsInterimResponse(t *testing.T) {
+	tests := []struct {
+		flags  uint32
+		status uint32
+		want   bool
+	}{
+		{smb.SMB2_FLAGS_ASYNC_COMMAND, ms.STATUS_PENDING, true},
+		{0, ms.STATUS_PENDING, false},
+		{smb.SMB2_FLAGS_ASYNC_COMMAND, ms.STATUS_SUCCESS, false},
+	}
+	for _, tt := range tests {
+		if got := isInterimResponse(testResponse(1, 1, tt.flags, tt.status, nil)); got != tt.want {
+			t.Errorf("isInterimResponse(flags 0x%x, status 0x%x) = %v", tt.flags, tt.status, got)
+		}
+	}
+}
+
+// 中间响应只记录AsyncId，最终响应才结束请求，未知以及主动通知的MessageId忽略
+func TestDispatch(t *testing.T) {
+	c := &Client{}
+	st := newConnState(nil)
+	st.credits = 0
+	call := &Call{MessageId: 7, done: make(chan struct{})}
+	st.pending[7] = call
+
+	interim := testResponse(7, 1, smb.SMB2_FLAGS_ASYNC_COMMAND, ms.STATUS_PENDING, nil)
+	binary.LittleEndian.PutUint64(interim[smb.SMB2AsyncIdOffset:], 0x42)
+	st.dispatch(c, interim, nil)
+	select {
+	case <-call.Done():
+		t.Fatal("interim response finished the call")
+	default:
+	}
+	if st.asyncIds[7] != 0x42 || st.credits != 1 {
+		t.Errorf("asyncId = 0x%x, credits = %d", st.asyncIds[7], st.credits)
+	}
+
+	st.dispatch(c, testResponse(8, 1, 0, 0, nil), nil)
+	st.dispatch(c, testResponse(smb.SMB2UnsolicitedMessageId, 1, 0, 0, nil), nil)
+	if st.credits != 1 || len(st.pending) != 1 {
+		t.Errorf("ignored responses changed state: credits = %d, pending = %d", st.credits, len(st.pending))
+	}
+
+	final := testResponse(7, 2, smb.SMB2_FLAGS_ASYNC_COMMAND, ms.STATUS_SUCCESS, []byte("done"))
+	st.dispatch(c, final, nil)
+	data, err := call.Wait()
+	if err != nil || string(data[smb.SMB2HeaderSize:]) != "done" {
+		t.Errorf("Wait = %q, %v", data, err)
+	}
+	if len(st.pending) != 0 || len(st.asyncIds) != 0 || st.credits != 3 {
+		t.Errorf("pending = %d, asyncIds = %d, credits = %d", len(st.pending), len(st.asyncIds), st.credits)
+	}
+}
+
+type testRequest struct {
+	Buf []byte
+}
+
+// 服务端收到全部请求后倒序响应，每个请求都应该拿到自己的响应
+func TestConcurrentRequests(t *testing.T) {
+	const n = 16
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &Client{}
+	c.WithConn(client).WithDialect(smb.SMB2_1_Dialect)
+	c.state.credits = n
+
+	go func() {
+		st := newConnState(server)
+		var reqs [][]byte
+		for len(reqs) < n {
+			buf, err := st.readFrame()
+			if err != nil {
+				return
+			}
+			reqs = append(reqs, buf)
+		}
+		for i := len(reqs) - 1; i >= 0; i-- {
+			messageId := binary.LittleEndian.Uint64(reqs[i][smb.SMB2MessageIdOffset:])
+			res := testResponse(messageId, 1, 0, 0, reqs[i][smb.SMB2HeaderSize:])
+			size := make([]byte, 4)
+			binary.BigEndian.PutUint32(size, uint32(len(res)))
+			if _, err := server.Write(append(size, res...)); err != nil {
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := []byte{byte(i)}
+			res, err := c.SMBSend(testRequest{Buf: testHeader(smb.SMB2_ECHO, 1, 1, payload)})
+			if err != nil {
+				errs <- err.Error()
+				return
+			}
+			if res[smb.SMB2HeaderSize] != byte(i) {
+				errs <- "response delivered to the wrong request"
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if c.GetMessageId() != n || c.GetCredits() != n {
+		t.Errorf("MessageId = %d, credits = %d, want %d", c.GetMessageId(), c.GetCredits(), n)
+	}
+}
+
+// 按NextCommand拆分复合响应
+func TestSplitCompound(t *testing.T) {
+	chain := func(sizes ...int) []byte {
+		var buf []byte
+		for i, size := range sizes {
+			msg := testHeader(smb.SMB2_ECHO, 1, 1, make([]byte, size-smb.SMB2HeaderSize))
+			if i < len(sizes)-1 {
+				binary.LittleEndian.PutUint32(msg[smb.SMB2NextCommandOffset:], uint32(size))
+			}
+			buf = append(buf, msg...)
+		}
+		return buf
+	}
+	tests := []struct {
+		name  string
+		buf   []byte
+		sizes []int
+	}{
+		{"single", chain(68), []int{68}},
+		{"three", chain(72, 80, 65), []int{72, 80, 65}},
+		{"short", make([]byte, 10), nil},
+		{"next beyond buffer", func() []byte {
+			buf := chain(72)
+			binary.LittleEndian.PutUint32(buf[smb.SMB2NextCommandOffset:], 200)
+			return buf
+		}(), nil},
+		{"next inside header", func() []byte {
+			buf := chain(72, 72)
+			binary.LittleEndian.PutUint32(buf[smb.SMB2NextCommandOffset:], 8)
+			return buf
+		}(), nil},
+		{"truncated last message", chain(72, 72)[:100], nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs, err := splitCompound(tt.buf)
+			if tt.sizes == nil {
+				if err == nil {
+					t.Errorf("splitCompound = %d messages, want error", len(msgs))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != len(tt.sizes) {
+				t.Fatalf("splitCompound = %d messages, want %d", len(msgs), len(tt.sizes))
+			}
+			for i, msg := range msgs {
+				if len(msg) != tt.sizes[i] {
+					t.Errorf("message %d length = %d, want %d", i, len(msg), tt.sizes[i])
+				}
+			}
+		})
+	}
+}
+
+// 不带stub数据的DCERPC PDU
+func testPDU(callId uint32, fragLength uint16) []byte {
+	pdu := make([]byte, fragLength)
+	pdu[0], pdu[2], pdu[3], pdu[4] = 5, 2, 0x03, 0x10
+	binary.LittleEndian.PutUint16(pdu[pduFragLengthOffset:], fragLength)
+	binary.LittleEndian.PutUint32(pdu[12:], callId)
+	return pdu
+}
+
+// TCPRead每次只读取一个PDU，一次写入的多个PDU需要分别读取
+func TestTCPRead(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &Client{}
+	c.WithConn(client).WithTimeout(time.Second)
+	first, second := testPDU(1, 24), testPDU(2, 32)
+	go server.Write(append(append([]byte(nil), first...), second...))
+	for _, want := range [][]byte{first, second} {
+		pdu, err := c.TCPRead()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pdu, want) {
+			t.Errorf("TCPRead = %x, want %x", pdu, want)
+		}
+	}
+	// frag_length小于通用头大小
+	short := testPDU(3, pduHeaderSize)
+	binary.LittleEndian.PutUint16(short[pduFragLengthOffset:], pduHeaderSize-1)
+	go server.Write(short)
+	if _, err := c.TCPRead(); err == nil {
+		t.Error("PDU with short frag_length read")
+	}
+}
@@ -0,0 +1,336 @@
+package ndr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"reflect"
+)
+
+// 此文件提供NDR编码
+
+type marshaller struct {
+	buf      bytes.Buffer
+	referent uint32
+	full     map[uintptr]uint32 // full指针已经分配的referent id
+	written  map[uintptr]bool   // full指针指向的数据已经写入
+	hoisted  bool               // conformant结构体的max count已经写入
+	ndr64    bool
+}
+
+// NDR20编码，v为结构体时每个字段作为一个顶层参数
+func Marshal(v interface{}) ([]byte, error) {
+	return MarshalWithSyntax(v, NDR20)
+}
+
+// 使用指定的传输语法编码
+func MarshalWithSyntax(v interface{}, syntax Syntax) ([]byte, error) {
+	m := &marshaller{
+		full:    make(map[uintptr]uint32),
+		written: make(map[uintptr]bool),
+		ndr64:   syntax == NDR64,
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		if err := m.writeTop(rv, &fieldTag{}); err != nil {
+			return nil, err
+		}
+		return m.buf.Bytes(), nil
+	}
+	fields, _, err := structFields(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if err := m.writeTop(rv.Field(f.index), f.tag); err != nil {
+			return nil, fmt.Errorf("%s: %s", f.name, err)
+		}
+	}
+	return m.buf.Bytes(), nil
+}
+
+// 写入顶层参数，指针指向的数据紧跟在参数之后
+func (m *marshaller) writeTop(v reflect.Value, tag *fieldTag) error {
+	if tag.ref {
+		switch v.Kind() {
+		case reflect.Ptr:
+			if v.IsNil() {
+				return errors.New("ref pointer is nil")
+			}
+			return m.writePointee(v.Elem(), tag)
+		case reflect.String, reflect.Slice:
+			return m.writePointee(v, tag)
+		}
+	}
+	if err := m.writeScalars(v, tag); err != nil {
+		return err
+	}
+	return m.writeBuffers(v, tag)
+}
+
+func (m *marshaller) align(n int) {
+	for m.buf.Len()%n != 0 {
+		m.buf.WriteByte(0)
+	}
+}
+
+// 写入指针或者数组长度
+func (m *marshaller) word(v uint32) {
+	if m.ndr64 {
+		m.align(8)
+		binary.Write(&m.buf, binary.LittleEndian, uint64(v))
+		return
+	}
+	m.align(4)
+	binary.Write(&m.buf, binary.LittleEndian, v)
+}
+
+// 写入指针的referent id，full指针引用同一数据时使用相同的referent id
+func (m *marshaller) pointer(v reflect.Value, tag *fieldTag) {
+	full := tag.full && v.Kind() == reflect.Ptr
+	if full {
+		if id, ok := m.full[v.Pointer()]; ok {
+			m.word(id)
+			return
+		}
+	}
+	m.referent += 4
+	id := referentBase + m.referent
+	if full {
+		m.full[v.Pointer()] = id
+	}
+	m.word(id)
+}
+
+// 写入结构体内的数据，指针只写入referent id
+func (m *marshaller) writeScalars(v reflect.Value, tag *fieldTag) error {
+	switch v.Kind() {
+	case reflect.Uint8, reflect.Int8, reflect.Bool,
+		reflect.Uint16, reflect.Int16,
+		reflect.Uint32, reflect.Int32,
+		reflect.Uint64, reflect.Int64:
+		m.align(alignOf(v.Type(), tag, m.ndr64))
+		if v.Kind() == reflect.Bool {
+			var b uint8
+			if v.Bool() {
+				b = 1
+			}
+			return binary.Write(&m.buf, binary.LittleEndian, b)
+		}
+		return binary.Write(&m.buf, binary.LittleEndian, v.Interface())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := m.writeScalars(v.Index(i), &fieldTag{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		return m.writeStructScalars(v)
+	case reflect.Ptr:
+		if v.IsNil() {
+			if tag.ref {
+				return errors.New("ref pointer is nil")
+			}
+			m.word(0)
+			return nil
+		}
+		m.pointer(v, tag)
+		return nil
+	case reflect.String:
+		if v.Len() == 0 && !tag.ref {
+			m.word(0)
+			return nil
+		}
+		m.pointer(v, tag)
+		return nil
+	case reflect.Slice:
+		if tag.inline {
+			for i := 0; i < v.Len(); i++ {
+				if err := m.writeScalars(v.Index(i), &fieldTag{}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if v.IsNil() && !tag.ref {
+			m.word(0)
+			return nil
+		}
+		m.pointer(v, tag)
+		return nil
+	}
+	return fmt.Errorf("Unsupported type %s", v.Type())
+}
+
+func (m *marshaller) writeStructScalars(v reflect.Value) error {
+	t := v.Type()
+	fields, switchIndex, err := structFields(t)
+	if err != nil {
+		return err
+	}
+	// conformant结构体在开头写入数组长度
+	if !m.hoisted && isConformant(t) {
+		m.word(uint32(conformantLen(v)))
+		m.hoisted = true
+		defer func() { m.hoisted = false }()
+	}
+	align := alignOf(t, nil, m.ndr64)
+	m.align(align)
+	if switchIndex >= 0 {
+		sw := fields[switchIndex]
+		if err = m.writeScalars(v.Field(sw.index), sw.tag); err != nil {
+			return err
+		}
+		value, err := switchValue(v.Field(sw.index))
+		if err != nil {
+			return err
+		}
+		// NDR64联合体成员按所有成员的最大对齐
+		if m.ndr64 {
+			m.align(armAlign(t, fields, switchIndex, true))
+		}
+		if arm := unionArm(fields, switchIndex, value); arm != nil {
+			if err = m.writeScalars(v.Field(arm.index), arm.tag); err != nil {
+				return fmt.Errorf("%s: %s", arm.name, err)
+			}
+		}
+	} else {
+		for _, f := range fields {
+			if err = m.writeScalars(v.Field(f.index), f.tag); err != nil {
+				return fmt.Errorf("%s: %s", f.name, err)
+			}
+		}
+	}
+	// NDR64结构体末尾填充到对齐大小
+	if m.ndr64 {
+		m.align(align)
+	}
+	return nil
+}
+
+// conformant结构体中inline数组的长度
+func conformantLen(v reflect.Value) int {
+	t := v.Type()
+	fields, _, _ := structFields(t)
+	last := v.Field(fields[len(fields)-1].index)
+	if last.Kind() == reflect.Slice {
+		return last.Len()
+	}
+	return conformantLen(last)
+}
+
+// 写入指针指向的数据
+func (m *marshaller) writeBuffers(v reflect.Value, tag *fieldTag) error {
+	switch v.Kind() {
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := m.writeBuffers(v.Index(i), &fieldTag{}); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields, switchIndex, err := structFields(v.Type())
+		if err != nil {
+			return err
+		}
+		if switchIndex >= 0 {
+			value, err := switchValue(v.Field(fields[switchIndex].index))
+			if err != nil {
+				return err
+			}
+			arm := unionArm(fields, switchIndex, value)
+			if arm == nil {
+				return nil
+			}
+			fields = []field{*arm}
+		}
+		for _, f := range fields {
+			if err = m.writeBuffers(v.Field(f.index), f.tag); err != nil {
+				return fmt.Errorf("%s: %s", f.name, err)
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		if tag.full {
+			// 只有第一次引用时写入数据
+			if m.written[v.Pointer()] {
+				return nil
+			}
+			m.written[v.Pointer()] = true
+		}
+		return m.writePointee(v.Elem(), tag)
+	case reflect.String:
+		if v.Len() == 0 && !tag.ref {
+			return nil
+		}
+		return m.writePointee(v, tag)
+	case reflect.Slice:
+		if tag.inline {
+			for i := 0; i < v.Len(); i++ {
+				if err := m.writeBuffers(v.Index(i), &fieldTag{}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if v.IsNil() && !tag.ref {
+			return nil
+		}
+		return m.writePointee(v, tag)
+	}
+	return nil
+}
+
+// 写入指针指向的数据本身，字符串以及切片写入数组头
+func (m *marshaller) writePointee(v reflect.Value, tag *fieldTag) error {
+	switch v.Kind() {
+	case reflect.String:
+		var data []byte
+		var count int
+		if tag.ascii {
+			data = append([]byte(v.String()), 0)
+			count = len(data)
+		} else {
+			data = encoder.ToUnicode(v.String() + "\x00")
+			count = len(data) / 2
+		}
+		m.word(uint32(count))
+		m.word(0)
+		m.word(uint32(count))
+		m.buf.Write(data)
+		return nil
+	case reflect.Slice:
+		m.word(uint32(v.Len()))
+		if tag.varying {
+			m.word(0)
+			m.word(uint32(v.Len()))
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			m.buf.Write(v.Bytes())
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := m.writeScalars(v.Index(i), &fieldTag{}); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := m.writeBuffers(v.Index(i), &fieldTag{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := m.writeScalars(v, &fieldTag{}); err != nil {
+		return err
+	}
+	return m.writeBuffers(v, &fieldTag{})
+}
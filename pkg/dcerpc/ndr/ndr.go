@@ -0,0 +1,245 @@
+package ndr
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 此文件提供基于结构体标签的NDR编码/解码
+// https://pubs.opengroup.org/onlinepubs/9629399/chap14.htm
+//
+// 传入Marshal/Unmarshal的结构体每个字段对应一个rpc参数，顶层参数的指针数据紧跟在参数之后，
+// 嵌入结构体或数组中的指针数据延迟到最外层结构体或数组之后，按字段顺序写入
+//
+// Go类型与NDR类型对应关系：
+//   uint8/int8/bool、uint16/int16、uint32/int32、uint64/int64  按自身大小对齐的基本类型
+//   [N]T                                                      固定长度数组
+//   struct                                                    结构体，按成员最大对齐
+//   *T                                                        unique指针，nil为空指针
+//   string                                                    unique指针指向的conformant varying宽字符串，空字符串为空指针
+//   []T                                                       unique指针指向的conformant数组，nil为空指针
+//
+// 字段标签：
+//   ndr:"ref"      ref指针，顶层参数不写入referent id，嵌入时不能为空
+//   ndr:"ptr"      full指针，多次引用同一数据时只写入一次
+//   ndr:"inline"   切片作为结构体最后一个成员的conformant数组，max count提前到结构体开头
+//   ndr:"varying"  切片为conformant varying数组
+//   ndr:"ascii"    字符串使用单字节字符
+//   ndr:"switch"   联合体标识，所在结构体作为联合体处理，只编码与标识匹配的成员
+//   ndr:"case:N"   联合体成员，标识为N时编码，可以用|分隔多个值
+//   ndr:"default"  联合体默认成员
+//   ndr:"-"        忽略该字段
+
+// 传输语法
+type Syntax int
+
+const (
+	NDR20 Syntax = iota
+	// NDR64中指针、数组长度为8字节，结构体末尾按对齐大小填充
+	// MS-RPCE 2.2.5 NDR64 Transfer Syntax
+	NDR64
+)
+
+// 非空指针的referent id起始值
+const referentBase = 0x00020000
+
+// 上下文句柄
+type ContextHandle struct {
+	Attributes uint32
+	UUID       [16]byte
+}
+
+// 字段是否为空句柄
+func (h ContextHandle) IsZero() bool {
+	return h == ContextHandle{}
+}
+
+type fieldTag struct {
+	ref       bool
+	full      bool
+	inline    bool
+	varying   bool
+	ascii     bool
+	isSwitch  bool
+	isDefault bool
+	cases     []uint64
+	skip      bool
+}
+
+func parseTag(sf reflect.StructField) (*fieldTag, error) {
+	tag := &fieldTag{}
+	s := sf.Tag.Get("ndr")
+	if s == "" {
+		return tag, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		tokens := strings.SplitN(item, ":", 2)
+		switch tokens[0] {
+		case "ref":
+			tag.ref = true
+		case "ptr":
+			tag.full = true
+		case "unique":
+		case "inline":
+			tag.inline = true
+		case "varying":
+			tag.varying = true
+		case "ascii":
+			tag.ascii = true
+		case "switch":
+			tag.isSwitch = true
+		case "default":
+			tag.isDefault = true
+		case "case":
+			if len(tokens) != 2 {
+				return nil, errors.New("Missing required tag data. Expecting case:val")
+			}
+			for _, c := range strings.Split(tokens[1], "|") {
+				i, err := strconv.ParseUint(c, 0, 64)
+				if err != nil {
+					return nil, err
+				}
+				tag.cases = append(tag.cases, i)
+			}
+		case "-":
+			tag.skip = true
+		default:
+			return nil, fmt.Errorf("Unknown ndr tag %s on field %s", tokens[0], sf.Name)
+		}
+	}
+	return tag, nil
+}
+
+// 结构体字段以及解析后的标签
+type field struct {
+	index int
+	name  string
+	tag   *fieldTag
+}
+
+// 解析结构体字段，联合体返回标识字段的下标
+func structFields(t reflect.Type) (fields []field, switchIndex int, err error) {
+	switchIndex = -1
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, err := parseTag(sf)
+		if err != nil {
+			return nil, -1, err
+		}
+		if tag.skip || sf.PkgPath != "" {
+			continue
+		}
+		if tag.isSwitch {
+			switchIndex = len(fields)
+		}
+		fields = append(fields, field{index: i, name: sf.Name, tag: tag})
+	}
+	return fields, switchIndex, nil
+}
+
+// 根据联合体标识选择成员，没有匹配的成员时返回nil
+func unionArm(fields []field, switchIndex int, value uint64) *field {
+	var def *field
+	for i := range fields {
+		if i == switchIndex {
+			continue
+		}
+		if fields[i].tag.isDefault {
+			def = &fields[i]
+		}
+		for _, c := range fields[i].tag.cases {
+			if c == value {
+				return &fields[i]
+			}
+		}
+	}
+	return def
+}
+
+// 联合体标识的值
+func switchValue(v reflect.Value) (uint64, error) {
+	switch v.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int()), nil
+	}
+	return 0, errors.New("Union switch must be an integer type")
+}
+
+// 结构体最后一个成员（或者嵌套结构体的最后一个成员）为inline切片时，结构体为conformant结构体
+func isConformant(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	fields, switchIndex, err := structFields(t)
+	if err != nil || switchIndex >= 0 || len(fields) == 0 {
+		return false
+	}
+	last := fields[len(fields)-1]
+	ft := t.Field(last.index).Type
+	if ft.Kind() == reflect.Slice && last.tag.inline {
+		return true
+	}
+	return isConformant(ft)
+}
+
+// 对齐大小，指针以及数组长度NDR20为4字节，NDR64为8字节
+func alignOf(t reflect.Type, tag *fieldTag, ndr64 bool) int {
+	word := 4
+	if ndr64 {
+		word = 8
+	}
+	switch t.Kind() {
+	case reflect.Uint8, reflect.Int8, reflect.Bool:
+		return 1
+	case reflect.Uint16, reflect.Int16:
+		return 2
+	case reflect.Uint32, reflect.Int32:
+		return 4
+	case reflect.Ptr, reflect.String:
+		return word
+	case reflect.Uint64, reflect.Int64:
+		return 8
+	case reflect.Array:
+		return alignOf(t.Elem(), &fieldTag{}, ndr64)
+	case reflect.Slice:
+		if tag != nil && tag.inline {
+			return alignOf(t.Elem(), &fieldTag{}, ndr64)
+		}
+		return word
+	case reflect.Struct:
+		fields, _, err := structFields(t)
+		if err != nil {
+			return 1
+		}
+		align := 1
+		if isConformant(t) {
+			align = word
+		}
+		for _, f := range fields {
+			if a := alignOf(t.Field(f.index).Type, f.tag, ndr64); a > align {
+				align = a
+			}
+		}
+		return align
+	}
+	return 1
+}
+
+// 联合体成员的最大对齐大小
+func armAlign(t reflect.Type, fields []field, switchIndex int, ndr64 bool) int {
+	align := 1
+	for i, f := range fields {
+		if i == switchIndex {
+			continue
+		}
+		if a := alignOf(t.Field(f.index).Type, f.tag, ndr64); a > align {
+			align = a
+		}
+	}
+	return align
+}
@@ -0,0 +1,59 @@
+package ndr
+
+import "testing"
+
+// NDR64向量按MS-RPCE 2.2.5 NDR64 Transfer Syntax的对齐规则推导
+
+// 指针以及数组长度为8字节并按8字节对齐
+func TestNDR64Pointer(t *testing.T) {
+	golden(t, &struct {
+		Flag uint8
+		Data []uint32
+		Name string
+	}{
+		Flag: 1,
+		Data: []uint32{1, 2},
+		Name: "ab",
+	}, NDR64, "01 00000000000000"+
+		"0400020000000000 0200000000000000 01000000 02000000"+
+		"0800020000000000 0300000000000000 0000000000000000 0300000000000000 6100 6200 0000")
+}
+
+// 联合体成员按所有成员的最大对齐，ptr成员使标识之后填充到8字节
+func TestNDR64Union(t *testing.T) {
+	x := uint32(7)
+	golden(t, &struct{ U testUnion }{testUnion{Tag: 1, P: &x}}, NDR64,
+		"01000000 00000000 0400020000000000 07000000")
+	// 联合体末尾同样填充到对齐大小
+	golden(t, &struct {
+		U testUnion
+		E uint8
+	}{U: testUnion{Tag: 2, V: 5}, E: 0xff}, NDR64,
+		"02000000 00000000 0500 000000000000 ff")
+}
+
+// conformant结构体的max count为8字节，结构体按8字节对齐并在末尾填充
+func TestNDR64ConformantStruct(t *testing.T) {
+	golden(t, &struct {
+		C conformantStruct
+		E uint8
+	}{
+		C: conformantStruct{Count: 3, Data: []uint16{1, 2, 3}},
+		E: 0xff,
+	}, NDR64, "0300000000000000 03000000 0100 0200 0300 000000000000 ff")
+}
+
+// 嵌入结构体中的指针数据延迟到顶层参数之后，结构体本身按8字节对齐
+func TestNDR64EmbeddedPointer(t *testing.T) {
+	x := uint32(42)
+	golden(t, &struct {
+		E uint16
+		S struct {
+			A uint32
+			P *uint32
+		}
+	}{E: 1, S: struct {
+		A uint32
+		P *uint32
+	}{A: 2, P: &x}}, NDR64, "0100 000000000000 02000000 00000000 0400020000000000 2a000000")
+}
@@ -0,0 +1,124 @@
+package ndr
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// 编码结果与want一致，并且解码后与原值相同
+func golden(t *testing.T, v interface{}, syntax Syntax, want string) {
+	t.Helper()
+	buf, err := MarshalWithSyntax(v, syntax)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := unhex(t, want); !bytes.Equal(buf, w) {
+		t.Fatalf("Marshal =\n%x\nwant\n%x", buf, w)
+	}
+	res := reflect.New(reflect.TypeOf(v).Elem())
+	if err = UnmarshalWithSyntax(buf, res.Interface(), syntax); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Interface(), v) {
+		t.Errorf("Unmarshal = %+v, want %+v", res.Elem().Interface(), reflect.ValueOf(v).Elem().Interface())
+	}
+}
+
+type conformantStruct struct {
+	Count uint32
+	Data  []uint16 `ndr:"inline"`
+}
+
+// 顶层unique字符串以及conformant结构体，conformant结构体的max count提前到结构体开头
+func TestMarshalConformant(t *testing.T) {
+	golden(t, &struct {
+		Name string
+		C    conformantStruct
+	}{
+		Name: "ab",
+		C:    conformantStruct{Count: 3, Data: []uint16{1, 2, 3}},
+	}, NDR20, "04000200 03000000 00000000 03000000 6100 6200 0000 0000"+
+		"03000000 03000000 0100 0200 0300")
+}
+
+type fullPair struct {
+	A *uint32 `ndr:"ptr"`
+	B *uint32 `ndr:"ptr"`
+}
+
+// full指针多次引用同一数据时使用相同的referent id，数据只写入一次
+func TestMarshalFullPointer(t *testing.T) {
+	x := uint32(42)
+	v := &struct {
+		P fullPair
+		C *uint32 `ndr:"ptr"`
+	}{P: fullPair{A: &x, B: &x}, C: &x}
+	golden(t, v, NDR20, "04000200 04000200 2a000000 04000200")
+
+	var res struct {
+		P fullPair
+		C *uint32 `ndr:"ptr"`
+	}
+	if err := Unmarshal(unhex(t, "04000200 04000200 2a000000 04000200"), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.P.A != res.P.B || res.P.A != res.C {
+		t.Error("full pointers with the same referent id do not alias")
+	}
+}
+
+// unique指针与ref指针指向的conformant varying数组
+func TestMarshalVarying(t *testing.T) {
+	golden(t, &struct {
+		Unique []uint16 `ndr:"varying"`
+		Ref    []byte   `ndr:"ref,varying"`
+		Null   []uint16 `ndr:"varying"`
+	}{
+		Unique: []uint16{1, 2, 3},
+		Ref:    []byte{0xaa, 0xbb},
+	}, NDR20, "04000200 03000000 00000000 03000000 0100 0200 0300 0000"+
+		"02000000 00000000 02000000 aabb 0000"+
+		"00000000")
+}
+
+type testUnion struct {
+	Tag uint32  `ndr:"switch"`
+	P   *uint32 `ndr:"case:1"`
+	V   uint16  `ndr:"case:2|3"`
+}
+
+// NDR20联合体成员紧跟在标识之后
+func TestMarshalUnion(t *testing.T) {
+	x := uint32(7)
+	golden(t, &struct{ U testUnion }{testUnion{Tag: 1, P: &x}}, NDR20, "01000000 04000200 07000000")
+	golden(t, &struct{ U testUnion }{testUnion{Tag: 3, V: 5}}, NDR20, "03000000 0500")
+}
+
+// ref指针不能为空
+func TestMarshalNilRef(t *testing.T) {
+	if _, err := Marshal(&struct {
+		P *uint32 `ndr:"ref"`
+	}{}); err == nil {
+		t.Error("nil ref pointer marshalled")
+	}
+}
+
+// 数组长度超过剩余数据时返回错误
+func TestUnmarshalTruncated(t *testing.T) {
+	var res struct{ Data []uint32 }
+	if err := Unmarshal(unhex(t, "04000200 ffffff00 01000000"), &res); err == nil {
+		t.Error("truncated array unmarshalled")
+	}
+}
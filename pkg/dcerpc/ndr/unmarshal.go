@@ -0,0 +1,415 @@
+package ndr
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"reflect"
+	"strings"
+)
+
+// 此文件提供NDR解码
+
+var errTruncated = errors.New("NDR data is truncated")
+
+type unmarshaller struct {
+	buf     []byte
+	off     int
+	full    map[uint32]reflect.Value // full指针referent id对应的数据
+	skip    map[uintptr]bool         // full指针引用已经读取的数据，不需要再次读取
+	present map[uintptr]bool         // 非空的字符串指针
+	size    uint32                   // conformant结构体开头读取的数组长度
+	hoisted bool
+	ndr64   bool
+}
+
+// NDR20解码，v必须为指针，指向结构体时每个字段作为一个顶层参数
+func Unmarshal(buf []byte, v interface{}) error {
+	return UnmarshalWithSyntax(buf, v, NDR20)
+}
+
+// 使用指定的传输语法解码
+func UnmarshalWithSyntax(buf []byte, v interface{}, syntax Syntax) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("Unmarshal requires a non-nil pointer")
+	}
+	u := &unmarshaller{
+		buf:     buf,
+		full:    make(map[uint32]reflect.Value),
+		skip:    make(map[uintptr]bool),
+		present: make(map[uintptr]bool),
+		ndr64:   syntax == NDR64,
+	}
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Struct {
+		return u.readTop(rv, &fieldTag{})
+	}
+	fields, _, err := structFields(rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if err := u.readTop(rv.Field(f.index), f.tag); err != nil {
+			return fmt.Errorf("%s: %s", f.name, err)
+		}
+	}
+	return nil
+}
+
+func (u *unmarshaller) readTop(v reflect.Value, tag *fieldTag) error {
+	if tag.ref {
+		switch v.Kind() {
+		case reflect.Ptr:
+			v.Set(reflect.New(v.Type().Elem()))
+			return u.readPointee(v.Elem(), tag)
+		case reflect.String, reflect.Slice:
+			return u.readPointee(v, tag)
+		}
+	}
+	if err := u.readScalars(v, tag); err != nil {
+		return err
+	}
+	return u.readBuffers(v, tag)
+}
+
+func (u *unmarshaller) align(n int) {
+	if rem := u.off % n; rem != 0 {
+		u.off += n - rem
+	}
+}
+
+func (u *unmarshaller) read(n int) ([]byte, error) {
+	if n < 0 || u.off+n > len(u.buf) {
+		return nil, errTruncated
+	}
+	b := u.buf[u.off : u.off+n]
+	u.off += n
+	return b, nil
+}
+
+func (u *unmarshaller) uint32() (uint32, error) {
+	u.align(4)
+	b, err := u.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// 读取指针或者数组长度
+func (u *unmarshaller) word() (uint32, error) {
+	if !u.ndr64 {
+		return u.uint32()
+	}
+	u.align(8)
+	b, err := u.read(8)
+	if err != nil {
+		return 0, err
+	}
+	n := binary.LittleEndian.Uint64(b)
+	if n > 0xFFFFFFFF {
+		return 0, errors.New("NDR64 value is too large")
+	}
+	return uint32(n), nil
+}
+
+// 读取数组长度，并检查长度是否超过剩余数据
+func (u *unmarshaller) count(elemSize int) (int, error) {
+	n, err := u.word()
+	if err != nil {
+		return 0, err
+	}
+	if uint64(n)*uint64(elemSize) > uint64(len(u.buf)-u.off) {
+		return 0, errTruncated
+	}
+	return int(n), nil
+}
+
+func (u *unmarshaller) readScalars(v reflect.Value, tag *fieldTag) error {
+	switch v.Kind() {
+	case reflect.Uint8, reflect.Int8, reflect.Bool:
+		b, err := u.read(1)
+		if err != nil {
+			return err
+		}
+		setInt(v, uint64(b[0]))
+		return nil
+	case reflect.Uint16, reflect.Int16:
+		u.align(2)
+		b, err := u.read(2)
+		if err != nil {
+			return err
+		}
+		setInt(v, uint64(binary.LittleEndian.Uint16(b)))
+		return nil
+	case reflect.Uint32, reflect.Int32:
+		n, err := u.uint32()
+		if err != nil {
+			return err
+		}
+		setInt(v, uint64(n))
+		return nil
+	case reflect.Uint64, reflect.Int64:
+		u.align(8)
+		b, err := u.read(8)
+		if err != nil {
+			return err
+		}
+		setInt(v, binary.LittleEndian.Uint64(b))
+		return nil
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := u.readScalars(v.Index(i), &fieldTag{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		return u.readStructScalars(v)
+	case reflect.Ptr:
+		id, err := u.word()
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if tag.full {
+			if p, ok := u.full[id]; ok {
+				v.Set(p)
+				u.skip[v.Addr().Pointer()] = true
+				return nil
+			}
+		}
+		v.Set(reflect.New(v.Type().Elem()))
+		if tag.full {
+			u.full[id] = v
+		}
+		return nil
+	case reflect.String:
+		id, err := u.word()
+		if err != nil {
+			return err
+		}
+		v.SetString("")
+		if id != 0 {
+			u.present[v.Addr().Pointer()] = true
+		}
+		return nil
+	case reflect.Slice:
+		if tag.inline {
+			if !u.hoisted {
+				return errors.New("Inline array must be the last member of a struct")
+			}
+			u.hoisted = false
+			n := int(u.size)
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+			for i := 0; i < n; i++ {
+				if err := u.readScalars(v.Index(i), &fieldTag{}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		id, err := u.word()
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		// 非空切片标记指针不为空
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+		return nil
+	}
+	return fmt.Errorf("Unsupported type %s", v.Type())
+}
+
+func setInt(v reflect.Value, n uint64) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(n != 0)
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(n))
+	default:
+		v.SetUint(n)
+	}
+}
+
+func (u *unmarshaller) readStructScalars(v reflect.Value) error {
+	t := v.Type()
+	fields, switchIndex, err := structFields(t)
+	if err != nil {
+		return err
+	}
+	if !u.hoisted && isConformant(t) {
+		n, err := u.count(1)
+		if err != nil {
+			return err
+		}
+		u.size, u.hoisted = uint32(n), true
+	}
+	align := alignOf(t, nil, u.ndr64)
+	u.align(align)
+	if switchIndex >= 0 {
+		sw := fields[switchIndex]
+		if err = u.readScalars(v.Field(sw.index), sw.tag); err != nil {
+			return err
+		}
+		value, err := switchValue(v.Field(sw.index))
+		if err != nil {
+			return err
+		}
+		if u.ndr64 {
+			u.align(armAlign(t, fields, switchIndex, true))
+		}
+		if arm := unionArm(fields, switchIndex, value); arm != nil {
+			if err = u.readScalars(v.Field(arm.index), arm.tag); err != nil {
+				return fmt.Errorf("%s: %s", arm.name, err)
+			}
+		}
+	} else {
+		for _, f := range fields {
+			if err = u.readScalars(v.Field(f.index), f.tag); err != nil {
+				return fmt.Errorf("%s: %s", f.name, err)
+			}
+		}
+	}
+	if u.ndr64 {
+		u.align(align)
+	}
+	return nil
+}
+
+func (u *unmarshaller) readBuffers(v reflect.Value, tag *fieldTag) error {
+	switch v.Kind() {
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := u.readBuffers(v.Index(i), &fieldTag{}); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields, switchIndex, err := structFields(v.Type())
+		if err != nil {
+			return err
+		}
+		if switchIndex >= 0 {
+			value, err := switchValue(v.Field(fields[switchIndex].index))
+			if err != nil {
+				return err
+			}
+			arm := unionArm(fields, switchIndex, value)
+			if arm == nil {
+				return nil
+			}
+			fields = []field{*arm}
+		}
+		for _, f := range fields {
+			if err = u.readBuffers(v.Field(f.index), f.tag); err != nil {
+				return fmt.Errorf("%s: %s", f.name, err)
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		if u.skip[v.Addr().Pointer()] {
+			return nil
+		}
+		return u.readPointee(v.Elem(), tag)
+	case reflect.String:
+		if !u.present[v.Addr().Pointer()] {
+			return nil
+		}
+		return u.readPointee(v, tag)
+	case reflect.Slice:
+		if tag.inline {
+			for i := 0; i < v.Len(); i++ {
+				if err := u.readBuffers(v.Index(i), &fieldTag{}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if v.IsNil() {
+			return nil
+		}
+		return u.readPointee(v, tag)
+	}
+	return nil
+}
+
+// 读取指针指向的数据本身
+func (u *unmarshaller) readPointee(v reflect.Value, tag *fieldTag) error {
+	switch v.Kind() {
+	case reflect.String:
+		if _, err := u.word(); err != nil { // MaxCount
+			return err
+		}
+		if _, err := u.word(); err != nil { // Offset
+			return err
+		}
+		charSize := 2
+		if tag.ascii {
+			charSize = 1
+		}
+		n, err := u.count(charSize)
+		if err != nil {
+			return err
+		}
+		b, err := u.read(n * charSize)
+		if err != nil {
+			return err
+		}
+		s := string(b)
+		if !tag.ascii {
+			s = encoder.FromUnicode(b)
+		}
+		v.SetString(strings.TrimRight(s, "\x00"))
+		return nil
+	case reflect.Slice:
+		// 元素大小不小于对齐大小，用于检查长度是否合理
+		elemSize := alignOf(v.Type().Elem(), &fieldTag{}, u.ndr64)
+		n, err := u.count(elemSize)
+		if err != nil {
+			return err
+		}
+		if tag.varying {
+			if _, err = u.word(); err != nil { // Offset
+				return err
+			}
+			if n, err = u.count(elemSize); err != nil {
+				return err
+			}
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := u.read(n)
+			if err != nil {
+				return err
+			}
+			copy(v.Bytes(), b)
+			return nil
+		}
+		for i := 0; i < n; i++ {
+			if err := u.readScalars(v.Index(i), &fieldTag{}); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < n; i++ {
+			if err := u.readBuffers(v.Index(i), &fieldTag{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := u.readScalars(v, &fieldTag{}); err != nil {
+		return err
+	}
+	return u.readBuffers(v, &fieldTag{})
+}
@@ -135,6 +135,37 @@ const (
 	RPC_X_SS_WRONG_STUB_VERSION     = 0x00000725
 )
 
+// fault PDU中的nca状态码
+// C706 Appendix E Reject Status Codes and Parameters
+const (
+	NCA_S_FAULT_INT_DIV_BY_ZERO     = 0x1C000001
+	NCA_S_FAULT_ADDR_ERROR          = 0x1C000002
+	NCA_S_FAULT_INVALID_TAG         = 0x1C000006
+	NCA_S_FAULT_INVALID_BOUND       = 0x1C000007
+	NCA_S_RPC_VERSION_MISMATCH      = 0x1C000008
+	NCA_S_UNSPEC_REJECT             = 0x1C000009
+	NCA_S_MANAGER_NOT_ENTERED       = 0x1C00000C
+	NCA_S_FAULT_CANCEL              = 0x1C00000D
+	NCA_S_FAULT_UNSPEC              = 0x1C000012
+	NCA_S_FAULT_REMOTE_COMM_FAILURE = 0x1C000013
+	NCA_S_FAULT_CONTEXT_MISMATCH    = 0x1C00001A
+	NCA_S_FAULT_REMOTE_NO_MEMORY    = 0x1C00001B
+	NCA_S_INVALID_PRES_CONTEXT_ID   = 0x1C00001C
+	NCA_S_UNSUPPORTED_AUTHN_LEVEL   = 0x1C00001D
+	NCA_S_INVALID_CHECKSUM          = 0x1C00001F
+	NCA_S_INVALID_CRC               = 0x1C000020
+	NCA_S_FAULT_USER_DEFINED        = 0x1C000021
+	NCA_S_FAULT_OBJECT_NOT_FOUND    = 0x1C000024
+	NCA_S_OP_RNG_ERROR              = 0x1C010002
+	NCA_S_UNK_IF                    = 0x1C010003
+	NCA_S_WRONG_BOOT_TIME           = 0x1C010006
+	NCA_S_YOU_CRASHED               = 0x1C010009
+	NCA_S_PROTO_ERROR               = 0x1C01000B
+	NCA_S_OUT_ARGS_TOO_BIG          = 0x1C010013
+	NCA_S_SERVER_TOO_BUSY           = 0x1C010014
+	NCA_S_UNSUPPORTED_TYPE          = 0x1C010017
+)
+
 var RpcStatusCodes = map[uint32]string{
 	EPT_S_CANT_CREATE:            "An entry into the endpoint mapper database cannot be created.",
 	EPT_S_CANT_PERFORM_OP:        "General failure when trying to perform an operation on the endpoint mapper database.",
@@ -258,4 +289,30 @@ var RpcStatusCodes = map[uint32]string{
 	RPC_X_SS_INVALID_BUFFER:         "The buffer is not valid for the operation.",
 	RPC_X_SS_WRONG_ES_VERSION:       "The software version is incorrect.",
 	RPC_X_SS_WRONG_STUB_VERSION:     "The stub version is incorrect.",
+	NCA_S_FAULT_INT_DIV_BY_ZERO:     "Integer division by zero in the server.",
+	NCA_S_FAULT_ADDR_ERROR:          "Address error in the server.",
+	NCA_S_FAULT_INVALID_TAG:         "Invalid union discriminant.",
+	NCA_S_FAULT_INVALID_BOUND:       "Array bound is out of range.",
+	NCA_S_RPC_VERSION_MISMATCH:      "The rpc protocol version is not supported.",
+	NCA_S_UNSPEC_REJECT:             "The call was rejected for an unspecified reason.",
+	NCA_S_MANAGER_NOT_ENTERED:       "The server manager routine was not entered.",
+	NCA_S_FAULT_CANCEL:              "The call was cancelled.",
+	NCA_S_FAULT_UNSPEC:              "Unspecified fault in the server.",
+	NCA_S_FAULT_REMOTE_COMM_FAILURE: "The server failed to communicate with a remote server.",
+	NCA_S_FAULT_CONTEXT_MISMATCH:    "The context handle does not match any known context.",
+	NCA_S_FAULT_REMOTE_NO_MEMORY:    "The server is out of memory.",
+	NCA_S_INVALID_PRES_CONTEXT_ID:   "The presentation context id is invalid.",
+	NCA_S_UNSUPPORTED_AUTHN_LEVEL:   "The authentication level is not supported.",
+	NCA_S_INVALID_CHECKSUM:          "The checksum of the packet is invalid.",
+	NCA_S_INVALID_CRC:               "The crc of the packet is invalid.",
+	NCA_S_FAULT_USER_DEFINED:        "User defined fault.",
+	NCA_S_FAULT_OBJECT_NOT_FOUND:    "The object was not found.",
+	NCA_S_OP_RNG_ERROR:              "The operation number is out of range.",
+	NCA_S_UNK_IF:                    "The interface is unknown to the server.",
+	NCA_S_WRONG_BOOT_TIME:           "The server boot time does not match.",
+	NCA_S_YOU_CRASHED:               "The server believes the client crashed.",
+	NCA_S_PROTO_ERROR:               "Rpc protocol error.",
+	NCA_S_OUT_ARGS_TOO_BIG:          "The output arguments are too big.",
+	NCA_S_SERVER_TOO_BUSY:           "The server is too busy.",
+	NCA_S_UNSUPPORTED_TYPE:          "Unsupported data type.",
 }
@@ -0,0 +1,98 @@
+package v5
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// 此文件提供字符串绑定解析
+// https://pubs.opengroup.org/onlinepubs/9629399/apdxi.htm
+
+// 协议序列
+const (
+	ProtSeqNp    = "ncacn_np"     // smb命名管道
+	ProtSeqTCP   = "ncacn_ip_tcp" // tcp
+	ProtSeqUDP   = "ncadg_ip_udp"
+	ProtSeqLRPC  = "ncalrpc" // 本地rpc
+	ProtSeqHTTP  = "ncacn_http"
+	pipeFullName = "\\pipe\\"
+)
+
+// 字符串绑定，格式为[ObjectUUID@]ProtocolSequence:NetworkAddress[Endpoint,Option=Value]
+// 例如：ncacn_np:10.0.0.1[\pipe\svcctl]、ncacn_ip_tcp:10.0.0.1[49667]
+type StringBinding struct {
+	ObjectUUID       string
+	ProtocolSequence string
+	NetworkAddress   string
+	Endpoint         string
+	Options          map[string]string
+}
+
+// 解析字符串绑定
+func ParseStringBinding(s string) (*StringBinding, error) {
+	b := &StringBinding{Options: make(map[string]string)}
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "@"); i >= 0 && i < strings.Index(s, ":") {
+		b.ObjectUUID, s = s[:i], s[i+1:]
+	}
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return nil, errors.New("Invalid string binding, missing protocol sequence")
+	}
+	b.ProtocolSequence, s = strings.ToLower(s[:i]), s[i+1:]
+	if i = strings.Index(s, "["); i < 0 {
+		b.NetworkAddress = s
+		return b, nil
+	}
+	if !strings.HasSuffix(s, "]") {
+		return nil, errors.New("Invalid string binding, missing ]")
+	}
+	b.NetworkAddress = s[:i]
+	for n, item := range strings.Split(s[i+1:len(s)-1], ",") {
+		item = strings.TrimSpace(item)
+		kv := strings.SplitN(item, "=", 2)
+		switch {
+		case len(kv) == 2 && strings.EqualFold(kv[0], "endpoint"):
+			b.Endpoint = kv[1]
+		case len(kv) == 2:
+			b.Options[kv[0]] = kv[1]
+		case n == 0:
+			b.Endpoint = item
+		case item != "":
+			return nil, errors.New("Invalid string binding option " + item)
+		}
+	}
+	return b, nil
+}
+
+// 转换为字符串绑定
+func (b *StringBinding) String() string {
+	var sb strings.Builder
+	if b.ObjectUUID != "" {
+		sb.WriteString(b.ObjectUUID + "@")
+	}
+	sb.WriteString(b.ProtocolSequence + ":" + b.NetworkAddress)
+	if b.Endpoint == "" && len(b.Options) == 0 {
+		return sb.String()
+	}
+	items := []string{b.Endpoint}
+	keys := make([]string, 0, len(b.Options))
+	for k := range b.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		items = append(items, k+"="+b.Options[k])
+	}
+	sb.WriteString("[" + strings.Join(items, ",") + "]")
+	return sb.String()
+}
+
+// 命名管道终结点对应的管道名，\pipe\svcctl返回svcctl
+func (b *StringBinding) PipeName() string {
+	if len(b.Endpoint) >= len(pipeFullName) && strings.EqualFold(b.Endpoint[:len(pipeFullName)], pipeFullName) {
+		return b.Endpoint[len(pipeFullName):]
+	}
+	return strings.TrimPrefix(b.Endpoint, "\\")
+}
@@ -0,0 +1,127 @@
+package v5
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStringBinding(t *testing.T) {
+	tests := []struct {
+		s    string
+		want StringBinding
+	}{
+		{"ncacn_np:10.0.0.1[\\pipe\\svcctl]", StringBinding{
+			ProtocolSequence: ProtSeqNp,
+			NetworkAddress:   "10.0.0.1",
+			Endpoint:         "\\pipe\\svcctl",
+		}},
+		{"ncacn_ip_tcp:10.0.0.1", StringBinding{
+			ProtocolSequence: ProtSeqTCP,
+			NetworkAddress:   "10.0.0.1",
+		}},
+		{"906b0ce0-c70b-1067-b317-00dd010662da@ncacn_ip_tcp:10.0.0.1[49667]", StringBinding{
+			ObjectUUID:       "906b0ce0-c70b-1067-b317-00dd010662da",
+			ProtocolSequence: ProtSeqTCP,
+			NetworkAddress:   "10.0.0.1",
+			Endpoint:         "49667",
+		}},
+		{"ncacn_np:host[\\pipe\\lsarpc,security=impersonation]", StringBinding{
+			ProtocolSequence: ProtSeqNp,
+			NetworkAddress:   "host",
+			Endpoint:         "\\pipe\\lsarpc",
+			Options:          map[string]string{"security": "impersonation"},
+		}},
+		{"NCACN_IP_TCP:host[endpoint=135]", StringBinding{
+			ProtocolSequence: ProtSeqTCP,
+			NetworkAddress:   "host",
+			Endpoint:         "135",
+		}},
+		{"ncalrpc:[epmapper]", StringBinding{
+			ProtocolSequence: ProtSeqLRPC,
+			Endpoint:         "epmapper",
+		}},
+	}
+	for _, tt := range tests {
+		b, err := ParseStringBinding(tt.s)
+		if err != nil {
+			t.Errorf("ParseStringBinding(%q): %v", tt.s, err)
+			continue
+		}
+		if tt.want.Options == nil {
+			tt.want.Options = map[string]string{}
+		}
+		if !reflect.DeepEqual(*b, tt.want) {
+			t.Errorf("ParseStringBinding(%q) = %+v, want %+v", tt.s, *b, tt.want)
+		}
+	}
+}
+
+func TestParseStringBindingErrors(t *testing.T) {
+	for _, s := range []string{
+		"10.0.0.1",
+		":10.0.0.1[135]",
+		"ncacn_np:10.0.0.1[\\pipe\\svcctl",
+		"ncacn_ip_tcp:10.0.0.1[135,bogus]",
+	} {
+		if b, err := ParseStringBinding(s); err == nil {
+			t.Errorf("ParseStringBinding(%q) = %+v, want error", s, b)
+		}
+	}
+}
+
+// String的结果可以重新解析为相同的绑定，选项按名称排序
+func TestStringBindingRoundTrip(t *testing.T) {
+	tests := []struct {
+		b    StringBinding
+		want string
+	}{
+		{StringBinding{ProtocolSequence: ProtSeqTCP, NetworkAddress: "10.0.0.1"}, "ncacn_ip_tcp:10.0.0.1"},
+		{StringBinding{
+			ObjectUUID:       "906b0ce0-c70b-1067-b317-00dd010662da",
+			ProtocolSequence: ProtSeqNp,
+			NetworkAddress:   "host",
+			Endpoint:         "\\pipe\\svcctl",
+			Options:          map[string]string{"b": "2", "a": "1"},
+		}, "906b0ce0-c70b-1067-b317-00dd010662da@ncacn_np:host[\\pipe\\svcctl,a=1,b=2]"},
+		{StringBinding{
+			ProtocolSequence: ProtSeqTCP,
+			NetworkAddress:   "host",
+			Options:          map[string]string{"a": "1"},
+		}, "ncacn_ip_tcp:host[,a=1]"},
+	}
+	for _, tt := range tests {
+		s := tt.b.String()
+		if s != tt.want {
+			t.Errorf("String() = %q, want %q", s, tt.want)
+		}
+		b, err := ParseStringBinding(s)
+		if err != nil {
+			t.Fatalf("ParseStringBinding(%q): %v", s, err)
+		}
+		if tt.b.Options == nil {
+			tt.b.Options = map[string]string{}
+		}
+		if !reflect.DeepEqual(*b, tt.b) {
+			t.Errorf("ParseStringBinding(%q) = %+v, want %+v", s, *b, tt.b)
+		}
+	}
+}
+
+func TestPipeName(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"\\pipe\\svcctl", "svcctl"},
+		{"\\PIPE\\srvsvc", "srvsvc"},
+		{"\\wkssvc", "wkssvc"},
+		{"lsarpc", "lsarpc"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		b := StringBinding{ProtocolSequence: ProtSeqNp, Endpoint: tt.endpoint}
+		if got := b.PipeName(); got != tt.want {
+			t.Errorf("PipeName(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+	}
+}
@@ -1,123 +1,302 @@
 package v5
 
 import (
+	"encoding/binary"
 	"encoding/hex"
-	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc/ndr"
 	"github.com/Amzza0x00/go-impacket/pkg/ms"
 	"github.com/Amzza0x00/go-impacket/pkg/util"
+	"strings"
 )
 
 // 此文件提供epmapper rpc接口
 // https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-rpce/86fc67d3-f44c-4a14-afeb-1e46048841c5
 
-// 绑定epmapper接口
-func (c *TCPClient) RpcBindEpmapper(callId uint32) (err error) {
-	ctxs := []CtxItemStruct{{
-		NumTransItems: 1,
-		AbstractSyntax: SyntaxIDStruct{
-			UUID:    util.PDUUuidFromBytes(ms.EPMv4_UUID),
-			Version: ms.EPMv4_VERSION,
-		},
-		TransferSyntax: SyntaxIDStruct{
-			UUID:    util.PDUUuidFromBytes(ms.NDR_UUID),
-			Version: ms.NDR_VERSION,
-		}}}
-	_, err = c.MSRPCBind(callId, ctxs)
+// opnum
+const (
+	EptInsert           = 0
+	EptDelete           = 1
+	EptLookup           = 2
+	EptMap              = 3
+	EptLookupHandleFree = 4
+)
+
+// ept_lookup查询类型
+const (
+	RPC_C_EP_ALL_ELTS      = 0
+	RPC_C_EP_MATCH_BY_IF   = 1
+	RPC_C_EP_MATCH_BY_OBJ  = 2
+	RPC_C_EP_MATCH_BY_BOTH = 3
+)
+
+// ept_lookup版本匹配方式
+const (
+	RPC_C_VERS_ALL        = 1
+	RPC_C_VERS_COMPATIBLE = 2
+	RPC_C_VERS_EXACT      = 3
+	RPC_C_VERS_MAJOR_ONLY = 4
+	RPC_C_VERS_UPTO       = 5
+)
+
+// 没有更多端点，ept_lookup迭代结束
+const EP_S_NOT_REGISTERED = 0x16C9A0D6
+
+// 每次ept_lookup请求返回的最大条目数
+const eptMaxEntries = 500
+
+// 连接目标的epmapper并绑定接口，使用完成后需要Close
+func OpenEpmapper(host string, port int, debug bool) (*RPCClient, error) {
+	transport := NewTCPStreamTransport(host, port, debug)
+	if err := transport.Connect(); err != nil {
+		return nil, err
+	}
+	rpc := NewRPCClient(transport, debug)
+	if err := rpc.Bind(ms.EPMv4_UUID, ms.EPMv4_VERSION); err != nil {
+		transport.Close()
+		return nil, err
+	}
+	return rpc, nil
+}
+
+// rpc接口标识
+type rpcIfId struct {
+	UUID      [16]byte
+	VersMajor uint16
+	VersMinor uint16
+}
+
+// ept_lookup请求
+type eptLookupRequest struct {
+	InquiryType uint32
+	Object      *[16]byte
+	Ifid        *rpcIfId
+	VersOption  uint32
+	EntryHandle ndr.ContextHandle
+	MaxEnts     uint32
+}
+
+// 端点映射条目
+type EPMEntry struct {
+	Object     string
+	Annotation string
+	Tower      *Tower // 协议塔为空或者无法解析时为空
+}
+
+// ept_lookup查询全部端点，使用返回的entry handle迭代直到EP_S_NOT_REGISTERED
+func (r *RPCClient) EPMLookupRequest() (entries []EPMEntry, err error) {
+	req := eptLookupRequest{
+		InquiryType: RPC_C_EP_ALL_ELTS,
+		VersOption:  RPC_C_VERS_ALL,
+		MaxEnts:     eptMaxEntries,
+	}
+	for {
+		r.Debug("Sending EPM Lookup request", nil)
+		stub, err := ndr.Marshal(&req)
+		if err != nil {
+			r.Debug("", err)
+			return entries, err
+		}
+		buf, err := r.Call(EptLookup, stub)
+		if err != nil {
+			return entries, err
+		}
+		r.Debug("Unmarshalling EPMLookup response", nil)
+		handle, items, status, err := parseEptLookupResponse(buf)
+		if err != nil {
+			r.Debug("Raw:\n"+hex.Dump(buf), err)
+			return entries, err
+		}
+		entries = append(entries, items...)
+		switch {
+		case status == EP_S_NOT_REGISTERED:
+			return entries, nil
+		case status != 0:
+			return entries, rpcStatusError("ept_lookup", status)
+		case handle.IsZero() || len(items) == 0:
+			return entries, nil
+		}
+		req.EntryHandle = handle
+	}
+}
+
+// 解析ept_lookup响应，annotation为结构体内的varying数组，无法使用ndr解码
+// entry_handle、num_ents、entries[]（max count、offset、actual count、条目、延迟的协议塔）、status
+func parseEptLookupResponse(buf []byte) (handle ndr.ContextHandle, entries []EPMEntry, status uint32, err error) {
+	r := &stubReader{buf: buf}
+	b, err := r.bytes(20)
 	if err != nil {
-		c.Debug("", err)
-		return err
+		return handle, nil, 0, err
 	}
-	return nil
+	handle.Attributes = binary.LittleEndian.Uint32(b)
+	copy(handle.UUID[:], b[4:])
+	if _, err = r.uint32(); err != nil { // num_ents
+		return handle, nil, 0, err
+	}
+	// max count、offset、actual count
+	var count uint32
+	for i := 0; i < 3; i++ {
+		if count, err = r.uint32(); err != nil {
+			return handle, nil, 0, err
+		}
+	}
+	if uint64(count)*28 > uint64(len(buf)) {
+		return handle, nil, 0, errors.New("Invalid ept_lookup response")
+	}
+	referents := make([]uint32, count)
+	for i := range referents {
+		var entry EPMEntry
+		// 上一个条目的annotation长度不一定为4的倍数，条目按4字节对齐
+		r.align(4)
+		if b, err = r.bytes(16); err != nil {
+			return handle, nil, 0, err
+		}
+		entry.Object = util.PDUUuidToString(b)
+		if referents[i], err = r.uint32(); err != nil {
+			return handle, nil, 0, err
+		}
+		if _, err = r.uint32(); err != nil { // offset
+			return handle, nil, 0, err
+		}
+		n, err := r.uint32()
+		if err != nil {
+			return handle, nil, 0, err
+		}
+		if b, err = r.bytes(int(n)); err != nil {
+			return handle, nil, 0, err
+		}
+		entry.Annotation = strings.TrimRight(string(b), "\x00")
+		entries = append(entries, entry)
+	}
+	// 协议塔：max count、tower_length、tower_octet_string
+	for i, referent := range referents {
+		if referent == 0 {
+			continue
+		}
+		if _, err = r.uint32(); err != nil {
+			return handle, nil, 0, err
+		}
+		n, err := r.uint32()
+		if err != nil {
+			return handle, nil, 0, err
+		}
+		if b, err = r.bytes(int(n)); err != nil {
+			return handle, nil, 0, err
+		}
+		// 无法解析的协议塔不影响其他条目
+		entries[i].Tower, _ = ParseTower(b)
+	}
+	if status, err = r.uint32(); err != nil {
+		return handle, nil, 0, err
+	}
+	return handle, entries, status, nil
 }
 
-// lookup request请求结构
-type EPMLookupRequestStruct struct {
-	MSRPCHeaderStruct
-	AllocHint            uint32 `smb:"len:EndpointMapperLookup"` // Endpoint Mapper lookup长度
-	ContextId            uint16
-	Opnum                uint16
-	EndpointMapperLookup endpointMapperLookup
+// 按NDR对齐读取stub数据
+type stubReader struct {
+	buf []byte
+	off int
 }
 
-type endpointMapperLookup struct {
-	InquiryType          uint32
-	NullPointerObject    uint32
-	NullPointerInterface uint32
-	VersionOption        uint32
-	EntryHandle          []byte `smb:"fixed:20"`
-	MaxEntries           uint32
+func (r *stubReader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.off+n > len(r.buf) {
+		return nil, errors.New("Stub data is truncated")
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b, nil
+}
+
+func (r *stubReader) align(n int) {
+	r.off = (r.off + n - 1) &^ (n - 1)
 }
 
-func NewEPMLookupRequest() EPMLookupRequestStruct {
-	header := NewMSRPCHeader()
-	header.PacketType = PDURequest
-	header.PacketFlags = PDUFault
-	header.FragLength = 24
-	return EPMLookupRequestStruct{
-		MSRPCHeaderStruct: header,
-		Opnum:             2,
-		EndpointMapperLookup: endpointMapperLookup{
-			InquiryType:          0,
-			NullPointerObject:    0,
-			NullPointerInterface: 0,
-			VersionOption:        1,
-			EntryHandle:          make([]byte, 20),
-			MaxEntries:           500,
-		},
+func (r *stubReader) uint32() (uint32, error) {
+	r.align(4)
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
 	}
+	return binary.LittleEndian.Uint32(b), nil
 }
 
-type EPMLookupResponseStruct struct {
-	MSRPCHeaderStruct
-	AllocHint   uint32
-	ContextId   uint16
-	CancelCount uint8
-	Reserved    uint8
-	// epm结构
-	EntryHandle        []byte `smb:"fixed:20"`
-	NumEntries         uint32
-	EntriesMaxCount    uint32
-	EntriesOffset      uint32
-	EntriesActualCount uint32
-	// 块结构
-	Entry     []EntryStruct `smb:"value:EntriesActualCount"`
-	Reserved2 []byte        `smb:"fixed:3"`
-	// 块的具体数据
-	EntryTowerPointer []EntryTowerPointerStruct `smb:"value:EntriesActualCount"`
-	ReturnCode        uint32
+// 协议塔，conformant结构体
+type twrT struct {
+	TowerLength      uint32
+	TowerOctetString []byte `ndr:"inline"`
 }
 
-type EntryStruct struct {
-	Object           []byte `smb:"fixed:16"`
-	ReferentID       []byte `smb:"fixed:4"`
-	AnnotationOffset uint32
-	AnnotationLength uint32
-	Annotation       []byte `smb:"dynamic:AnnotationLength:4"` // 根据AnnotationLength动态设置长度，最小长度4字节，并且如果长度不能整除4就填充00直到能整除
+// ept_map请求
+type eptMapRequest struct {
+	Object      *[16]byte
+	MapTower    *twrT `ndr:"ptr"`
+	EntryHandle ndr.ContextHandle
+	MaxTowers   uint32
 }
 
-type EntryTowerPointerStruct struct {
-	Length         uint32 // 数据总长度
-	Length1        uint32
-	NumberOfFloors uint16 // 数量
-	Buffer         []byte `smb:"value:Length"` // 可变的
+type eptMapResponse struct {
+	EntryHandle ndr.ContextHandle
+	NumTowers   uint32
+	Towers      []*twrT `ndr:"ref,varying"`
+	Status      uint32
 }
 
-func NewEPMLookupResponse() EPMLookupResponseStruct {
-	return EPMLookupResponseStruct{}
+// 每次ept_map请求返回的最大协议塔数量
+const eptMaxTowers = 4
+
+// ept_map查询接口在指定协议序列上的端点，返回第一个匹配的字符串绑定
+// version与SyntaxIDStruct.Version相同，服务端未返回地址时NetworkAddress为空
+func (r *RPCClient) EPMMapRequest(uuid string, version uint32, protocolSequence string) (*StringBinding, error) {
+	tower, err := NewTower(uuid, version, protocolSequence)
+	if err != nil {
+		return nil, err
+	}
+	req := eptMapRequest{
+		Object:    new([16]byte),
+		MapTower:  &twrT{TowerLength: uint32(len(tower)), TowerOctetString: tower},
+		MaxTowers: eptMaxTowers,
+	}
+	var res eptMapResponse
+	r.Debug("Sending EPM Map request", nil)
+	if err = r.Request(EptMap, &req, &res); err != nil {
+		return nil, err
+	}
+	switch {
+	case res.Status == EP_S_NOT_REGISTERED:
+		return nil, errors.New("Failed to ept_map: interface " + uuid + " is not registered")
+	case res.Status != 0:
+		return nil, rpcStatusError("ept_map", res.Status)
+	}
+	for _, t := range res.Towers {
+		if t == nil {
+			continue
+		}
+		parsed, err := ParseTower(t.TowerOctetString)
+		if err != nil || parsed.Binding.ProtocolSequence != protocolSequence {
+			continue
+		}
+		binding := parsed.Binding
+		if binding.NetworkAddress == "0.0.0.0" {
+			binding.NetworkAddress = ""
+		}
+		return &binding, nil
+	}
+	return nil, errors.New("Failed to ept_map: no endpoint for interface " + uuid)
 }
 
-func (c *TCPClient) EPMLookupRequest(callId uint32) (res EPMLookupResponseStruct, err error) {
-	c.Debug("Sending EPM Lookup request", nil)
-	req := NewEPMLookupRequest()
-	fragLength := util.SizeOfStruct(req.EndpointMapperLookup)
-	req.FragLength += uint16(fragLength)
-	req.CallId = callId
-	buf, err := c.TCPSend(req)
-	// 解析响应内容
-	res = NewEPMLookupResponse()
-	c.Debug("Unmarshalling EPMLookup response", nil)
-	if err = encoder.Unmarshal(buf, &res); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
-	}
-	return res, nil
+// 连接目标的epmapper解析接口所在的端点，服务端未返回地址时使用目标地址
+func ResolveEndpoint(host string, uuid string, version uint32, protocolSequence string, debug bool) (*StringBinding, error) {
+	rpc, err := OpenEpmapper(host, 135, debug)
+	if err != nil {
+		return nil, err
+	}
+	defer rpc.Close()
+	binding, err := rpc.EPMMapRequest(uuid, version, protocolSequence)
+	if err != nil {
+		return nil, err
+	}
+	if binding.NetworkAddress == "" {
+		binding.NetworkAddress = host
+	}
+	return binding, nil
 }
@@ -0,0 +1,207 @@
+package v5
+
+import (
+	"encoding/binary"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc/ndr"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"github.com/Amzza0x00/go-impacket/pkg/util"
+	"testing"
+)
+
+type testEntry struct {
+	object     string
+	annotation string
+	tower      []byte // 为空时协议塔指针为空
+}
+
+func testAlign(buf []byte) []byte {
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// 编码ept_lookup响应
+func testEptLookupResponse(handle ndr.ContextHandle, entries []testEntry, status uint32) []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, handle.Attributes)
+	buf = append(buf, handle.UUID[:]...)
+	for i := 0; i < 4; i++ { // num_ents、max count、offset、actual count
+		n := len(entries)
+		if i == 2 {
+			n = 0
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(n))
+	}
+	for i, e := range entries {
+		buf = append(testAlign(buf), util.PDUUuidFromBytes(e.object)...)
+		var referent uint32
+		if e.tower != nil {
+			referent = uint32(0x20000 + i)
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, referent)
+		buf = binary.LittleEndian.AppendUint32(buf, 0)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(e.annotation)+1))
+		buf = append(append(buf, e.annotation...), 0)
+	}
+	for _, e := range entries {
+		if e.tower == nil {
+			continue
+		}
+		buf = binary.LittleEndian.AppendUint32(testAlign(buf), uint32(len(e.tower)))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(e.tower)))
+		buf = append(buf, e.tower...)
+	}
+	return binary.LittleEndian.AppendUint32(testAlign(buf), status)
+}
+
+func testTCPTower(port byte) []byte {
+	return testTower(append(testTowerFloors(),
+		TowerFloor{Lhs: []byte{EPM_PROTOCOL_NCACN}, Rhs: []byte{0, 0}},
+		TowerFloor{Lhs: []byte{EPM_PROTOCOL_TCP}, Rhs: []byte{0xc0, port}},
+		TowerFloor{Lhs: []byte{EPM_PROTOCOL_IP}, Rhs: []byte{10, 0, 0, 1}},
+	)...)
+}
+
+func TestParseEptLookupResponse(t *testing.T) {
+	handle := testHandle()
+	object := "00000000-0000-0000-0000-000000000000"
+	tests := []struct {
+		name    string
+		entries []testEntry
+		status  uint32
+	}{
+		{"single", []testEntry{{object, "Server service", testTCPTower(1)}}, 0},
+		// annotation长度分别为奇数、4的倍数以及空，之后的条目需要重新对齐
+		{"odd annotations", []testEntry{
+			{object, "a", testTCPTower(1)},
+			{ms.NTSVCS_UUID, "abcd", testTCPTower(2)},
+			{object, "", testTCPTower(3)},
+			{object, "Impl friendly name", testTCPTower(4)},
+		}, 0},
+		{"null towers", []testEntry{
+			{object, "abc", nil},
+			{object, "abcde", testTCPTower(2)},
+			{object, "", nil},
+		}, 0},
+		// 协议塔长度为奇数
+		{"odd tower", []testEntry{
+			{object, "x", append(testTCPTower(1), 0)},
+			{object, "y", testTCPTower(2)},
+		}, 0},
+		{"not registered", nil, EP_S_NOT_REGISTERED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := testEptLookupResponse(handle, tt.entries, tt.status)
+			gotHandle, entries, status, err := parseEptLookupResponse(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotHandle != handle || status != tt.status {
+				t.Errorf("handle = %x status = 0x%08x", gotHandle.UUID, status)
+			}
+			if len(entries) != len(tt.entries) {
+				t.Fatalf("got %d entries, want %d", len(entries), len(tt.entries))
+			}
+			for i, e := range entries {
+				want := tt.entries[i]
+				if e.Object != want.object || e.Annotation != want.annotation {
+					t.Errorf("entry %d: object %s annotation %q, want %s %q", i, e.Object, e.Annotation, want.object, want.annotation)
+				}
+				if want.tower == nil {
+					if e.Tower != nil {
+						t.Errorf("entry %d: tower = %+v, want nil", i, e.Tower)
+					}
+					continue
+				}
+				if e.Tower == nil {
+					t.Fatalf("entry %d: tower is nil", i)
+				}
+				if e.Tower.InterfaceUUID != ms.SRVSVC_UUID || e.Tower.Binding.ProtocolSequence != ProtSeqTCP || e.Tower.Binding.NetworkAddress != "10.0.0.1" {
+					t.Errorf("entry %d: tower = %+v", i, e.Tower)
+				}
+			}
+		})
+	}
+}
+
+// 无法解析的协议塔不影响其他条目
+func TestParseEptLookupResponseInvalidTower(t *testing.T) {
+	buf := testEptLookupResponse(testHandle(), []testEntry{
+		{ms.NTSVCS_UUID, "bad", []byte{1, 0, 0}},
+		{ms.NTSVCS_UUID, "good", testTCPTower(1)},
+	}, 0)
+	_, entries, _, err := parseEptLookupResponse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Tower != nil || entries[1].Tower == nil || entries[1].Tower.Binding.Endpoint != "49153" {
+		t.Errorf("entries = %+v", entries)
+	}
+}
+
+func TestParseEptLookupResponseTruncated(t *testing.T) {
+	buf := testEptLookupResponse(testHandle(), []testEntry{
+		{ms.NTSVCS_UUID, "abc", testTCPTower(1)},
+		{ms.NTSVCS_UUID, "", nil},
+	}, 0)
+	for n := 0; n < len(buf); n++ {
+		if _, _, _, err := parseEptLookupResponse(buf[:n]); err == nil {
+			t.Errorf("parsed response truncated to %d of %d bytes", n, len(buf))
+		}
+	}
+	// 条目数量超出数据长度
+	binary.LittleEndian.PutUint32(buf[32:], 0xffff)
+	if _, _, _, err := parseEptLookupResponse(buf); err == nil {
+		t.Error("parsed response with invalid entry count")
+	}
+}
+
+// ept_map返回的协议塔中选择请求的协议序列，地址为0.0.0.0时NetworkAddress为空
+func TestEPMMapRequest(t *testing.T) {
+	np := testTower(append(testTowerFloors(),
+		TowerFloor{Lhs: []byte{EPM_PROTOCOL_NCACN}, Rhs: []byte{0, 0}},
+		TowerFloor{Lhs: []byte{EPM_PROTOCOL_SMB}, Rhs: []byte("\\PIPE\\srvsvc\x00")},
+		TowerFloor{Lhs: []byte{EPM_PROTOCOL_NETBIOS}, Rhs: []byte("DC01\x00")},
+	)...)
+	tcp := testTower(append(testTowerFloors(),
+		TowerFloor{Lhs: []byte{EPM_PROTOCOL_NCACN}, Rhs: []byte{0, 0}},
+		TowerFloor{Lhs: []byte{EPM_PROTOCOL_TCP}, Rhs: []byte{0xc2, 0x03}},
+		TowerFloor{Lhs: []byte{EPM_PROTOCOL_IP}, Rhs: []byte{0, 0, 0, 0}},
+	)...)
+	res := eptMapResponse{
+		NumTowers: 2,
+		Towers: []*twrT{
+			{TowerLength: uint32(len(np)), TowerOctetString: np},
+			{TowerLength: uint32(len(tcp)), TowerOctetString: tcp},
+		},
+	}
+	stub, err := ndr.Marshal(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &fakeTransport{responses: [][]byte{testPDU(PDUResponse, stub)}}
+	binding, err := NewRPCClient(transport, false).EPMMapRequest(ms.SRVSVC_UUID, 3, ProtSeqTCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binding.ProtocolSequence != ProtSeqTCP || binding.NetworkAddress != "" || binding.Endpoint != "49667" {
+		t.Errorf("binding = %+v", *binding)
+	}
+	if opnum := binary.LittleEndian.Uint16(transport.sent[0][22:]); opnum != EptMap {
+		t.Errorf("opnum = %d, want %d", opnum, EptMap)
+	}
+
+	// 接口未注册或者没有匹配的协议序列
+	stub, _ = ndr.Marshal(&eptMapResponse{Status: EP_S_NOT_REGISTERED})
+	rpc := NewRPCClient(&fakeTransport{responses: [][]byte{testPDU(PDUResponse, stub)}}, false)
+	if _, err = rpc.EPMMapRequest(ms.SRVSVC_UUID, 3, ProtSeqTCP); err == nil {
+		t.Error("unregistered interface resolved")
+	}
+	res.Towers, res.NumTowers = res.Towers[:1], 1
+	stub, _ = ndr.Marshal(&res)
+	rpc = NewRPCClient(&fakeTransport{responses: [][]byte{testPDU(PDUResponse, stub)}}, false)
+	if _, err = rpc.EPMMapRequest(ms.SRVSVC_UUID, 3, ProtSeqTCP); err == nil {
+		t.Error("named pipe tower returned for ncacn_ip_tcp")
+	}
+}
@@ -1,11 +1,12 @@
 package v5
 
 import (
-	"bytes"
 	"encoding/hex"
-	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc/ndr"
 	"github.com/Amzza0x00/go-impacket/pkg/ms"
 	"github.com/Amzza0x00/go-impacket/pkg/util"
+	"unicode/utf16"
 )
 
 // 此文件提供IObjectExporter rpc接口
@@ -23,70 +24,149 @@ const (
 	ServerAlive2 = 5
 )
 
-// ServerAlive2请求结构
-type ServerAlive2RequestStruct struct {
-	MSRPCHeaderStruct
-	AllocHint uint32
-	ContextId uint16
-	Opnum     uint16
-}
-
-type ServerAlive2ResponseStruct struct {
-	MSRPCHeaderStruct
-	AllocHint       uint32
-	ContextId       uint16
-	CancelCount     uint8
-	Reserved        uint8
-	VersionMajor    uint16
-	VersionMinor    uint16
-	Unknown         uint64
-	PpdsaOrBindings AddressStruct
-	Reserved2       uint64
-}
-
-// 解析的地址结构
-type AddressStruct struct {
+// 服务端支持的DCOM版本
+type COMVersion struct {
+	MajorVersion uint16
+	MinorVersion uint16
+}
+
+// DUALSTRINGARRAY，conformant结构体，StringArray前SecurityOffset个元素为字符串绑定，之后为安全绑定
+// MS-DCOM 2.2.19.1 DUALSTRINGARRAY
+type dualStringArray struct {
 	NumEntries     uint16
 	SecurityOffset uint16
-	//Buf            []byte
-	//stringBindingStruct
-	//securityBindingStruct
-}
-
-//type AddressStruct struct {
-//	NumEntries     uint16
-//	SecurityOffset uint16
-//	stringBindingStruct
-//	securityBindingStruct
-//}
-
-// 绑定地址结构
-//type stringBindingStruct struct {
-//	TowerId     uint16
-//	NetworkAddr []byte // 长度不固定
-//}
-//
-//type securityBindingStruct struct {
-//	AuthnSvc  uint16
-//	AuthzSvc  uint16
-//	PrincName uint16
-//}
-
-func NewServerAlive2Request() ServerAlive2RequestStruct {
-	header := NewMSRPCHeader()
-	header.PacketType = PDURequest
-	header.PacketFlags = PDUFault
-	header.FragLength = 24
-	return ServerAlive2RequestStruct{
-		MSRPCHeaderStruct: header,
-		AllocHint:         0,
-		ContextId:         0,
-		Opnum:             ServerAlive2,
-	}
-}
-
-func NewServerAlive2Response() ServerAlive2ResponseStruct {
-	return ServerAlive2ResponseStruct{}
+	StringArray    []uint16 `ndr:"inline"`
+}
+
+// STRINGBINDING，TowerId为协议塔中的协议标识，如EPM_PROTOCOL_TCP
+type ComStringBinding struct {
+	TowerId     uint16
+	NetworkAddr string // 可能带有端点，如10.0.0.1[49667]
+}
+
+// 转换为字符串绑定，不支持的协议标识返回错误
+func (b ComStringBinding) StringBinding() (*StringBinding, error) {
+	var protocolSequence string
+	switch b.TowerId {
+	case EPM_PROTOCOL_TCP:
+		protocolSequence = ProtSeqTCP
+	case EPM_PROTOCOL_UDP:
+		protocolSequence = ProtSeqUDP
+	case EPM_PROTOCOL_SMB:
+		protocolSequence = ProtSeqNp
+	case EPM_PROTOCOL_HTTP:
+		protocolSequence = ProtSeqHTTP
+	case EPM_PROTOCOL_NAMED_PIPE:
+		protocolSequence = ProtSeqLRPC
+	default:
+		return nil, errors.New("Unsupported tower id in string binding")
+	}
+	return ParseStringBinding(protocolSequence + ":" + b.NetworkAddr)
+}
+
+// SECURITYBINDING
+type ComSecurityBinding struct {
+	AuthnSvc  uint16 // 认证类型，如RPC_C_AUTHN_WINNT
+	AuthzSvc  uint16
+	PrincName string
+}
+
+// 解析后的DUALSTRINGARRAY
+type DualStringArray struct {
+	StringBindings   []ComStringBinding
+	SecurityBindings []ComSecurityBinding
+}
+
+// 字符串绑定以及安全绑定都是以0结尾的宽字符串，列表以单独的0结尾
+func parseDualStringArray(d *dualStringArray) (*DualStringArray, error) {
+	if d == nil {
+		return nil, errors.New("Empty DUALSTRINGARRAY")
+	}
+	arr := d.StringArray
+	if int(d.NumEntries) != len(arr) {
+		return nil, errors.New("Invalid DUALSTRINGARRAY entry count")
+	}
+	if int(d.SecurityOffset) > len(arr) {
+		return nil, errors.New("Invalid DUALSTRINGARRAY security offset")
+	}
+	res := &DualStringArray{}
+	bindings := arr[:d.SecurityOffset]
+	for len(bindings) > 0 && bindings[0] != 0 {
+		addr, rest, err := wideString(bindings[1:])
+		if err != nil {
+			return nil, err
+		}
+		res.StringBindings = append(res.StringBindings, ComStringBinding{TowerId: bindings[0], NetworkAddr: addr})
+		bindings = rest
+	}
+	security := arr[d.SecurityOffset:]
+	for len(security) > 0 && security[0] != 0 {
+		if len(security) < 2 {
+			return nil, errors.New("Invalid DUALSTRINGARRAY security binding")
+		}
+		name, rest, err := wideString(security[2:])
+		if err != nil {
+			return nil, err
+		}
+		res.SecurityBindings = append(res.SecurityBindings, ComSecurityBinding{AuthnSvc: security[0], AuthzSvc: security[1], PrincName: name})
+		security = rest
+	}
+	return res, nil
+}
+
+// 读取以0结尾的宽字符串，返回剩余的数据
+func wideString(arr []uint16) (string, []uint16, error) {
+	for i, c := range arr {
+		if c == 0 {
+			return string(utf16.Decode(arr[:i])), arr[i+1:], nil
+		}
+	}
+	return "", nil, errors.New("Unterminated string in DUALSTRINGARRAY")
+}
+
+type serverAlive2Response struct {
+	Version  COMVersion
+	Bindings *dualStringArray
+	Reserved uint32
+	Status   uint32
+}
+
+// ResolveOxid请求，Protseqs为客户端支持的协议标识
+type resolveOxidRequest struct {
+	Oxid        uint64
+	NumProtseqs uint16
+	Protseqs    []uint16 `ndr:"ref"`
+}
+
+type resolveOxidResponse struct {
+	Bindings       *dualStringArray
+	IPIDRemUnknown [16]byte
+	AuthnHint      uint32
+	Status         uint32
+}
+
+type resolveOxid2Response struct {
+	Bindings       *dualStringArray
+	IPIDRemUnknown [16]byte
+	AuthnHint      uint32
+	Version        COMVersion
+	Status         uint32
+}
+
+type simplePingRequest struct {
+	SetId uint64
+}
+
+type simplePingResponse struct {
+	Status uint32
+}
+
+// OXID解析结果，Version只有ResolveOxid2返回
+type OxidBindings struct {
+	Bindings       *DualStringArray
+	IPIDRemUnknown string
+	AuthnHint      uint32 // 客户端应当使用的最低认证级别
+	Version        COMVersion
 }
 
 // 绑定IOXIDResolver接口
@@ -109,53 +189,100 @@ func (c *TCPClient) RpcBindIOXIDResolver(callId uint32) (err error) {
 	return nil
 }
 
-func (c *TCPClient) ServerAlive2Request(callId uint32) (address []string, err error) {
+// ServerAlive2查询服务端的DCOM版本以及全部网络地址，不需要认证
+func (c *TCPClient) ServerAlive2Request(callId uint32) (version COMVersion, bindings *DualStringArray, err error) {
 	c.Debug("Sending ServerAlive2 request", nil)
-	req := NewServerAlive2Request()
-	req.CallId = callId
-	buf, err := c.TCPSend(req)
-	res := NewServerAlive2Response()
+	buf, err := c.MSRPCCall(callId, 0, ServerAlive2, nil)
+	if err != nil {
+		c.Debug("", err)
+		return version, nil, err
+	}
+	var res serverAlive2Response
 	c.Debug("Unmarshalling ServerAlive2 response", nil)
-	if err = encoder.Unmarshal(buf, &res); err != nil {
+	if err = ndr.Unmarshal(buf, &res); err != nil {
 		c.Debug("Raw:\n"+hex.Dump(buf), err)
+		return version, nil, err
 	}
-	// 解析address
-	addressBuf := buf[40:]
-	// 去除securityBinding数据，只保留网卡、ip信息
-	securityBindingIndex := bytes.Index(addressBuf, []byte{9, 00})
-	newAddressBuf := bytes.Split(addressBuf[:securityBindingIndex], []byte{07, 00})
-	for _, i := range newAddressBuf {
-		if i != nil {
-			address = append(address, string(i))
-		}
+	return serverAlive2Result(res)
+}
+
+// ServerAlive2查询服务端的DCOM版本以及全部网络地址，需要先绑定IObjectExporter接口
+func (r *RPCClient) ServerAlive2() (version COMVersion, bindings *DualStringArray, err error) {
+	var res serverAlive2Response
+	r.Debug("Sending ServerAlive2 request", nil)
+	if err = r.Request(ServerAlive2, &struct{}{}, &res); err != nil {
+		return version, nil, err
+	}
+	return serverAlive2Result(res)
+}
+
+func serverAlive2Result(res serverAlive2Response) (COMVersion, *DualStringArray, error) {
+	if res.Status != 0 {
+		return res.Version, nil, rpcStatusError("ServerAlive2", res.Status)
+	}
+	bindings, err := parseDualStringArray(res.Bindings)
+	return res.Version, bindings, err
+}
+
+// ResolveOxid解析OXID所在对象导出器的绑定信息，protseqs为客户端支持的协议标识，为空时只请求ncacn_ip_tcp
+func (r *RPCClient) ResolveOxid(oxid uint64, protseqs ...uint16) (*OxidBindings, error) {
+	var res resolveOxidResponse
+	r.Debug("Sending ResolveOxid request", nil)
+	if err := r.Request(ResolveOxid, newResolveOxidRequest(oxid, protseqs), &res); err != nil {
+		return nil, err
+	}
+	if res.Status != 0 {
+		return nil, rpcStatusError("ResolveOxid", res.Status)
+	}
+	bindings, err := parseDualStringArray(res.Bindings)
+	if err != nil {
+		return nil, err
 	}
-	return address, nil
+	return &OxidBindings{
+		Bindings:       bindings,
+		IPIDRemUnknown: util.PDUUuidToString(res.IPIDRemUnknown[:]),
+		AuthnHint:      res.AuthnHint,
+	}, nil
 }
 
-// ResolveOxid2请求结构
-// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-dcom/65292e10-ef0c-43ee-bce7-788e271cc794
-type ResolveOxid2RequestStruct struct {
-	// 一共32字节
-	OXID           uint64
-	IpIdRemUnknown uint64
-	DwPid          uint32
-	DwTid          uint32
-	Reserved       uint64
+// ResolveOxid2与ResolveOxid相同，同时返回对象导出器的DCOM版本
+func (r *RPCClient) ResolveOxid2(oxid uint64, protseqs ...uint16) (*OxidBindings, error) {
+	var res resolveOxid2Response
+	r.Debug("Sending ResolveOxid2 request", nil)
+	if err := r.Request(ResolveOxid2, newResolveOxidRequest(oxid, protseqs), &res); err != nil {
+		return nil, err
+	}
+	if res.Status != 0 {
+		return nil, rpcStatusError("ResolveOxid2", res.Status)
+	}
+	bindings, err := parseDualStringArray(res.Bindings)
+	if err != nil {
+		return nil, err
+	}
+	return &OxidBindings{
+		Bindings:       bindings,
+		IPIDRemUnknown: util.PDUUuidToString(res.IPIDRemUnknown[:]),
+		AuthnHint:      res.AuthnHint,
+		Version:        res.Version,
+	}, nil
 }
 
-// ResolveOxid2响应结构
-type ResolveOxid2ResponseStruct struct {
-	Reserved uint64
-	oxidBindingsStruct
-	Reserved2    uint16
-	IPID         []byte `smb:"fixed:16"`
-	AuthnHint    uint32
-	VersionMajor uint16
-	VersionMinor uint16
-	HResult      uint32
+func newResolveOxidRequest(oxid uint64, protseqs []uint16) *resolveOxidRequest {
+	if len(protseqs) == 0 {
+		protseqs = []uint16{EPM_PROTOCOL_TCP}
+	}
+	return &resolveOxidRequest{Oxid: oxid, NumProtseqs: uint16(len(protseqs)), Protseqs: protseqs}
 }
 
-type oxidBindingsStruct struct {
-	NumEntries     uint16
-	SecurityOffset uint16
+// SimplePing对ping集合保活，setId由ComplexPing分配
+func (r *RPCClient) SimplePing(setId uint64) error {
+	var res simplePingResponse
+	r.Debug("Sending SimplePing request", nil)
+	if err := r.Request(SimplePing, &simplePingRequest{SetId: setId}, &res); err != nil {
+		return err
+	}
+	if res.Status != 0 {
+		return rpcStatusError("SimplePing", res.Status)
+	}
+	return nil
 }
@@ -0,0 +1,128 @@
+package v5
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// 编码DUALSTRINGARRAY，两个部分都以单独的0结尾
+func testDualStringArray(bindings []ComStringBinding, security []ComSecurityBinding) *dualStringArray {
+	var arr []uint16
+	for _, b := range bindings {
+		arr = append(append(append(arr, b.TowerId), utf16.Encode([]rune(b.NetworkAddr))...), 0)
+	}
+	if len(bindings) == 0 {
+		arr = append(arr, 0)
+	}
+	arr = append(arr, 0)
+	d := &dualStringArray{SecurityOffset: uint16(len(arr))}
+	for _, s := range security {
+		arr = append(append(append(arr, s.AuthnSvc, s.AuthzSvc), utf16.Encode([]rune(s.PrincName))...), 0)
+	}
+	if len(security) == 0 {
+		arr = append(arr, 0)
+	}
+	arr = append(arr, 0)
+	d.NumEntries = uint16(len(arr))
+	d.StringArray = arr
+	return d
+}
+
+func TestParseDualStringArray(t *testing.T) {
+	tests := []struct {
+		name     string
+		bindings []ComStringBinding
+		security []ComSecurityBinding
+	}{
+		{"ipv4", []ComStringBinding{
+			{EPM_PROTOCOL_TCP, "WIN-DC01"},
+			{EPM_PROTOCOL_TCP, "10.0.0.1"},
+		}, []ComSecurityBinding{{RPC_C_AUTHN_WINNT, 0xffff, ""}}},
+		{"ipv6", []ComStringBinding{
+			{EPM_PROTOCOL_TCP, "fe80::1c2b:3d4e:5f60:7a8b%12"},
+			{EPM_PROTOCOL_TCP, "2001:db8::1"},
+		}, []ComSecurityBinding{{RPC_C_AUTHN_WINNT, 0xffff, ""}, {0x10, 0xffff, "host/dc01.corp.local"}}},
+		{"long hostname", []ComStringBinding{
+			{EPM_PROTOCOL_TCP, strings.Repeat("a", 255) + ".corp.local[49667]"},
+		}, nil},
+		{"non tcp tower id", []ComStringBinding{
+			{EPM_PROTOCOL_NAMED_PIPE, "LRPC-0123"},
+			{EPM_PROTOCOL_SPX, "00000000"},
+		}, []ComSecurityBinding{{RPC_C_AUTHN_WINNT, 0xffff, ""}}},
+		{"empty security", []ComStringBinding{{EPM_PROTOCOL_TCP, "10.0.0.1"}}, nil},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := parseDualStringArray(testDualStringArray(tt.bindings, tt.security))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(res.StringBindings, tt.bindings) {
+				t.Errorf("string bindings = %+v, want %+v", res.StringBindings, tt.bindings)
+			}
+			if !reflect.DeepEqual(res.SecurityBindings, tt.security) {
+				t.Errorf("security bindings = %+v, want %+v", res.SecurityBindings, tt.security)
+			}
+		})
+	}
+}
+
+func TestParseDualStringArrayErrors(t *testing.T) {
+	valid := func() *dualStringArray {
+		return testDualStringArray([]ComStringBinding{{EPM_PROTOCOL_TCP, "10.0.0.1"}}, []ComSecurityBinding{{RPC_C_AUTHN_WINNT, 0xffff, ""}})
+	}
+	count := valid()
+	count.NumEntries++
+	offset := valid()
+	offset.SecurityOffset = offset.NumEntries + 1
+	unterminated := valid()
+	unterminated.StringArray = unterminated.StringArray[:3]
+	unterminated.NumEntries, unterminated.SecurityOffset = 3, 3
+	security := valid()
+	security.StringArray = append(security.StringArray[:security.SecurityOffset], RPC_C_AUTHN_WINNT)
+	security.NumEntries = uint16(len(security.StringArray))
+	tests := []struct {
+		name string
+		d    *dualStringArray
+	}{
+		{"nil", nil},
+		{"entry count", count},
+		{"security offset", offset},
+		{"unterminated string binding", unterminated},
+		{"truncated security binding", security},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res, err := parseDualStringArray(tt.d); err == nil {
+				t.Errorf("parseDualStringArray = %+v, want error", res)
+			}
+		})
+	}
+}
+
+func TestComStringBinding(t *testing.T) {
+	tests := []struct {
+		b    ComStringBinding
+		want string
+	}{
+		{ComStringBinding{EPM_PROTOCOL_TCP, "10.0.0.1[49667]"}, "ncacn_ip_tcp:10.0.0.1[49667]"},
+		{ComStringBinding{EPM_PROTOCOL_TCP, "WIN-DC01"}, "ncacn_ip_tcp:WIN-DC01"},
+		{ComStringBinding{EPM_PROTOCOL_NAMED_PIPE, "[LRPC-0123]"}, "ncalrpc:[LRPC-0123]"},
+	}
+	for _, tt := range tests {
+		b, err := tt.b.StringBinding()
+		if err != nil {
+			t.Errorf("StringBinding(%+v): %v", tt.b, err)
+			continue
+		}
+		if got := b.String(); got != tt.want {
+			t.Errorf("StringBinding(%+v) = %q, want %q", tt.b, got, tt.want)
+		}
+	}
+	if b, err := (ComStringBinding{EPM_PROTOCOL_SPX, "00000000"}).StringBinding(); err == nil {
+		t.Errorf("StringBinding = %+v, want error", b)
+	}
+}
@@ -0,0 +1,60 @@
+package v5
+
+import (
+	"github.com/Amzza0x00/go-impacket/pkg/util"
+)
+
+// 此文件提供远程管理（mgmt）rpc接口，所有rpc服务端都会注册
+// C706 Remote Management Interface
+
+// opnum
+const (
+	MgmtInqIfIds            = 0
+	MgmtInqStats            = 1
+	MgmtIsServerListening   = 2
+	MgmtStopServerListening = 3
+	MgmtInqPrincName        = 4
+)
+
+// rpc_if_id_vector_t，conformant结构体
+type rpcIfIdVector struct {
+	Count uint32
+	IfId  []*rpcIfId `ndr:"inline"`
+}
+
+type inqIfIdsResponse struct {
+	IfIdVector *rpcIfIdVector
+	Status     uint32
+}
+
+// 服务端注册的接口
+type InterfaceId struct {
+	UUID    string
+	Version uint32 // 与SyntaxIDStruct.Version相同，低16位为主版本号，高16位为次版本号
+}
+
+// inq_if_ids查询服务端注册的全部接口，需要先绑定mgmt接口
+func (r *RPCClient) InqIfIds() ([]InterfaceId, error) {
+	var res inqIfIdsResponse
+	r.Debug("Sending mgmt inq_if_ids request", nil)
+	if err := r.Request(MgmtInqIfIds, &struct{}{}, &res); err != nil {
+		return nil, err
+	}
+	if res.Status != 0 {
+		return nil, rpcStatusError("inq_if_ids", res.Status)
+	}
+	var ids []InterfaceId
+	if res.IfIdVector == nil {
+		return ids, nil
+	}
+	for _, id := range res.IfIdVector.IfId {
+		if id == nil {
+			continue
+		}
+		ids = append(ids, InterfaceId{
+			UUID:    util.PDUUuidToString(id.UUID[:]),
+			Version: uint32(id.VersMajor) | uint32(id.VersMinor)<<16,
+		})
+	}
+	return ids, nil
+}
@@ -1,26 +1,27 @@
 package v5
 
 import (
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/Amzza0x00/go-impacket/pkg/dcerpc"
-	"github.com/Amzza0x00/go-impacket/pkg/encoder"
 	"github.com/Amzza0x00/go-impacket/pkg/ms"
 	"github.com/Amzza0x00/go-impacket/pkg/smb/smb2"
 	"github.com/Amzza0x00/go-impacket/pkg/util"
+	"io"
+	"os"
 	"strings"
 )
 
 // 此文件提供访问windows服务管理安装/删除
 
-// smb->上传文件，返回文件名
-func (c *SMBClient) FileUpload(file, Path string) (filename string, err error) {
-	treeId, err := c.TreeConnect("C$")
+// smb->上传文件到指定共享的根目录，返回文件名
+func (c *SMBClient) FileUpload(share, file, Path string) (filename string, err error) {
+	treeId, err := c.TreeConnect(share)
 	if err != nil {
 		c.Debug("", err)
 		return "", err
 	}
+	// 关闭目录连接
+	defer c.TreeDisconnect(share)
 	createRequestStruct := smb2.CreateRequestStruct{
 		OpLock:             smb2.SMB2_OPLOCK_LEVEL_NONE,
 		ImpersonationLevel: smb2.Impersonation,
@@ -39,385 +40,154 @@ func (c *SMBClient) FileUpload(file, Path string) (filename string, err error) {
 		fileInfo := strings.Split(file, ".")
 		newFilename = string(util.Random(7)) + "." + fileInfo[len(fileInfo)-1]
 	}
-	fileId, err := c.CreateRequest(treeId, newFilename, createRequestStruct)
+	f, err := os.Open(Path + file)
 	if err != nil {
 		c.Debug("", err)
 		return "", err
 	}
-	err = c.WriteRequest(treeId, Path, file, fileId)
+	defer f.Close()
+	info, err := f.Stat()
 	if err != nil {
 		c.Debug("", err)
-		return newFilename, err
-	}
-	// 关闭目录连接
-	c.TreeDisconnect("C$")
-	return newFilename, nil
-}
-
-// smb->打开scm，返回scm服务句柄
-func (c *SMBClient) OpenSvcManager(treeId, callId uint32) (fileid, handler []byte, err error) {
-	createRequestStruct := smb2.CreateRequestStruct{
-		OpLock:             smb2.SMB2_OPLOCK_LEVEL_NONE,
-		ImpersonationLevel: smb2.Impersonation,
-		AccessMask:         smb2.FILE_OPEN_IF,
-		FileAttributes:     smb2.FILE_ATTRIBUTE_NORMAL,
-		ShareAccess:        smb2.FILE_SHARE_READ,
-		CreateDisposition:  smb2.FILE_OPEN,
-		CreateOptions:      smb2.FILE_NON_DIRECTORY_FILE,
-	}
-	fileId, err := c.CreateRequest(treeId, "svcctl", createRequestStruct)
-	if err != nil {
-		c.Debug("", err)
-		return nil, nil, err
-	}
-	// 绑定svcctl函数
-	ctxs := []CtxItemStruct{{
-		NumTransItems: 1,
-		AbstractSyntax: SyntaxIDStruct{
-			UUID:    util.PDUUuidFromBytes(ms.NTSVCS_UUID),
-			Version: ms.NTSVCS_VERSION,
-		},
-		TransferSyntax: SyntaxIDStruct{
-			UUID:    util.PDUUuidFromBytes(ms.NDR_UUID),
-			Version: ms.NDR_VERSION,
-		}}}
-	err = c.MSRPCBind(treeId, fileId, callId, ctxs)
-	if err != nil {
-		c.Debug("", err)
-		return nil, nil, err
-	}
-	openSvcManagerRequest := NewOpenSCManagerWRequest()
-	openSvcManagerRequest.CallId = callId
-	req := c.NewWriteRequest(treeId, fileId, openSvcManagerRequest)
-	_, err = c.SMBSend(req)
-	if err != nil {
-		c.Debug("", err)
-		return nil, nil, err
-	}
-	c.Debug("Read OpenSCManagerW response", nil)
-	reqRead := c.NewReadRequest(treeId, fileId)
-	buf, err := c.SMBSend(reqRead)
-	if err != nil {
-		c.Debug("", err)
-		return nil, nil, err
-	}
-	smbRes := smb2.NewReadResponse()
-	res := NewOpenSCManagerWResponse()
-	c.Debug("Unmarshalling OpenSCManagerW response", nil)
-	if err = encoder.Unmarshal(buf, &smbRes); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
-	}
-	// 切开smb头
-	startIndex := len(buf) - int(smbRes.BlobLength)
-	if err = encoder.Unmarshal(buf[startIndex:], &res); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
+		return "", err
 	}
-	if res.ReturnCode != dcerpc.RPC_S_OK {
-		if len(dcerpc.RpcStatusCodes[res.ReturnCode]) == 0 {
-			msg := fmt.Sprintf("Failed to OpenSCManagerW service active code : 0x%08x", res.ReturnCode)
-			return nil, nil, errors.New(msg)
-		} else {
-			return nil, nil, errors.New("Failed to OpenSCManagerW service active : " + dcerpc.RpcStatusCodes[res.ReturnCode])
+	if info.Size() <= int64(c.MaxWriteChunk()) {
+		// 单次写入即可完成时，创建、写入、关闭通过复合请求完成
+		data := make([]byte, info.Size())
+		if _, err = io.ReadFull(f, data); err != nil {
+			c.Debug("", err)
+			return "", err
 		}
-	}
-	c.Debug("Completed OpenSCManagerW ", nil)
-	// 获取OpenSCManagerW句柄
-	contextHandle := res.ContextHandle
-	return fileId, contextHandle, nil
-}
-
-// smb->打开服务
-func (c *SMBClient) OpenService(treeId uint32, fileId, contextHandle []byte, servicename string, callId uint32) (err error) {
-	// 打开服务
-	c.Debug("Sending svcctl OpenServiceW request", nil)
-	rOpenServiceRequest := NewROpenServiceWRequest(contextHandle, servicename)
-	rOpenServiceRequest.CallId = callId
-	req := c.NewWriteRequest(treeId, fileId, rOpenServiceRequest)
-	buf, err := c.SMBSend(req)
-	if err != nil {
-		c.Debug("", err)
-		return err
-	}
-	c.Debug("Read svcctl OpenServiceW response", nil)
-	reqRead := c.NewReadRequest(treeId, fileId)
-	buf, err = c.SMBSend(reqRead)
-	if err != nil {
-		c.Debug("", err)
-		return err
-	}
-	smbRes := smb2.NewReadResponse()
-	res := NewROpenServiceWResponse()
-	c.Debug("Unmarshalling ROpenServiceW response", nil)
-	if err = encoder.Unmarshal(buf, &smbRes); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
-	}
-	// 切开smb头
-	startIndex := len(buf) - int(smbRes.BlobLength)
-	if err = encoder.Unmarshal(buf[startIndex:], &res); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
-	}
-	if res.ReturnCode != dcerpc.RPC_S_OK {
-		if len(dcerpc.RpcStatusCodes[res.ReturnCode]) == 0 {
-			msg := fmt.Sprintf("Failed to ROpenServiceW service active code : 0x%08x", res.ReturnCode)
-			return errors.New(msg)
-		} else {
-			return errors.New("Failed to ROpenServiceW service active : " + dcerpc.RpcStatusCodes[res.ReturnCode])
+		if err = c.CreateWriteClose(treeId, newFilename, createRequestStruct, data); err != nil {
+			c.Debug("", err)
+			return newFilename, err
 		}
+		return newFilename, nil
 	}
-	c.Debug("Completed ROpenServiceW ", nil)
-	return nil
-}
-
-// smb->创建服务，返回创建服务后的实例句柄
-func (c *SMBClient) CreateService(treeId uint32, fileId, contextHandle []byte, servicename, uploadPathFile string, callId uint32) (handler []byte, err error) {
-	// 创建服务
-	c.Debug("Sending svcctl RCreateServiceW request", nil)
-	rCreateServiceWRequest := NewRCreateServiceWRequest(contextHandle, servicename, uploadPathFile)
-	rCreateServiceWRequest.CallId = callId
-	req := c.NewWriteRequest(treeId, fileId, rCreateServiceWRequest)
-	buf, err := c.SMBSend(req)
+	// 大文件分块写入，不整体读入内存
+	fileId, err := c.CreateRequest(treeId, newFilename, createRequestStruct)
 	if err != nil {
 		c.Debug("", err)
-		return nil, err
+		return newFilename, err
 	}
-	c.Debug("Read svcctl RCreateServiceW response", nil)
-	reqRead := c.NewReadRequest(treeId, fileId)
-	buf, err = c.SMBSend(reqRead)
+	_, err = c.WriteFile(treeId, fileId, f)
+	c.CloseRequest(treeId, fileId)
 	if err != nil {
 		c.Debug("", err)
-		return nil, err
-	}
-	smbRes := smb2.NewReadResponse()
-	res := NewRCreateServiceWResponse()
-	c.Debug("Unmarshalling RCreateServiceW response", nil)
-	if err = encoder.Unmarshal(buf, &smbRes); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
-	}
-	// 切开smb头
-	startIndex := len(buf) - int(smbRes.BlobLength)
-	if err = encoder.Unmarshal(buf[startIndex:], &res); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
-	}
-	if res.ReturnCode != dcerpc.RPC_S_OK {
-		if len(dcerpc.RpcStatusCodes[res.ReturnCode]) == 0 {
-			msg := fmt.Sprintf("Failed to RCreateServiceW service active code : 0x%08x", res.ReturnCode)
-			return nil, errors.New(msg)
-		} else {
-			return nil, errors.New("Failed to RCreateServiceW service active : " + dcerpc.RpcStatusCodes[res.ReturnCode])
-		}
+		return newFilename, err
 	}
-	c.Debug("Completed RCreateServiceW to ["+servicename+"] ", nil)
-	// 得到创建服务后的服务句柄
-	serviceHandle := res.ContextHandle
-	return serviceHandle, nil
+	return newFilename, nil
 }
 
-// smb->启动服务
-func (c *SMBClient) StartService(treeId uint32, fileId, serviceHandle []byte, callId uint32) (err error) {
-	// 启动服务
-	c.Debug("Sending svcctl RStartServiceW request", nil)
-	rStartServiceWRequest := NewRStartServiceWRequest(serviceHandle)
-	rStartServiceWRequest.CallId = callId
-	req := c.NewWriteRequest(treeId, fileId, rStartServiceWRequest)
-	buf, err := c.SMBSend(req)
+// smb->通过共享枚举找到可写的磁盘共享并上传文件，返回文件在目标机器上的本地路径
+// 优先尝试ADMIN$、C$，无法枚举共享时使用C$
+func (c *SMBClient) UploadToWritableShare(file, Path string) (remotePath string, err error) {
+	shares, err := c.NetrShareEnum(2)
 	if err != nil {
 		c.Debug("", err)
-		return err
-	}
-	c.Debug("Read svcctl RStartServiceW response", nil)
-	reqRead := c.NewReadRequest(treeId, fileId)
-	buf, err = c.SMBSend(reqRead)
-	if err != nil {
-		c.Debug("", err)
-		return err
-	}
-	smbRes := smb2.NewReadResponse()
-	res := NewRStartServiceWResponse()
-	c.Debug("Unmarshalling RStartServiceW response", nil)
-	if err = encoder.Unmarshal(buf, &smbRes); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
-	}
-	// 切开smb头
-	startIndex := len(buf) - int(smbRes.BlobLength)
-	if err = encoder.Unmarshal(buf[startIndex:], &res); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
-	}
-	if res.StubData != dcerpc.RPC_S_OK {
-		if len(dcerpc.RpcStatusCodes[res.StubData]) == 0 {
-			msg := fmt.Sprintf("Failed to RStartServiceW service active code : 0x%08x", res.StubData)
-			return errors.New(msg)
-		} else {
-			return errors.New("Failed to RStartServiceW service active : " + dcerpc.RpcStatusCodes[res.StubData])
+		filename, err := c.FileUpload("C$", file, Path)
+		if err != nil {
+			return "", err
 		}
-	}
-	c.Debug("Completed RStartServiceW ", nil)
-	return nil
-}
-
-// smb->删除服务
-func (c *SMBClient) DeleteService(treeId uint32, fileId, serviceHandle []byte, callId uint32) (err error) {
-	c.Debug("Sending svcctl RDeleteService request", nil)
-	rDeleteServiceRequest := NewRDeleteServiceRequest(serviceHandle)
-	rDeleteServiceRequest.CallId = callId
-	req := c.NewWriteRequest(treeId, fileId, rDeleteServiceRequest)
-	buf, err := c.SMBSend(req)
-	if err != nil {
-		c.Debug("", err)
-		return err
-	}
-	c.Debug("Read svcctl RDeleteService response", nil)
-	reqRead := c.NewReadRequest(treeId, fileId)
-	buf, err = c.SMBSend(reqRead)
-	if err != nil {
-		c.Debug("", err)
-		return err
-	}
-	smbRes := smb2.NewReadResponse()
-	res := NewRDeleteServiceResponse()
-	c.Debug("Unmarshalling RDeleteService response", nil)
-	if err = encoder.Unmarshal(buf, &smbRes); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
-	}
-	// 切开smb头
-	startIndex := len(buf) - int(smbRes.BlobLength)
-	if err = encoder.Unmarshal(buf[startIndex:], &res); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
-	}
-	if res.ReturnCode != dcerpc.RPC_S_OK {
-		if len(dcerpc.RpcStatusCodes[res.ReturnCode]) == 0 {
-			msg := fmt.Sprintf("Failed to RDeleteService service active code : 0x%08x", res.ReturnCode)
-			return errors.New(msg)
-		} else {
-			return errors.New("Failed to RDeleteService service active : " + dcerpc.RpcStatusCodes[res.ReturnCode])
+		return "%systemdrive%\\" + filename, nil
+	}
+	var candidates []ShareInfo
+	for _, preferred := range []string{"ADMIN$", "C$"} {
+		for _, share := range shares {
+			if strings.EqualFold(share.Name, preferred) {
+				candidates = append(candidates, share)
+			}
 		}
 	}
-	c.Debug("Completed RDeleteService ", nil)
-	return nil
-}
-
-// smb->关闭scm句柄
-func (c *SMBClient) CloseService(treeId uint32, fileId, serviceHandle []byte, callId uint32) error {
-	// 关闭服务管理句柄
-	c.Debug("Sending svcctl RCloseServiceHandle request", nil)
-	rCloseServiceHandleRequest := NewRCloseServiceHandleRequest(serviceHandle)
-	rCloseServiceHandleRequest.CallId = callId
-	req := c.NewWriteRequest(treeId, fileId, rCloseServiceHandleRequest)
-	buf, err := c.SMBSend(req)
-	if err != nil {
-		c.Debug("", err)
-		return err
-	}
-	c.Debug("Read svcctl RCloseServiceHandle response", nil)
-	reqRead := c.NewReadRequest(treeId, fileId)
-	buf, err = c.SMBSend(reqRead)
-	if err != nil {
-		c.Debug("", err)
-		return err
-	}
-	smbRes := smb2.NewReadResponse()
-	res := NewRCloseServiceHandleResponse()
-	c.Debug("Unmarshalling RCloseServiceHandle response", nil)
-	if err = encoder.Unmarshal(buf, &smbRes); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
-	}
-	// 切开smb头
-	startIndex := len(buf) - int(smbRes.BlobLength)
-	if err = encoder.Unmarshal(buf[startIndex:], &res); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
+	for _, share := range shares {
+		if !strings.EqualFold(share.Name, "ADMIN$") && !strings.EqualFold(share.Name, "C$") {
+			candidates = append(candidates, share)
+		}
 	}
-	if res.ReturnCode != dcerpc.RPC_S_OK {
-		if len(dcerpc.RpcStatusCodes[res.ReturnCode]) == 0 {
-			msg := fmt.Sprintf("Failed to RCloseServiceHandle service active code : 0x%08x", res.ReturnCode)
-			return errors.New(msg)
-		} else {
-			return errors.New("Failed to RCloseServiceHandle service active : " + dcerpc.RpcStatusCodes[res.ReturnCode])
+	err = errors.New("No writable share found")
+	for _, share := range candidates {
+		// 只有磁盘共享并且知道本地路径才能作为服务程序路径
+		if !share.IsDisk() || share.Path == "" {
+			continue
 		}
+		var filename string
+		filename, err = c.FileUpload(share.Name, file, Path)
+		if err != nil {
+			c.Debug("Share ["+share.Name+"] is not writable", err)
+			continue
+		}
+		fmt.Printf("[+] Uploaded file to share [%s]\n", share.Name)
+		return strings.TrimRight(share.Path, "\\") + "\\" + filename, nil
 	}
-	c.Debug("Completed RCloseServiceHandle ", nil)
-	return nil
+	return "", err
 }
 
-// 服务安装
-func (c *SMBClient) ServiceInstall(servicename, file, path string) (service string, servicehandle []byte, err error) {
+// 服务安装，上传文件后通过svcctl管道创建并启动服务
+func (c *SMBClient) ServiceInstall(servicename, file, path string) (service string, err error) {
 	// 上传文件
-	filename, err := c.FileUpload(file, path)
+	uploadFilePath, err := c.UploadToWritableShare(file, path)
 	if err != nil {
 		fmt.Println("[-]", err)
-		return "", nil, err
+		return "", err
 	}
-	//建立ipc$管道
-	treeId, err := c.TreeConnect("IPC$")
+	rpc, err := c.OpenPipe("svcctl", ms.NTSVCS_UUID, ms.NTSVCS_VERSION)
 	if err != nil {
 		fmt.Println("[-]", err)
-		return "", nil, err
+		return "", err
 	}
-	var callId uint32
-	callId = 2
-	// 打开服务管理
-	svcctlFileId, svcctlHandler, err := c.OpenSvcManager(treeId, callId)
-	callId++
+	defer rpc.Close()
+	// 打开服务管理，出错返回时同样需要关闭
+	scManager, err := rpc.ROpenSCManagerW(SC_MANAGER_CREATE_SERVICE | SC_MANAGER_CONNECT)
 	if err != nil {
 		fmt.Println("[-]", err)
-		return "", nil, err
+		return "", err
 	}
-	// 打开服务
-	err = c.OpenService(treeId, svcctlFileId, svcctlHandler, servicename, callId)
-	if err != nil {
+	defer rpc.RCloseServiceHandle(scManager)
+	// 打开服务，服务已经存在时创建服务会失败
+	if handle, err := rpc.ROpenServiceW(scManager, servicename, SERVICE_ALL_ACCESS); err != nil {
 		fmt.Println("[-]", err)
-		//return "", err
+	} else {
+		rpc.RCloseServiceHandle(handle)
 	}
-	callId++
 	// 创建服务
-	uploadFilePath := "%systemdrive%\\" + filename
-	serviceHandle, err := c.CreateService(treeId, svcctlFileId, svcctlHandler, servicename, uploadFilePath, callId)
+	serviceHandle, err := rpc.RCreateServiceW(scManager, servicename, uploadFilePath)
 	if err != nil {
 		fmt.Println("[-]", err)
-		return "", nil, err
+		return "", err
 	}
-	callId++
+	defer rpc.RCloseServiceHandle(serviceHandle)
 	// 启动服务
-	err = c.StartService(treeId, svcctlFileId, serviceHandle, callId)
-	if err != nil {
+	if err = rpc.RStartServiceW(serviceHandle); err != nil {
 		fmt.Println("[-]", err)
-		return servicename, serviceHandle, err
+		return servicename, err
 	}
-	callId++
-	// 关闭服务管理
-	err = c.CloseService(treeId, svcctlFileId, svcctlHandler, callId)
-	if err != nil {
-		fmt.Println("[-]", err)
-		return servicename, serviceHandle, err
-	}
-	return servicename, serviceHandle, nil
+	return servicename, nil
 }
 
-// 服务删除
-func (c *SMBClient) ServiceDelete(serviceHandle []byte) (err error) {
-	//建立ipc$管道
-	treeId, err := c.TreeConnect("IPC$")
+// 服务删除，安装时的服务句柄随管道关闭失效，需要在新的scm句柄上重新打开服务
+func (c *SMBClient) ServiceDelete(servicename string) (err error) {
+	rpc, err := c.OpenPipe("svcctl", ms.NTSVCS_UUID, ms.NTSVCS_VERSION)
 	if err != nil {
 		fmt.Println("[-]", err)
 		return err
 	}
-	var callId uint32
-	callId = 7
+	defer rpc.Close()
 	// 打开服务管理
-	svcctlFileId, svcctlHandler, err := c.OpenSvcManager(treeId, callId)
+	scManager, err := rpc.ROpenSCManagerW(SC_MANAGER_CONNECT)
 	if err != nil {
 		fmt.Println("[-]", err)
 		return err
 	}
-	callId++
-	// 删除服务
-	err = c.DeleteService(treeId, svcctlFileId, serviceHandle, callId)
+	defer rpc.RCloseServiceHandle(scManager)
+	// 打开服务
+	serviceHandle, err := rpc.ROpenServiceW(scManager, servicename, SERVICE_ALL_ACCESS)
 	if err != nil {
 		fmt.Println("[-]", err)
 		return err
 	}
-	callId++
-	// 关闭服务管理
-	err = c.CloseService(treeId, svcctlFileId, svcctlHandler, callId)
-	if err != nil {
+	defer rpc.RCloseServiceHandle(serviceHandle)
+	// 删除服务
+	if err = rpc.RDeleteService(serviceHandle); err != nil {
 		fmt.Println("[-]", err)
 		return err
 	}
@@ -0,0 +1,173 @@
+package v5
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/common"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"github.com/Amzza0x00/go-impacket/pkg/krb5/ntlm"
+)
+
+// 此文件提供rpc认证，绑定时完成ntlm认证（bind->bind_ack->auth3），请求按认证级别签名、加密
+// MS-RPCE 2.2.2.11 sec_trailer Structure
+
+// 认证级别
+const (
+	RPC_C_AUTHN_LEVEL_DEFAULT       = 0
+	RPC_C_AUTHN_LEVEL_NONE          = 1
+	RPC_C_AUTHN_LEVEL_CONNECT       = 2
+	RPC_C_AUTHN_LEVEL_CALL          = 3
+	RPC_C_AUTHN_LEVEL_PKT           = 4
+	RPC_C_AUTHN_LEVEL_PKT_INTEGRITY = 5
+	RPC_C_AUTHN_LEVEL_PKT_PRIVACY   = 6
+)
+
+// 认证类型
+const (
+	RPC_C_AUTHN_NONE         = 0
+	RPC_C_AUTHN_WINNT        = 10 // ntlm
+	RPC_C_AUTHN_GSS_KERBEROS = 16
+)
+
+// sec_trailer大小
+const SecTrailerSize = 8
+
+// 签名、加密时stub填充到16字节对齐
+const authPadAlign = 16
+
+// 认证信息之前的sec_trailer
+type SecTrailerStruct struct {
+	AuthType      uint8
+	AuthLevel     uint8
+	AuthPadLen    uint8
+	AuthRsrvd     uint8
+	AuthContextId uint32
+}
+
+// auth3请求结构，服务端不返回响应
+type MSRPCAuth3Struct struct {
+	MSRPCHeaderStruct
+	Pad        uint32
+	SecTrailer SecTrailerStruct
+	AuthValue  []byte
+}
+
+// rpc连接的认证状态
+type rpcAuth struct {
+	level   uint8
+	options common.ClientOptions
+	session *ntlm.Session // 签名、加密使用，connect级别为空
+}
+
+// 设置认证凭据以及认证级别，需要在Bind之前调用
+// 支持RPC_C_AUTHN_LEVEL_NONE、CONNECT、PKT_INTEGRITY以及PKT_PRIVACY
+func (r *RPCClient) SetAuth(opt common.ClientOptions, level uint8) error {
+	switch level {
+	case RPC_C_AUTHN_LEVEL_NONE:
+		r.auth = nil
+		return nil
+	case RPC_C_AUTHN_LEVEL_CONNECT, RPC_C_AUTHN_LEVEL_PKT_INTEGRITY, RPC_C_AUTHN_LEVEL_PKT_PRIVACY:
+		r.auth = &rpcAuth{level: level, options: opt}
+		return nil
+	}
+	return errors.New("Unsupported rpc authentication level")
+}
+
+// 是否需要对每个请求签名
+func (a *rpcAuth) protects() bool {
+	return a != nil && a.session != nil
+}
+
+func (a *rpcAuth) trailer(padLen int) []byte {
+	buf, _ := encoder.Marshal(SecTrailerStruct{
+		AuthType:   RPC_C_AUTHN_WINNT,
+		AuthLevel:  a.level,
+		AuthPadLen: uint8(padLen),
+	})
+	return buf
+}
+
+// 在绑定请求末尾附加sec_trailer以及ntlm协商消息
+func (a *rpcAuth) negotiate(pdu []byte) ([]byte, error) {
+	neg, err := encoder.Marshal(ntlm.NewNegotiateSeal(a.options.Domain, a.options.Workstation))
+	if err != nil {
+		return nil, err
+	}
+	pad := (4 - len(pdu)%4) % 4
+	pdu = append(pdu, make([]byte, pad)...)
+	pdu = append(pdu, a.trailer(pad)...)
+	pdu = append(pdu, neg...)
+	binary.LittleEndian.PutUint16(pdu[8:], uint16(len(pdu)))
+	binary.LittleEndian.PutUint16(pdu[10:], uint16(len(neg)))
+	return pdu, nil
+}
+
+// 根据绑定响应中的质询生成auth3请求，签名以上级别同时生成会话安全上下文
+func (a *rpcAuth) authenticate(callId uint32, challenge []byte) ([]byte, error) {
+	if len(challenge) == 0 {
+		return nil, errors.New("Failed to rpc bind: no authentication challenge")
+	}
+	c := ntlm.NewChallenge()
+	if err := encoder.Unmarshal(challenge, &c); err != nil {
+		return nil, err
+	}
+	auth, flags, key, err := ntlm.NewAuthenticateSeal(a.options.Domain, a.options.User, a.options.Workstation, a.options.Password, a.options.Hash, c)
+	if err != nil {
+		return nil, err
+	}
+	value, err := encoder.Marshal(auth)
+	if err != nil {
+		return nil, err
+	}
+	if a.level >= RPC_C_AUTHN_LEVEL_PKT_INTEGRITY {
+		if a.session, err = ntlm.NewSession(flags, key); err != nil {
+			return nil, err
+		}
+	}
+	header := NewMSRPCHeader()
+	header.CallId = callId
+	header.PacketType = PDUAuth3
+	header.PacketFlags = FirstFrag | LastFrag
+	header.AuthLength = uint16(len(value))
+	header.FragLength = uint16(16 + 4 + SecTrailerSize + len(value))
+	return encoder.Marshal(MSRPCAuth3Struct{
+		MSRPCHeaderStruct: header,
+		SecTrailer: SecTrailerStruct{
+			AuthType:  RPC_C_AUTHN_WINNT,
+			AuthLevel: a.level,
+		},
+		AuthValue: value,
+	})
+}
+
+// 对请求分片填充stub并附加sec_trailer以及签名，privacy级别同时加密stub
+func (a *rpcAuth) protect(pdu []byte) []byte {
+	pad := (authPadAlign - (len(pdu)-MSRPCRequestHeaderSize)%authPadAlign) % authPadAlign
+	pdu = append(pdu, make([]byte, pad)...)
+	body := len(pdu)
+	pdu = append(pdu, a.trailer(pad)...)
+	binary.LittleEndian.PutUint16(pdu[8:], uint16(len(pdu)+ntlm.SignatureSize))
+	binary.LittleEndian.PutUint16(pdu[10:], ntlm.SignatureSize)
+	// 先加密再对明文签名
+	var sealed []byte
+	if a.level == RPC_C_AUTHN_LEVEL_PKT_PRIVACY {
+		sealed = a.session.Seal(pdu[MSRPCRequestHeaderSize:body])
+	}
+	sig := a.session.Sign(pdu)
+	if sealed != nil {
+		copy(pdu[MSRPCRequestHeaderSize:], sealed)
+	}
+	return append(pdu, sig...)
+}
+
+// 校验响应分片的签名，privacy级别先原地解密stub，trailer为sec_trailer的偏移
+func (a *rpcAuth) open(frag []byte, trailer int) error {
+	authStart := trailer + SecTrailerSize
+	if len(frag)-authStart != ntlm.SignatureSize {
+		return errors.New("Invalid rpc response signature")
+	}
+	if a.level == RPC_C_AUTHN_LEVEL_PKT_PRIVACY {
+		copy(frag[MSRPCRequestHeaderSize:], a.session.Unseal(frag[MSRPCRequestHeaderSize:trailer]))
+	}
+	return a.session.Verify(frag[:authStart], frag[authStart:])
+}
@@ -0,0 +1,181 @@
+package v5
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/krb5/ntlm"
+	"testing"
+)
+
+// MS-NLMP 4.2.4 NTLMv2示例中的协商标志以及会话密钥，包含128位密钥以及密钥交换
+const testNTLMFlags = 0xe28a8233
+
+var testSessionKey = bytes.Repeat([]byte{0x55}, 16)
+
+// 服务端会话安全，按MS-NLMP 3.4.4.2独立实现，校验客户端分片并生成响应分片
+type testServer struct {
+	level            uint8
+	recvKey, sendKey []byte
+	recv, send       *rc4.Cipher
+	recvSeq, sendSeq uint32
+}
+
+func newTestServer(t *testing.T, level uint8) *testServer {
+	t.Helper()
+	key := func(magic string) []byte {
+		h := md5.New()
+		h.Write(testSessionKey)
+		h.Write([]byte(magic))
+		return h.Sum(nil)
+	}
+	s := &testServer{
+		level:   level,
+		recvKey: key("session key to client-to-server signing key magic constant\x00"),
+		sendKey: key("session key to server-to-client signing key magic constant\x00"),
+	}
+	var err error
+	if s.recv, err = rc4.NewCipher(key("session key to client-to-server sealing key magic constant\x00")); err != nil {
+		t.Fatal(err)
+	}
+	if s.send, err = rc4.NewCipher(key("session key to server-to-client sealing key magic constant\x00")); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testMAC(handle *rc4.Cipher, key []byte, seq uint32, message []byte) []byte {
+	sig := make([]byte, ntlm.SignatureSize)
+	binary.LittleEndian.PutUint32(sig[0:], 1)
+	binary.LittleEndian.PutUint32(sig[12:], seq)
+	h := hmac.New(md5.New, key)
+	h.Write(sig[12:])
+	h.Write(message)
+	handle.XORKeyStream(sig[4:12], h.Sum(nil)[:8])
+	return sig
+}
+
+// 解密并校验请求分片，trailer为sec_trailer的偏移
+func (s *testServer) open(frag []byte, trailer int) error {
+	authStart := trailer + SecTrailerSize
+	if s.level == RPC_C_AUTHN_LEVEL_PKT_PRIVACY {
+		s.recv.XORKeyStream(frag[MSRPCRequestHeaderSize:trailer], frag[MSRPCRequestHeaderSize:trailer])
+	}
+	sig := testMAC(s.recv, s.recvKey, s.recvSeq, frag[:authStart])
+	s.recvSeq++
+	if !bytes.Equal(sig, frag[authStart:]) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// 对响应分片填充、附加sec_trailer并签名，privacy级别同时加密
+func (s *testServer) protect(frag []byte) []byte {
+	frag = append([]byte(nil), frag...)
+	pad := (authPadAlign - (len(frag)-MSRPCRequestHeaderSize)%authPadAlign) % authPadAlign
+	frag = append(frag, make([]byte, pad)...)
+	body := len(frag)
+	frag = append(frag, RPC_C_AUTHN_WINNT, s.level, uint8(pad), 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint16(frag[8:], uint16(len(frag)+ntlm.SignatureSize))
+	binary.LittleEndian.PutUint16(frag[10:], ntlm.SignatureSize)
+	plain := append([]byte(nil), frag...)
+	if s.level == RPC_C_AUTHN_LEVEL_PKT_PRIVACY {
+		s.send.XORKeyStream(frag[MSRPCRequestHeaderSize:body], frag[MSRPCRequestHeaderSize:body])
+	}
+	sig := testMAC(s.send, s.sendKey, s.sendSeq, plain)
+	s.sendSeq++
+	return append(frag, sig...)
+}
+
+// 使用相同会话密钥的客户端认证状态以及服务端
+func testAuthPair(t *testing.T, level uint8) (*rpcAuth, *testServer) {
+	t.Helper()
+	session, err := ntlm.NewSession(testNTLMFlags, testSessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &rpcAuth{level: level, session: session}, newTestServer(t, level)
+}
+
+// 请求分片加密签名后由服务端逐个解密校验
+func TestProtectOpen(t *testing.T) {
+	for _, level := range []uint8{RPC_C_AUTHN_LEVEL_PKT_INTEGRITY, RPC_C_AUTHN_LEVEL_PKT_PRIVACY} {
+		client, server := testAuthPair(t, level)
+		stub := testStub(100)
+		// 每个分片32字节stub，stub长度按16字节向下对齐
+		maxFrag := MSRPCRequestHeaderSize + 32 + SecTrailerSize + ntlm.SignatureSize + 15
+		frags, err := fragmentRequest(1, 0, 15, stub, uint16(maxFrag), client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(frags) != 4 {
+			t.Fatalf("level %d: got %d fragments, want 4", level, len(frags))
+		}
+		var got []byte
+		for i, frag := range frags {
+			if len(frag) > maxFrag || int(binary.LittleEndian.Uint16(frag[8:])) != len(frag) || binary.LittleEndian.Uint16(frag[10:]) != ntlm.SignatureSize {
+				t.Fatalf("level %d fragment %d: invalid frag_length or auth_length", level, i)
+			}
+			// alloc_hint不包含填充以及认证信息
+			if allocHint := binary.LittleEndian.Uint32(frag[16:]); int(allocHint) != len(stub)-32*i {
+				t.Errorf("level %d fragment %d: alloc_hint %d, want %d", level, i, allocHint, len(stub)-32*i)
+			}
+			trailer := len(frag) - ntlm.SignatureSize - SecTrailerSize
+			if frag[trailer] != RPC_C_AUTHN_WINNT || frag[trailer+1] != level {
+				t.Fatalf("level %d fragment %d: invalid sec_trailer %x", level, i, frag[trailer:trailer+SecTrailerSize])
+			}
+			if (trailer-MSRPCRequestHeaderSize)%authPadAlign != 0 {
+				t.Fatalf("level %d fragment %d: stub is not padded to %d bytes", level, i, authPadAlign)
+			}
+			if sealed := !bytes.Contains(frag, stub[32*i:32*i+4]); sealed != (level == RPC_C_AUTHN_LEVEL_PKT_PRIVACY) {
+				t.Fatalf("level %d fragment %d: sealed = %v", level, i, sealed)
+			}
+			if err = server.open(frag, trailer); err != nil {
+				t.Fatalf("level %d fragment %d: %v", level, i, err)
+			}
+			got = append(got, frag[MSRPCRequestHeaderSize:trailer-int(frag[trailer+2])]...)
+		}
+		if !bytes.Equal(got, stub) {
+			t.Errorf("level %d: opened stub = %x, want %x", level, got, stub)
+		}
+	}
+}
+
+// 服务端加密签名的响应分片由readFragments解密校验并拼接
+func TestReadFragmentsPrivacy(t *testing.T) {
+	client, server := testAuthPair(t, RPC_C_AUTHN_LEVEL_PKT_PRIVACY)
+	stub := testStub(50)
+	frags, err := fragmentRequest(1, 0, 0, stub, MSRPCRequestHeaderSize+32, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream []byte
+	for _, frag := range frags {
+		frag[2] = PDUResponse
+		stream = append(stream, server.protect(frag)...)
+	}
+	pdu, err := readFragments(testReader(stream, len(stream)), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pdu[MSRPCRequestHeaderSize:], stub) {
+		t.Errorf("stub = %x, want %x", pdu[MSRPCRequestHeaderSize:], stub)
+	}
+
+	// 修改密文后签名校验失败
+	client, server = testAuthPair(t, RPC_C_AUTHN_LEVEL_PKT_PRIVACY)
+	frag := server.protect(frags[0])
+	frag[MSRPCRequestHeaderSize] ^= 1
+	if _, err = readFragments(testReader(frag, len(frag)), client); err == nil {
+		t.Error("tampered fragment opened")
+	}
+	// 需要签名时不接受未签名的响应
+	client, _ = testAuthPair(t, RPC_C_AUTHN_LEVEL_PKT_INTEGRITY)
+	frag = testFragment(PDUResponse, FirstFrag|LastFrag, stub)
+	if _, err = readFragments(testReader(frag, len(frag)), client); err == nil {
+		t.Error("unsigned fragment accepted")
+	}
+}
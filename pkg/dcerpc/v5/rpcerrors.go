@@ -0,0 +1,99 @@
+package v5
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// 此文件提供bind_nak、上下文拒绝以及fault对应的错误类型
+// https://pubs.opengroup.org/onlinepubs/9629399/chap12.htm
+
+// bind_nak拒绝原因
+const (
+	REASON_NOT_SPECIFIED               = 0
+	TEMPORARY_CONGESTION               = 1
+	LOCAL_LIMIT_EXCEEDED               = 2
+	CALLED_PADDR_UNKNOWN               = 3
+	PROTOCOL_VERSION_NOT_SUPPORTED     = 4
+	DEFAULT_CONTEXT_NOT_SUPPORTED      = 5
+	USER_DATA_NOT_READABLE             = 6
+	NO_PSAP_AVAILABLE                  = 7
+	AUTHENTICATION_TYPE_NOT_RECOGNIZED = 8
+	INVALID_CHECKSUM                   = 9
+)
+
+var BindNakReasons = map[uint16]string{
+	REASON_NOT_SPECIFIED:               "reason not specified",
+	TEMPORARY_CONGESTION:               "temporary congestion",
+	LOCAL_LIMIT_EXCEEDED:               "local limit exceeded",
+	CALLED_PADDR_UNKNOWN:               "called paddr unknown",
+	PROTOCOL_VERSION_NOT_SUPPORTED:     "protocol version not supported",
+	DEFAULT_CONTEXT_NOT_SUPPORTED:      "default context not supported",
+	USER_DATA_NOT_READABLE:             "user data not readable",
+	NO_PSAP_AVAILABLE:                  "no psap available",
+	AUTHENTICATION_TYPE_NOT_RECOGNIZED: "authentication type not recognized",
+	INVALID_CHECKSUM:                   "invalid checksum",
+}
+
+// 上下文被拒绝的原因
+const (
+	ProviderReasonNotSpecified           = 0
+	AbstractSyntaxNotSupported           = 1
+	ProposedTransferSyntaxesNotSupported = 2
+	ProviderLocalLimitExceeded           = 3
+)
+
+var ProviderReasons = map[uint16]string{
+	ProviderReasonNotSpecified:           "reason not specified",
+	AbstractSyntaxNotSupported:           "abstract syntax not supported",
+	ProposedTransferSyntaxesNotSupported: "proposed transfer syntaxes not supported",
+	ProviderLocalLimitExceeded:           "local limit exceeded",
+}
+
+// 服务端返回bind_nak拒绝绑定
+type BindNakError struct {
+	Reason uint16
+}
+
+func (e *BindNakError) Error() string {
+	if msg, ok := BindNakReasons[e.Reason]; ok {
+		return "Failed to rpc bind, reject reason: " + msg
+	}
+	return fmt.Sprintf("Failed to rpc bind, reject reason: %d", e.Reason)
+}
+
+// 绑定响应中的上下文被拒绝
+type ContextRejectedError struct {
+	ContextId uint16
+	Result    uint16 // UserRejection或ProviderRejection
+	Reason    uint16
+}
+
+func (e *ContextRejectedError) Error() string {
+	reason, ok := ProviderReasons[e.Reason]
+	if !ok {
+		reason = fmt.Sprintf("%d", e.Reason)
+	}
+	return fmt.Sprintf("Failed to rpc bind, context %d rejected: %s", e.ContextId, reason)
+}
+
+// 服务端返回fault，Status为rpc状态码
+type FaultError struct {
+	Op     string
+	Status uint32
+}
+
+func (e *FaultError) Error() string {
+	return rpcStatusError(e.Op, e.Status).Error()
+}
+
+// fault PDU中status之前有AllocHint、ContextId、CancelCount以及保留字节
+const faultStatusOffset = MSRPCRequestHeaderSize
+
+// 响应为fault PDU时返回FaultError
+func checkFault(op string, pdu []byte) error {
+	if len(pdu) < faultStatusOffset+4 || pdu[2] != PDUFault {
+		return nil
+	}
+	return &FaultError{Op: op, Status: binary.LittleEndian.Uint32(pdu[faultStatusOffset:])}
+}
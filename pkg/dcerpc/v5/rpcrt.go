@@ -1,12 +1,19 @@
 package v5
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc/ndr"
 	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"github.com/Amzza0x00/go-impacket/pkg/krb5/ntlm"
 	"github.com/Amzza0x00/go-impacket/pkg/ms"
-	"github.com/Amzza0x00/go-impacket/pkg/smb/smb2"
 	"github.com/Amzza0x00/go-impacket/pkg/util"
+	"log"
+	"runtime/debug"
 )
 
 // 此文件提供ms-rpce封装
@@ -110,9 +117,10 @@ type MSRPCBindAckStruct struct {
 	MaxRecvFrag   uint16
 	AssocGroup    uint32
 	ScndryAddrlen uint16
-	ScndryAddr    []byte `smb:"count:ScndryAddrlen"` //取决管道的长度
+	ScndryAddr    []byte //取决管道的长度，之后按4字节对齐
 	NumResults    uint8
-	CtxItem       CtxEItemResponseStruct
+	CtxItems      []CtxEItemResponseStruct // 与请求中的CtxItems一一对应
+	AuthValue     []byte                   // 认证信息，ntlm认证时为质询消息
 }
 
 // PDU CtxItem结构
@@ -137,6 +145,137 @@ type CtxEItemResponseStruct struct {
 	SyntaxVer      uint32
 }
 
+// CtxItem响应结果
+const (
+	Acceptance         = 0
+	UserRejection      = 1
+	ProviderRejection  = 2
+	NegotiateAck       = 3 // 绑定时特性协商
+	ctxResponseSize    = 24
+	bindAckFixedLength = 26
+)
+
+// 生成绑定请求的CtxItems，每个传输语法一个上下文，ContextId依次递增
+func NewCtxItems(uuid string, version uint32, syntaxes ...ndr.Syntax) []CtxItemStruct {
+	var ctxs []CtxItemStruct
+	for i, syntax := range syntaxes {
+		transfer := SyntaxIDStruct{
+			UUID:    util.PDUUuidFromBytes(ms.NDR_UUID),
+			Version: ms.NDR_VERSION,
+		}
+		if syntax == ndr.NDR64 {
+			transfer = SyntaxIDStruct{
+				UUID:    util.PDUUuidFromBytes(ms.NDR64_UUID),
+				Version: ms.NDR64_VERSION,
+			}
+		}
+		ctxs = append(ctxs, CtxItemStruct{
+			ContextId:     uint16(i),
+			NumTransItems: 1,
+			AbstractSyntax: SyntaxIDStruct{
+				UUID:    util.PDUUuidFromBytes(uuid),
+				Version: version,
+			},
+			TransferSyntax: transfer,
+		})
+	}
+	return ctxs
+}
+
+// 解析函数绑定响应，次要地址长度可变，无法直接使用encoder解码
+func ParseMSRPCBindAck(buf []byte) (res MSRPCBindAckStruct, err error) {
+	// bind_nak只有拒绝原因以及支持的协议版本，比bind_ack短
+	if len(buf) < 18 {
+		return res, errors.New("Invalid rpc bind response")
+	}
+	if err = encoder.Unmarshal(buf[:16], &res.MSRPCHeaderStruct); err != nil {
+		return res, err
+	}
+	switch res.PacketType {
+	case PDUBind_Ack, PDUAlter_Context_Resp:
+		if len(buf) < bindAckFixedLength {
+			return res, errors.New("Invalid rpc bind response")
+		}
+	case PDUBind_Nak:
+		return res, &BindNakError{Reason: binary.LittleEndian.Uint16(buf[16:])}
+	case PDUFault:
+		// alter_context失败时服务端返回fault
+		if err = checkFault("rpc bind", buf); err != nil {
+			return res, err
+		}
+		return res, errors.New("Invalid rpc fault response")
+	default:
+		return res, fmt.Errorf("Unexpected rpc packet type %d", res.PacketType)
+	}
+	res.MaxXmitFrag = binary.LittleEndian.Uint16(buf[16:])
+	res.MaxRecvFrag = binary.LittleEndian.Uint16(buf[18:])
+	res.AssocGroup = binary.LittleEndian.Uint32(buf[20:])
+	res.ScndryAddrlen = binary.LittleEndian.Uint16(buf[24:])
+	off := bindAckFixedLength + int(res.ScndryAddrlen)
+	if off > len(buf) {
+		return res, errors.New("Invalid rpc bind response")
+	}
+	res.ScndryAddr = buf[bindAckFixedLength:off]
+	off = (off + 3) &^ 3
+	// NumResults之后有3字节保留
+	if off+4 > len(buf) {
+		return res, errors.New("Invalid rpc bind response")
+	}
+	res.NumResults = buf[off]
+	off += 4
+	for i := 0; i < int(res.NumResults); i++ {
+		if off+ctxResponseSize > len(buf) {
+			return res, errors.New("Invalid rpc bind response")
+		}
+		res.CtxItems = append(res.CtxItems, CtxEItemResponseStruct{
+			AckResult:      binary.LittleEndian.Uint16(buf[off:]),
+			AckReason:      binary.LittleEndian.Uint16(buf[off+2:]),
+			TransferSyntax: buf[off+4 : off+20],
+			SyntaxVer:      binary.LittleEndian.Uint32(buf[off+20:]),
+		})
+		off += ctxResponseSize
+	}
+	// 认证信息位于PDU末尾
+	if res.AuthLength > 0 {
+		end := int(res.FragLength)
+		if end > len(buf) || int(res.AuthLength) > end-off {
+			return res, errors.New("Invalid rpc bind response")
+		}
+		res.AuthValue = buf[end-int(res.AuthLength) : end]
+	}
+	return res, nil
+}
+
+// 返回服务端接受的第一个上下文以及对应的传输语法，ctxs为绑定请求中的上下文
+// 全部上下文被拒绝时返回第一个被拒绝上下文的ContextRejectedError
+func (res *MSRPCBindAckStruct) Accepted(ctxs []CtxItemStruct) (contextId uint16, syntax ndr.Syntax, err error) {
+	ndr64 := util.PDUUuidFromBytes(ms.NDR64_UUID)
+	var rejected error
+	for i, item := range res.CtxItems {
+		if i >= len(ctxs) {
+			break
+		}
+		if item.AckResult == UserRejection || item.AckResult == ProviderRejection {
+			if rejected == nil {
+				rejected = &ContextRejectedError{ContextId: ctxs[i].ContextId, Result: item.AckResult, Reason: item.AckReason}
+			}
+			continue
+		}
+		if item.AckResult != Acceptance {
+			continue
+		}
+		syntax = ndr.NDR20
+		if bytes.Equal(item.TransferSyntax, ndr64) {
+			syntax = ndr.NDR64
+		}
+		return ctxs[i].ContextId, syntax, nil
+	}
+	if rejected != nil {
+		return 0, ndr.NDR20, rejected
+	}
+	return 0, ndr.NDR20, errors.New("Failed to rpc bind: no transfer syntax accepted")
+}
+
 // PDU PacketType
 // https://pubs.opengroup.org/onlinepubs/9629399/chap12.htm
 const (
@@ -156,6 +295,7 @@ const (
 	PDUBind_Nak           = 13
 	PDUAlter_Context      = 14
 	PDUAlter_Context_Resp = 15
+	PDUAuth3              = 16
 	PDUShutdown           = 17
 	PDUCo_Cancel          = 18
 	PDUOrphaned           = 19
@@ -178,25 +318,16 @@ const (
 	PDUFlagReserved_80 = 0x80
 )
 
-// 函数绑定响应
-func NewMSRPCBindAck() MSRPCBindAckStruct {
-	return MSRPCBindAckStruct{
-		CtxItem: CtxEItemResponseStruct{
-			TransferSyntax: make([]byte, 16),
-		},
-	}
-}
-
 // smb->函数绑定
-func (c *SMBClient) MSRPCBind(treeId uint32, fileId []byte, callId uint32, ctxs []CtxItemStruct) (err error) {
+func (c *SMBClient) MSRPCBind(treeId uint32, fileId []byte, callId uint32, ctxs []CtxItemStruct) (res MSRPCBindAckStruct, err error) {
 	header := NewMSRPCHeader()
 	header.CallId = callId
 	header.PacketType = PDUBind
 	header.PacketFlags = PDUFlagPending
 	bindStruct := MSRPCBindStruct{
 		MSRPCHeaderStruct: header,
-		MaxXmitFrag:       4280,
-		MaxRecvFrag:       4280,
+		MaxXmitFrag:       DefaultMaxFrag,
+		MaxRecvFrag:       DefaultMaxFrag,
 		AssocGroup:        0,
 		CtxItems:          ctxs,
 	}
@@ -209,31 +340,25 @@ func (c *SMBClient) MSRPCBind(treeId uint32, fileId []byte, callId uint32, ctxs
 	_, err = c.SMBSend(req)
 	if err != nil {
 		c.Debug("", err)
-		return err
+		return res, err
 	}
 	c.Debug("Read rpc response", nil)
-	req1 := c.NewReadRequest(treeId, fileId)
-	buf, err1 := c.SMBSend(req1)
-	if err1 != nil {
-		c.Debug("", err1)
-		return err1
-	}
-	smbRes := smb2.NewReadResponse()
-	res := NewMSRPCBindAck()
-	c.Debug("Unmarshalling rpc bind", nil)
-	if err = encoder.Unmarshal(buf, &smbRes); err != nil {
-		c.Debug("Raw:\n"+hex.Dump(buf), err)
+	buf, err := c.ReadPipe(treeId, fileId)
+	if err != nil {
+		c.Debug("", err)
+		return res, err
 	}
-	// 切开smb头
-	startIndex := len(buf) - int(smbRes.BlobLength)
-	if err = encoder.Unmarshal(buf[startIndex:], &res); err != nil {
+	c.Debug("Unmarshalling rpc bind", nil)
+	if res, err = ParseMSRPCBindAck(buf); err != nil {
 		c.Debug("Raw:\n"+hex.Dump(buf), err)
+		return res, err
 	}
-	if res.NumResults < 1 {
-		return errors.New("Failed to rpc bind code : " + ms.StatusMap[smbRes.Status])
+	if _, _, err = res.Accepted(ctxs); err != nil {
+		c.Debug("", err)
+		return res, err
 	}
 	c.Debug("Completed rpc bind", nil)
-	return nil
+	return res, nil
 }
 
 // tcp->函数绑定
@@ -244,8 +369,8 @@ func (c *TCPClient) MSRPCBind(callId uint32, ctxs []CtxItemStruct) (res MSRPCBin
 	header.PacketFlags = PDUFlagPending
 	bindStruct := MSRPCBindStruct{
 		MSRPCHeaderStruct: header,
-		MaxXmitFrag:       4280,
-		MaxRecvFrag:       4280,
+		MaxXmitFrag:       DefaultMaxFrag,
+		MaxRecvFrag:       DefaultMaxFrag,
 		AssocGroup:        0,
 		CtxItems:          ctxs,
 	}
@@ -255,19 +380,25 @@ func (c *TCPClient) MSRPCBind(callId uint32, ctxs []CtxItemStruct) (res MSRPCBin
 	bindStruct.FragLength = uint16(fragLength)
 	c.Debug("Sending rpc bind", nil)
 	buf, err := c.TCPSend(bindStruct)
-	res = NewMSRPCBindAck()
+	if err != nil {
+		c.Debug("", err)
+		return res, err
+	}
 	c.Debug("Unmarshalling rpc bind", nil)
-	if err = encoder.Unmarshal(buf, &res); err != nil {
+	if res, err = ParseMSRPCBindAck(buf); err != nil {
 		c.Debug("Raw:\n"+hex.Dump(buf), err)
+		return MSRPCBindAckStruct{}, err
 	}
-	if res.NumResults < 1 {
-		return MSRPCBindAckStruct{}, errors.New("Failed to rpc bind")
+	if _, _, err = res.Accepted(ctxs); err != nil {
+		c.Debug("", err)
+		return MSRPCBindAckStruct{}, err
 	}
+	c.maxXmitFrag = res.MaxRecvFrag
 	c.Debug("Completed rpc bind", nil)
-	return res, err
+	return res, nil
 }
 
-// 带认证场景的msrpc绑定
+// 带认证场景的msrpc绑定，只发送ntlm协商消息，完整的认证流程使用RPCClient.SetAuth
 func (c *TCPClient) MSRPCAuthBind(callId uint32, ctxs []CtxItemStruct, auth AuthInfoByNTLMSSPStruct, assocGroup uint32, domainNameLen, workstationNameLen uint16) (err error) {
 	header := NewMSRPCHeader()
 	header.CallId = callId
@@ -295,14 +426,385 @@ func (c *TCPClient) MSRPCAuthBind(callId uint32, ctxs []CtxItemStruct, auth Auth
 	bindStruct.FragLength = uint16(fragLength)
 	c.Debug("Sending rpc bind", nil)
 	buf, err := c.TCPSend(bindStruct)
-	res := NewMSRPCBindAck()
+	if err != nil {
+		c.Debug("", err)
+		return err
+	}
 	c.Debug("Unmarshalling rpc bind", nil)
-	if err = encoder.Unmarshal(buf, &res); err != nil {
+	res, err := ParseMSRPCBindAck(buf)
+	if err != nil {
 		c.Debug("Raw:\n"+hex.Dump(buf), err)
+		return err
 	}
-	if res.NumResults < 1 {
-		return errors.New("Failed to rpc bind")
+	if _, _, err = res.Accepted(ctxs); err != nil {
+		c.Debug("", err)
+		return err
 	}
+	c.maxXmitFrag = res.MaxRecvFrag
 	c.Debug("Completed rpc bind", nil)
-	return err
+	return nil
+}
+
+// 请求响应PDU头大小，标准头16字节+AllocHint、ContextId、OpNum/CancelCount
+const MSRPCRequestHeaderSize = 24
+
+// 绑定请求中的默认分片大小，绑定成功后以服务端返回的MaxRecvFrag为准，即服务端可以接收的最大分片
+const DefaultMaxFrag = 4280
+
+// 将请求stub数据按maxFrag拆分为多个请求分片，除最后一个分片外stub长度按8字节对齐
+// auth不为空且需要签名时，每个分片附加sec_trailer以及签名，stub长度按16字节对齐
+func fragmentRequest(callId uint32, contextId, opNum uint16, stub []byte, maxFrag uint16, auth *rpcAuth) ([][]byte, error) {
+	if maxFrag == 0 {
+		maxFrag = DefaultMaxFrag
+	}
+	size := (int(maxFrag) - MSRPCRequestHeaderSize) &^ 7
+	if auth.protects() {
+		size = (int(maxFrag) - MSRPCRequestHeaderSize - SecTrailerSize - ntlm.SignatureSize) &^ (authPadAlign - 1)
+	}
+	if size <= 0 {
+		return nil, errors.New("Invalid rpc fragment size")
+	}
+	var frags [][]byte
+	for offset := 0; offset == 0 || offset < len(stub); offset += size {
+		end := offset + size
+		if end > len(stub) {
+			end = len(stub)
+		}
+		header := NewMSRPCHeader()
+		header.CallId = callId
+		header.PacketType = PDURequest
+		header.FragLength = uint16(MSRPCRequestHeaderSize + end - offset)
+		if offset == 0 {
+			header.PacketFlags |= FirstFrag
+		}
+		if end == len(stub) {
+			header.PacketFlags |= LastFrag
+		}
+		buf, err := encoder.Marshal(header)
+		if err != nil {
+			return nil, err
+		}
+		var b [8]byte
+		// AllocHint为剩余stub数据的长度
+		binary.LittleEndian.PutUint32(b[0:], uint32(len(stub)-offset))
+		binary.LittleEndian.PutUint16(b[4:], contextId)
+		binary.LittleEndian.PutUint16(b[6:], opNum)
+		buf = append(buf, b[:]...)
+		buf = append(buf, stub[offset:end]...)
+		if auth.protects() {
+			buf = auth.protect(buf)
+		}
+		frags = append(frags, buf)
+	}
+	return frags, nil
+}
+
+// 读取响应分片直到LastFrag，next返回传输层读取到的数据，可以包含多个分片或者不完整的分片
+// auth不为空且需要签名时校验每个分片的签名并解密
+// 返回第一个分片的头部加上全部stub数据拼接后的完整响应
+func readFragments(next func() ([]byte, error), auth *rpcAuth) ([]byte, error) {
+	var buf, pdu []byte
+	for {
+		for len(buf) < MSRPCRequestHeaderSize || len(buf) < int(binary.LittleEndian.Uint16(buf[8:])) {
+			data, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if len(data) == 0 {
+				return nil, errors.New("Invalid rpc response")
+			}
+			buf = append(buf, data...)
+		}
+		fragLength := int(binary.LittleEndian.Uint16(buf[8:]))
+		authLength := int(binary.LittleEndian.Uint16(buf[10:]))
+		if fragLength < MSRPCRequestHeaderSize {
+			return nil, errors.New("Invalid rpc response")
+		}
+		switch buf[2] {
+		case PDUResponse:
+		case PDUFault:
+			return nil, checkFault("rpc request", buf)
+		default:
+			return nil, fmt.Errorf("Unexpected rpc packet type %d", buf[2])
+		}
+		end := fragLength
+		if authLength > 0 {
+			// 认证信息前有8字节sec_trailer，stub之后的填充长度记录在sec_trailer中
+			trailer := fragLength - authLength - SecTrailerSize
+			if trailer < MSRPCRequestHeaderSize {
+				return nil, errors.New("Invalid rpc response")
+			}
+			if auth.protects() {
+				if err := auth.open(buf[:fragLength], trailer); err != nil {
+					return nil, err
+				}
+			}
+			end = trailer - int(buf[trailer+2])
+		} else if auth.protects() {
+			return nil, errors.New("Rpc response is not signed")
+		}
+		if end < MSRPCRequestHeaderSize {
+			return nil, errors.New("Invalid rpc response")
+		}
+		if pdu == nil {
+			pdu = append(pdu, buf[:MSRPCRequestHeaderSize]...)
+		}
+		pdu = append(pdu, buf[MSRPCRequestHeaderSize:end]...)
+		last := buf[3]&LastFrag != 0
+		buf = buf[fragLength:]
+		if last {
+			// 修改为单个分片的头部
+			pdu[3] |= FirstFrag | LastFrag
+			if len(pdu) <= 0xFFFF {
+				binary.LittleEndian.PutUint16(pdu[8:], uint16(len(pdu)))
+			}
+			binary.LittleEndian.PutUint16(pdu[10:], 0)
+			return pdu, nil
+		}
+	}
+}
+
+// tcp->发送请求并读取响应，返回stub数据
+func (c *TCPClient) MSRPCCall(callId uint32, contextId, opNum uint16, stub []byte) ([]byte, error) {
+	pdu, err := c.request(callId, contextId, opNum, stub)
+	if err != nil {
+		return nil, err
+	}
+	return pdu[MSRPCRequestHeaderSize:], nil
+}
+
+// tcp->发送请求并读取响应，请求超过服务端的MaxRecvFrag时分片发送，返回拼接全部分片后的响应
+func (c *TCPClient) request(callId uint32, contextId, opNum uint16, stub []byte) ([]byte, error) {
+	frags, err := fragmentRequest(callId, contextId, opNum, stub, c.maxXmitFrag, nil)
+	if err != nil {
+		c.Debug("", err)
+		return nil, err
+	}
+	c.Debug("Sending rpc request", nil)
+	for _, frag := range frags {
+		if err = c.TCPWrite(frag); err != nil {
+			return nil, err
+		}
+	}
+	pdu, err := readFragments(c.TCPRead, nil)
+	if err != nil {
+		c.Debug("", err)
+		return nil, err
+	}
+	c.Debug("Completed rpc request", nil)
+	return pdu, nil
+}
+
+// 基于Transport的rpc客户端，接口调用与具体传输层无关
+type RPCClient struct {
+	Transport
+	*association
+	debug     bool
+	contextId uint16
+	syntax    ndr.Syntax // 服务端接受的传输语法
+}
+
+// 同一连接上绑定的多个接口共享的状态
+type association struct {
+	callId      uint32
+	contexts    uint16 // 已经分配的上下文数量，新的上下文从此开始编号
+	maxXmitFrag uint16
+	assocGroup  uint32
+	auth        *rpcAuth // 未设置认证时为空
+}
+
+func NewRPCClient(transport Transport, debug bool) *RPCClient {
+	return &RPCClient{Transport: transport, association: &association{}, debug: debug}
+}
+
+func (r *RPCClient) Debug(msg string, err error) {
+	if r.debug {
+		log.Println("[ DEBUG ] ", msg)
+		if err != nil {
+			debug.PrintStack()
+		}
+	}
+}
+
+// 服务端接受的传输语法
+func (r *RPCClient) Syntax() ndr.Syntax {
+	return r.syntax
+}
+
+func (r *RPCClient) nextCallId() uint32 {
+	r.callId++
+	return r.callId
+}
+
+// 绑定接口，syntaxes为提供的传输语法，为空时只提供NDR20
+func (r *RPCClient) Bind(uuid string, version uint32, syntaxes ...ndr.Syntax) error {
+	contextId, syntax, err := r.bind(PDUBind, uuid, version, syntaxes)
+	if err != nil {
+		return err
+	}
+	r.contextId, r.syntax = contextId, syntax
+	return nil
+}
+
+// 在已经绑定的连接上通过alter_context绑定另一个接口，返回共享连接以及认证状态的客户端
+// 关闭任意一个客户端都会关闭底层连接
+func (r *RPCClient) AlterContext(uuid string, version uint32, syntaxes ...ndr.Syntax) (*RPCClient, error) {
+	contextId, syntax, err := r.bind(PDUAlter_Context, uuid, version, syntaxes)
+	if err != nil {
+		return nil, err
+	}
+	alt := *r
+	alt.contextId, alt.syntax = contextId, syntax
+	return &alt, nil
+}
+
+// 发送bind或者alter_context请求，返回服务端接受的上下文以及传输语法
+func (r *RPCClient) bind(packetType uint8, uuid string, version uint32, syntaxes []ndr.Syntax) (uint16, ndr.Syntax, error) {
+	if len(syntaxes) == 0 {
+		syntaxes = []ndr.Syntax{ndr.NDR20}
+	}
+	if t, ok := r.Transport.(endpointResolver); ok && packetType == PDUBind {
+		if err := t.Resolve(uuid, version); err != nil {
+			r.Debug("", err)
+			return 0, ndr.NDR20, err
+		}
+	}
+	ctxs := NewCtxItems(uuid, version, syntaxes...)
+	// 同一连接上的ContextId不能重复
+	for i := range ctxs {
+		ctxs[i].ContextId += r.contexts
+	}
+	r.contexts += uint16(len(ctxs))
+	header := NewMSRPCHeader()
+	header.CallId = r.nextCallId()
+	header.PacketType = packetType
+	header.PacketFlags = FirstFrag | LastFrag
+	bindStruct := MSRPCBindStruct{
+		MSRPCHeaderStruct: header,
+		MaxXmitFrag:       DefaultMaxFrag,
+		MaxRecvFrag:       DefaultMaxFrag,
+		AssocGroup:        r.assocGroup,
+		NumCtxItems:       uint8(len(ctxs)),
+		CtxItems:          ctxs,
+	}
+	bindStruct.FragLength = uint16(util.SizeOfStruct(bindStruct))
+	buf, err := encoder.Marshal(bindStruct)
+	if err != nil {
+		r.Debug("", err)
+		return 0, ndr.NDR20, err
+	}
+	// 认证在第一次绑定时完成，alter_context沿用已经建立的安全上下文
+	authenticate := r.auth != nil && packetType == PDUBind
+	if authenticate {
+		if buf, err = r.auth.negotiate(buf); err != nil {
+			r.Debug("", err)
+			return 0, ndr.NDR20, err
+		}
+	}
+	r.Debug("Sending rpc bind", nil)
+	if err = r.Send(buf); err != nil {
+		r.Debug("", err)
+		return 0, ndr.NDR20, err
+	}
+	if buf, err = r.Recv(); err != nil {
+		r.Debug("", err)
+		return 0, ndr.NDR20, err
+	}
+	res, err := ParseMSRPCBindAck(buf)
+	if err != nil {
+		r.Debug("Raw:\n"+hex.Dump(buf), err)
+		return 0, ndr.NDR20, err
+	}
+	// 上下文被拒绝时关联仍然建立，完成认证后返回错误，之后可以继续alter_context
+	contextId, syntax, rejected := res.Accepted(ctxs)
+	if _, ok := rejected.(*ContextRejectedError); rejected != nil && !ok {
+		r.Debug("", rejected)
+		return 0, ndr.NDR20, rejected
+	}
+	if packetType == PDUBind {
+		r.maxXmitFrag = res.MaxRecvFrag
+		r.assocGroup = res.AssocGroup
+	}
+	if authenticate {
+		// 发送auth3完成认证
+		if buf, err = r.auth.authenticate(header.CallId, res.AuthValue); err != nil {
+			r.Debug("", err)
+			return 0, ndr.NDR20, err
+		}
+		r.Debug("Sending rpc auth3", nil)
+		if err = r.Send(buf); err != nil {
+			r.Debug("", err)
+			return 0, ndr.NDR20, err
+		}
+	}
+	if rejected != nil {
+		r.Debug("", rejected)
+		return 0, ndr.NDR20, rejected
+	}
+	r.Debug("Completed rpc bind", nil)
+	return contextId, syntax, nil
+}
+
+// 发送请求并读取响应，请求超过服务端的MaxRecvFrag时分片发送，返回拼接全部分片后的stub数据
+func (r *RPCClient) Call(opNum uint16, stub []byte) ([]byte, error) {
+	frags, err := fragmentRequest(r.nextCallId(), r.contextId, opNum, stub, r.maxXmitFrag, r.auth)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Sending rpc request", nil)
+	for _, frag := range frags {
+		if err = r.Send(frag); err != nil {
+			r.Debug("", err)
+			return nil, err
+		}
+	}
+	pdu, err := readFragments(r.Recv, r.auth)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Completed rpc request", nil)
+	return pdu[MSRPCRequestHeaderSize:], nil
+}
+
+// 按协商的传输语法编码req，发送请求后将响应解码到res
+func (r *RPCClient) Request(opNum uint16, req, res interface{}) error {
+	stub, err := ndr.MarshalWithSyntax(req, r.syntax)
+	if err != nil {
+		r.Debug("", err)
+		return err
+	}
+	buf, err := r.Call(opNum, stub)
+	if err != nil {
+		return err
+	}
+	if err = ndr.UnmarshalWithSyntax(buf, res, r.syntax); err != nil {
+		r.Debug("Raw:\n"+hex.Dump(buf), err)
+		return err
+	}
+	return nil
+}
+
+// smb->通过IPC$打开命名管道并绑定接口，同时提供NDR20与NDR64，由服务端选择，使用完成后需要Close
+func (c *SMBClient) OpenPipe(pipename, uuid string, version uint32) (*RPCClient, error) {
+	transport := NewNpTransport(c.Client, pipename)
+	if err := transport.Connect(); err != nil {
+		c.Debug("", err)
+		return nil, err
+	}
+	rpc := NewRPCClient(transport, c.GetDebug())
+	if err := rpc.Bind(uuid, version, ndr.NDR20, ndr.NDR64); err != nil {
+		transport.Close()
+		return nil, err
+	}
+	return rpc, nil
+}
+
+// 将rpc/win32错误码转换为错误
+func rpcStatusError(op string, status uint32) error {
+	if msg, ok := dcerpc.RpcStatusCodes[status]; ok {
+		return errors.New("Failed to " + op + ": " + msg)
+	}
+	return fmt.Errorf("Failed to %s, code: 0x%08x", op, status)
 }
@@ -0,0 +1,150 @@
+package v5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func testStub(n int) []byte {
+	stub := make([]byte, n)
+	for i := range stub {
+		stub[i] = byte(i)
+	}
+	return stub
+}
+
+// 不带认证信息的单个分片
+func testFragment(packetType, flags uint8, stub []byte) []byte {
+	frag := make([]byte, MSRPCRequestHeaderSize, MSRPCRequestHeaderSize+len(stub))
+	frag[0], frag[2], frag[3], frag[4] = 5, packetType, flags, 0x10
+	frag = append(frag, stub...)
+	binary.LittleEndian.PutUint16(frag[8:], uint16(len(frag)))
+	binary.LittleEndian.PutUint32(frag[16:], uint32(len(stub)))
+	return frag
+}
+
+// 每次返回data中的n个字节，模拟传输层读取到不完整的分片
+func testReader(data []byte, n int) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		if n > len(data) {
+			n = len(data)
+		}
+		buf := data[:n]
+		data = data[n:]
+		return buf, nil
+	}
+}
+
+func TestFragmentRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		stubLen int
+		maxFrag uint16
+		want    []int // 每个分片的stub长度
+	}{
+		{"split", 100, MSRPCRequestHeaderSize + 32, []int{32, 32, 32, 4}},
+		{"align down", 100, MSRPCRequestHeaderSize + 39, []int{32, 32, 32, 4}},
+		{"exact", 64, MSRPCRequestHeaderSize + 32, []int{32, 32}},
+		{"single", 10, MSRPCRequestHeaderSize + 32, []int{10}},
+		{"empty", 0, MSRPCRequestHeaderSize + 32, []int{0}},
+		{"default", DefaultMaxFrag, 0, []int{DefaultMaxFrag - MSRPCRequestHeaderSize, MSRPCRequestHeaderSize}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := testStub(tt.stubLen)
+			frags, err := fragmentRequest(7, 1, 15, stub, tt.maxFrag, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(frags) != len(tt.want) {
+				t.Fatalf("got %d fragments, want %d", len(frags), len(tt.want))
+			}
+			var got []byte
+			for i, frag := range frags {
+				var flags uint8
+				if i == 0 {
+					flags |= FirstFrag
+				}
+				if i == len(frags)-1 {
+					flags |= LastFrag
+				}
+				if frag[2] != PDURequest || frag[3] != flags {
+					t.Errorf("fragment %d: type %d flags 0x%02x, want %d 0x%02x", i, frag[2], frag[3], PDURequest, flags)
+				}
+				if fragLength := binary.LittleEndian.Uint16(frag[8:]); int(fragLength) != len(frag) || len(frag) != MSRPCRequestHeaderSize+tt.want[i] {
+					t.Errorf("fragment %d: frag_length %d, length %d, want %d", i, fragLength, len(frag), MSRPCRequestHeaderSize+tt.want[i])
+				}
+				if callId := binary.LittleEndian.Uint32(frag[12:]); callId != 7 {
+					t.Errorf("fragment %d: call_id %d, want 7", i, callId)
+				}
+				// alloc_hint为包括本分片在内剩余的stub长度
+				if allocHint := binary.LittleEndian.Uint32(frag[16:]); int(allocHint) != tt.stubLen-len(got) {
+					t.Errorf("fragment %d: alloc_hint %d, want %d", i, allocHint, tt.stubLen-len(got))
+				}
+				if binary.LittleEndian.Uint16(frag[20:]) != 1 || binary.LittleEndian.Uint16(frag[22:]) != 15 {
+					t.Errorf("fragment %d: p_cont_id %d opnum %d", i, binary.LittleEndian.Uint16(frag[20:]), binary.LittleEndian.Uint16(frag[22:]))
+				}
+				got = append(got, frag[MSRPCRequestHeaderSize:]...)
+			}
+			if !bytes.Equal(got, stub) {
+				t.Errorf("stub = %x, want %x", got, stub)
+			}
+		})
+	}
+	if _, err := fragmentRequest(1, 0, 0, testStub(10), MSRPCRequestHeaderSize+7, nil); err == nil {
+		t.Error("fragment size smaller than 8 accepted")
+	}
+}
+
+// 多个响应分片拼接为单个分片的响应
+func TestReadFragments(t *testing.T) {
+	stub := testStub(100)
+	frags, err := fragmentRequest(1, 0, 0, stub, MSRPCRequestHeaderSize+32, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream []byte
+	for _, frag := range frags {
+		frag[2] = PDUResponse
+		stream = append(stream, frag...)
+	}
+	// 一次读取全部分片、每次读取不完整的分片
+	for _, n := range []int{len(stream), 5} {
+		pdu, err := readFragments(testReader(stream, n), nil)
+		if err != nil {
+			t.Fatalf("read %d bytes at a time: %v", n, err)
+		}
+		if !bytes.Equal(pdu[MSRPCRequestHeaderSize:], stub) {
+			t.Errorf("read %d bytes at a time: stub = %x, want %x", n, pdu[MSRPCRequestHeaderSize:], stub)
+		}
+		if pdu[3] != FirstFrag|LastFrag || int(binary.LittleEndian.Uint16(pdu[8:])) != len(pdu) {
+			t.Errorf("read %d bytes at a time: flags 0x%02x frag_length %d", n, pdu[3], binary.LittleEndian.Uint16(pdu[8:]))
+		}
+	}
+}
+
+func TestReadFragmentsErrors(t *testing.T) {
+	first := testFragment(PDUResponse, FirstFrag, testStub(8))
+	var status [8]byte
+	// nca_s_op_rng_error
+	binary.LittleEndian.PutUint32(status[:], 0x1c010002)
+	short := testFragment(PDUResponse, FirstFrag|LastFrag, nil)
+	binary.LittleEndian.PutUint16(short[8:], MSRPCRequestHeaderSize-1)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"fault after first fragment", append(append([]byte(nil), first...), testFragment(PDUFault, LastFrag, status[:])...)},
+		{"short frag_length", short},
+		{"unexpected packet type", testFragment(PDURequest, FirstFrag|LastFrag, nil)},
+		{"connection closed", first},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readFragments(testReader(tt.data, len(tt.data)), nil); err == nil {
+				t.Error("readFragments succeeded")
+			}
+		})
+	}
+}
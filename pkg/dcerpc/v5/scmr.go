@@ -1,38 +1,13 @@
 package v5
 
 import (
-	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc/ndr"
 	"github.com/Amzza0x00/go-impacket/pkg/util"
 )
 
 // 此文件提供访问windows服务管理封装
 
-// 打开服务管理结构
-// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-scmr/dc84adb3-d51d-48eb-820d-ba1c6ca5faf2
-type OpenSCManagerWStruct struct {
-	MachineName machineName
-	Database    database
-	AccessMask  uint32
-}
-
-type machineName struct {
-	ReferentId  uint32 `smb:"offset:MachineName"`
-	MaxCount    uint32
-	Offset      uint32
-	ActualCount uint32 //机器名的长度
-	MachineName []byte //任意长度,unicode编码
-	Reserved    uint16
-}
-
-type database struct {
-	ReferentId  uint32 `smb:"offset:Database"`
-	MaxCount    uint32
-	Offset      uint32
-	ActualCount uint32 //机器名的长度
-	Database    []byte //任意长度,unicode编码
-	Reserved    uint16
-}
-
 // https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-scmr/0d7a7011-9f41-470d-ad52-8535b47ac282
 // 安全描述符
 const (
@@ -41,17 +16,6 @@ const (
 	SC_MANAGER_CONNECT        = 0x00000001
 )
 
-// OpenSCManagerW响应结构
-type OpenSCManagerWResponse struct {
-	MSRPCHeaderStruct
-	AllocHint     uint32
-	ContextId     uint16
-	CancelCount   uint8
-	Reserved      uint8
-	ContextHandle []byte `smb:"fixed:20"`
-	ReturnCode    uint32
-}
-
 // opnum
 // https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-scmr/0d7a7011-9f41-470d-ad52-8535b47ac282
 const (
@@ -107,163 +71,6 @@ const (
 	ROpenSCManager2             = 64
 )
 
-// OpenSCManagerW请求
-// DWORD ROpenSCManagerW(
-//
-//	[in, string, unique, range(0, SC_MAX_COMPUTER_NAME_LENGTH)] SVCCTL_HANDLEW lpMachineName,
-//	[in, string, unique, range(0, SC_MAX_NAME_LENGTH)] wchar_t* lpDatabaseName,
-//	[in] DWORD dwDesiredAccess,
-//	[out] LPSC_RPC_HANDLE lpScHandle
-//	);
-//
-// lpMachineName：一种 SVCCTL_HANDLEW（第 2.2.3 节）数据类型，它定义指向以空字符结尾的 UNICODE 字符串的指针，该字符串指定服务器的机器名称。
-// lpDatabaseName：指向以空结尾的 UNICODE 字符串的指针，该字符串指定要打开的 SCM 数据库的名称。该参数必须设置为 NULL、“ServicesActive”或“ServicesFailed”。
-// dwDesiredAccess：一个值，指定对数据库的访问。这必须是第 3.1.4 节中指定的值之一。
-// 客户端还必须具有 SC_MANAGER_CONNECT 访问权限。
-// lpScHandle：一种 LPSC_RPC_HANDLE 数据类型，用于定义新打开的 SCM 数据库的句柄。
-func NewOpenSCManagerWRequest() MSRPCRequestHeaderStruct {
-	header := NewMSRPCHeader()
-	//header.CallId = 2
-	header.PacketType = PDURequest
-	header.PacketFlags = PDUFault
-	// 服务请求
-	machinename := string(util.Random(6)) + "\x00"
-	databaseName := "ServicesActive\x00"
-	buffer := OpenSCManagerWStruct{
-		MachineName: machineName{
-			MaxCount:    uint32(len(machinename)),
-			ActualCount: uint32(len(machinename)),
-			MachineName: encoder.ToUnicode(machinename),
-		},
-		Database: database{
-			MaxCount:    uint32(len(databaseName)),
-			ActualCount: uint32(len(databaseName)),
-			Database:    encoder.ToUnicode(databaseName),
-		},
-		AccessMask: SC_MANAGER_CREATE_SERVICE | SC_MANAGER_CONNECT,
-	}
-	fragLength := 24 + util.SizeOfStruct(buffer)
-	header.FragLength = uint16(fragLength)
-	return MSRPCRequestHeaderStruct{
-		MSRPCHeaderStruct: header,
-		ContextId:         0,
-		OpNum:             ROpenSCManagerW,
-		Buffer:            buffer,
-	}
-}
-
-func NewOpenSCManagerWResponse() OpenSCManagerWResponse {
-	return OpenSCManagerWResponse{
-		ContextHandle: make([]byte, 20),
-	}
-}
-
-// 打开服务
-// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-scmr/6d0a4225-451b-4132-894d-7cef7aecfd2d
-type ROpenServiceWRequestStruct struct {
-	ContextHandle []byte `smb:"fixed:20"` //OpenSCManagerW 句柄
-	ServiceName   serviceName
-	AccessMask    uint32
-}
-
-type ROpenServiceWResponseStruct struct {
-	MSRPCHeaderStruct
-	AllocHint     uint32
-	ContextId     uint16
-	CancelCount   uint8
-	Reserved      uint8
-	ContextHandle []byte `smb:"fixed:20"`
-	ReturnCode    uint32
-}
-
-// 初始化打开服务请求
-func NewROpenServiceWRequest(contextHandle []byte, servicename string) MSRPCRequestHeaderStruct {
-	header := NewMSRPCHeader()
-	//header.CallId = 3
-	header.PacketType = PDURequest
-	header.PacketFlags = PDUFault
-	serName := servicename + "\x00"
-	buffer := ROpenServiceWRequestStruct{
-		ContextHandle: contextHandle,
-		ServiceName: serviceName{
-			MaxCount:    uint32(len(serName)),
-			ActualCount: uint32(len(serName)),
-			ServiceName: encoder.ToUnicode(serName),
-		},
-		AccessMask: SERVICE_ALL_ACCESS,
-	}
-	fragLength := 24 + util.SizeOfStruct(buffer) // 头固定大小24
-	header.FragLength = uint16(fragLength)
-	return MSRPCRequestHeaderStruct{
-		MSRPCHeaderStruct: header,
-		ContextId:         0,
-		OpNum:             ROpenServiceW,
-		Buffer:            buffer,
-	}
-}
-
-func NewROpenServiceWResponse() ROpenServiceWResponseStruct {
-	return ROpenServiceWResponseStruct{
-		ContextHandle: make([]byte, 20),
-	}
-}
-
-// 创建服务
-// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-scmr/6a8ca926-9477-4dd4-b766-692fab07227e
-type RCreateServiceWRequestStruct struct {
-	ContextHandle       []byte `smb:"fixed:20"` //OpenSCManagerW 句柄
-	ServiceName         serviceName
-	DisplayName         displayName
-	AccessMask          uint32
-	ServiceType         uint32
-	ServiceStartType    uint32
-	ServiceErrorControl uint32
-	BinaryPathName      binaryPathName
-	NULLPointer         uint32
-	TagId               uint32
-	NULLPointer2        uint32
-	DependSize          uint32
-	NULLPointer3        uint32
-	NULLPointer4        uint32
-	PasswordSize        uint32
-}
-
-type serviceName struct {
-	MaxCount    uint32
-	Offset      uint32
-	ActualCount uint32
-	ServiceName []byte
-	Reserved    uint16
-}
-
-type displayName struct {
-	ReferentId  uint32 `smb:"offset:DisplayName"`
-	MaxCount    uint32
-	Offset      uint32
-	ActualCount uint32
-	DisplayName []byte
-	Reserved    uint16
-}
-
-type binaryPathName struct {
-	MaxCount       uint32
-	Offset         uint32
-	ActualCount    uint32
-	BinaryPathName []byte `smb:"fixed:26"` // 长度不能超过26字节
-}
-
-// RCreateServiceW响应结构
-type RCreateServiceWResponseStruct struct {
-	MSRPCHeaderStruct
-	AllocHint     uint32
-	ContextId     uint16
-	CancelCount   uint8
-	Reserved      uint8
-	TagId         uint32
-	ContextHandle []byte `smb:"fixed:20"`
-	ReturnCode    uint32
-}
-
 // https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-scmr/6a8ca926-9477-4dd4-b766-692fab07227e
 // dwServiceType 类型
 const (
@@ -291,168 +98,184 @@ const (
 	SERVICE_ERROR_CRITICAL = 0x00000003
 )
 
-func NewRCreateServiceWRequest(contextHandle []byte, servicename, uploadPathFile string) MSRPCRequestHeaderStruct {
-	header := NewMSRPCHeader()
-	//header.CallId = 4
-	header.PacketType = PDURequest
-	header.PacketFlags = PDUFault
-	serName := servicename + "\x00"
-	uploadpathFile := uploadPathFile + "\x00"
-	buffer := RCreateServiceWRequestStruct{
-		ContextHandle: contextHandle,
-		ServiceName: serviceName{
-			MaxCount:    uint32(len(serName)),
-			ActualCount: uint32(len(serName)),
-			ServiceName: encoder.ToUnicode(serName),
-		},
-		DisplayName: displayName{
-			MaxCount:    uint32(len(serName)),
-			ActualCount: uint32(len(serName)),
-			DisplayName: encoder.ToUnicode(serName),
-		},
-		AccessMask:          SERVICE_ALL_ACCESS,
-		ServiceType:         SERVICE_WIN32_OWN_PROCESS,
-		ServiceStartType:    SERVICE_DEMAND_START,
-		ServiceErrorControl: SERVICE_ERROR_IGNORE,
-		BinaryPathName: binaryPathName{
-			MaxCount:       uint32(len(uploadpathFile)),
-			ActualCount:    uint32(len(uploadpathFile)),
-			BinaryPathName: encoder.ToUnicode(uploadpathFile),
-		},
-	}
-	fragLength := 24 + util.SizeOfStruct(buffer) // 头固定大小24
-	header.FragLength = uint16(fragLength)
-	return MSRPCRequestHeaderStruct{
-		MSRPCHeaderStruct: header,
-		ContextId:         0,
-		OpNum:             RCreateServiceW,
-		Buffer:            buffer,
-	}
+// ROpenSCManagerW请求，lpMachineName以及lpDatabaseName为unique字符串
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-scmr/dc84adb3-d51d-48eb-820d-ba1c6ca5faf2
+type rOpenSCManagerWRequest struct {
+	MachineName   string
+	DatabaseName  string
+	DesiredAccess uint32
 }
 
-func NewRCreateServiceWResponse() RCreateServiceWResponseStruct {
-	return RCreateServiceWResponseStruct{
-		ContextHandle: make([]byte, 20),
-	}
+type rOpenSCManagerWResponse struct {
+	SCHandle   ndr.ContextHandle
+	ReturnCode uint32
 }
 
-// 启动服务
-// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-scmr/d9be95a2-cf01-4bdc-b30f-6fe4b37ada16
-type RStartServiceWRequestStruct struct {
-	ContextHandle []byte `smb:"fixed:20"` //20字节，创建服务返回的句柄
-	Argc          uint32 //argv字符串数量
-	Argv          []byte `smb:"fixed:4"` //4字节，unicode对象指针
+// ROpenServiceW请求
+type rOpenServiceWRequest struct {
+	SCManager     ndr.ContextHandle
+	ServiceName   string `ndr:"ref"`
+	DesiredAccess uint32
 }
 
-type RStartServiceWResponseStruct struct {
-	MSRPCHeaderStruct
-	AllocHint   uint32
-	ContextId   uint16
-	CancelCount uint8
-	Reserved    uint8
-	StubData    uint32
+type rOpenServiceWResponse struct {
+	Service    ndr.ContextHandle
+	ReturnCode uint32
 }
 
-// 启动服务封装
-func NewRStartServiceWRequest(contextHandle []byte) MSRPCRequestHeaderStruct {
-	header := NewMSRPCHeader()
-	//header.CallId = 5
-	header.PacketType = PDURequest
-	header.PacketFlags = PDUFault
-	argv := encoder.ToUnicode(string(make([]byte, 4)))
-	buffer := RStartServiceWRequestStruct{
-		ContextHandle: contextHandle,
-		Argc:          0,
-		Argv:          argv[:4],
-	}
-	fragLength := 24 + util.SizeOfStruct(buffer) // 头固定大小24
-	header.FragLength = uint16(fragLength)
-	return MSRPCRequestHeaderStruct{
-		MSRPCHeaderStruct: header,
-		ContextId:         0,
-		OpNum:             RStartServiceW,
-		Buffer:            buffer,
-	}
+// RCreateServiceW请求，依赖、登录账户以及密码为空
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-scmr/6a8ca926-9477-4dd4-b766-692fab07227e
+type rCreateServiceWRequest struct {
+	SCManager        ndr.ContextHandle
+	ServiceName      string `ndr:"ref"`
+	DisplayName      string
+	DesiredAccess    uint32
+	ServiceType      uint32
+	StartType        uint32
+	ErrorControl     uint32
+	BinaryPathName   string `ndr:"ref"`
+	LoadOrderGroup   string
+	TagId            *uint32
+	Dependencies     []byte
+	DependSize       uint32
+	ServiceStartName string
+	Password         []byte
+	PwSize           uint32
+}
+
+type rCreateServiceWResponse struct {
+	TagId      *uint32
+	Service    ndr.ContextHandle
+	ReturnCode uint32
+}
+
+// RStartServiceW请求，不传递启动参数
+type rStartServiceWRequest struct {
+	Service ndr.ContextHandle
+	Argc    uint32
+	Argv    []string
+}
+
+// RDeleteService请求
+type rDeleteServiceRequest struct {
+	Service ndr.ContextHandle
 }
 
-// 启动服务响应
-func NewRStartServiceWResponse() RStartServiceWResponseStruct {
-	return RStartServiceWResponseStruct{}
+// 只有返回码的响应
+type scmrStatusResponse struct {
+	ReturnCode uint32
 }
 
-// 删除服务结构
-// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-scmr/6744cdb8-f162-4be0-bb31-98996b6495be
-type RDeleteServiceRequestStruct struct {
-	ContextHandle []byte `smb:"fixed:20"` //20字节，创建服务返回的句柄
+// RCloseServiceHandle请求，响应中的句柄被置空
+type rCloseServiceHandleRequest struct {
+	SCObject ndr.ContextHandle
 }
 
-type RDeleteServiceResponseStruct struct {
-	MSRPCHeaderStruct
-	AllocHint   uint32
-	ContextId   uint16
-	CancelCount uint8
-	Reserved    uint8
-	ReturnCode  uint32
+type rCloseServiceHandleResponse struct {
+	SCObject   ndr.ContextHandle
+	ReturnCode uint32
 }
 
-// 删除服务封装
-func NewRDeleteServiceRequest(contextHandle []byte) MSRPCRequestHeaderStruct {
-	header := NewMSRPCHeader()
-	//header.CallId = 5
-	header.PacketType = PDURequest
-	header.PacketFlags = PDUFault
-	buffer := RDeleteServiceRequestStruct{
-		ContextHandle: contextHandle,
+// 打开服务管理，返回scm句柄，需要先绑定svcctl接口
+func (r *RPCClient) ROpenSCManagerW(desiredAccess uint32) (ndr.ContextHandle, error) {
+	req := &rOpenSCManagerWRequest{
+		MachineName:   string(util.Random(6)),
+		DatabaseName:  "ServicesActive",
+		DesiredAccess: desiredAccess,
+	}
+	var res rOpenSCManagerWResponse
+	r.Debug("Sending svcctl ROpenSCManagerW request", nil)
+	if err := r.Request(ROpenSCManagerW, req, &res); err != nil {
+		return ndr.ContextHandle{}, err
 	}
-	fragLength := 24 + util.SizeOfStruct(buffer) // 头固定大小24
-	header.FragLength = uint16(fragLength)
-	return MSRPCRequestHeaderStruct{
-		MSRPCHeaderStruct: header,
-		ContextId:         0,
-		OpNum:             RDeleteService,
-		Buffer:            buffer,
+	if res.ReturnCode != dcerpc.RPC_S_OK {
+		return ndr.ContextHandle{}, rpcStatusError("ROpenSCManagerW", res.ReturnCode)
 	}
+	r.Debug("Completed ROpenSCManagerW", nil)
+	return res.SCHandle, nil
 }
 
-// 删除服务响应
-func NewRDeleteServiceResponse() RDeleteServiceResponseStruct {
-	return RDeleteServiceResponseStruct{}
+// 打开服务，返回服务句柄
+func (r *RPCClient) ROpenServiceW(scManager ndr.ContextHandle, servicename string, desiredAccess uint32) (ndr.ContextHandle, error) {
+	req := &rOpenServiceWRequest{
+		SCManager:     scManager,
+		ServiceName:   servicename,
+		DesiredAccess: desiredAccess,
+	}
+	var res rOpenServiceWResponse
+	r.Debug("Sending svcctl ROpenServiceW request", nil)
+	if err := r.Request(ROpenServiceW, req, &res); err != nil {
+		return ndr.ContextHandle{}, err
+	}
+	if res.ReturnCode != dcerpc.RPC_S_OK {
+		return ndr.ContextHandle{}, rpcStatusError("ROpenServiceW", res.ReturnCode)
+	}
+	r.Debug("Completed ROpenServiceW", nil)
+	return res.Service, nil
 }
 
-// 关闭服务句柄
-type RCloseServiceHandleRequestStruct struct {
-	ContextHandle []byte `smb:"fixed:20"`
+// 创建手动启动的独立进程服务，显示名称与服务名相同，返回服务句柄
+func (r *RPCClient) RCreateServiceW(scManager ndr.ContextHandle, servicename, binaryPathName string) (ndr.ContextHandle, error) {
+	req := &rCreateServiceWRequest{
+		SCManager:      scManager,
+		ServiceName:    servicename,
+		DisplayName:    servicename,
+		DesiredAccess:  SERVICE_ALL_ACCESS,
+		ServiceType:    SERVICE_WIN32_OWN_PROCESS,
+		StartType:      SERVICE_DEMAND_START,
+		ErrorControl:   SERVICE_ERROR_IGNORE,
+		BinaryPathName: binaryPathName,
+	}
+	var res rCreateServiceWResponse
+	r.Debug("Sending svcctl RCreateServiceW request", nil)
+	if err := r.Request(RCreateServiceW, req, &res); err != nil {
+		return ndr.ContextHandle{}, err
+	}
+	if res.ReturnCode != dcerpc.RPC_S_OK {
+		return ndr.ContextHandle{}, rpcStatusError("RCreateServiceW", res.ReturnCode)
+	}
+	r.Debug("Completed RCreateServiceW to ["+servicename+"]", nil)
+	return res.Service, nil
 }
 
-type RCloseServiceHandleResponseStruct struct {
-	MSRPCHeaderStruct
-	AllocHint     uint32
-	ContextId     uint16
-	CancelCount   uint8
-	Reserved      uint8
-	ContextHandle []byte `smb:"fixed:20"`
-	ReturnCode    uint32
+// 启动服务
+func (r *RPCClient) RStartServiceW(service ndr.ContextHandle) error {
+	var res scmrStatusResponse
+	r.Debug("Sending svcctl RStartServiceW request", nil)
+	if err := r.Request(RStartServiceW, &rStartServiceWRequest{Service: service}, &res); err != nil {
+		return err
+	}
+	if res.ReturnCode != dcerpc.RPC_S_OK {
+		return rpcStatusError("RStartServiceW", res.ReturnCode)
+	}
+	r.Debug("Completed RStartServiceW", nil)
+	return nil
 }
 
-// 初始化关闭服务句柄
-func NewRCloseServiceHandleRequest(contextHandle []byte) MSRPCRequestHeaderStruct {
-	header := NewMSRPCHeader()
-	//header.CallId = 6
-	header.PacketType = PDURequest
-	header.PacketFlags = PDUFault
-	buffer := RCloseServiceHandleRequestStruct{ContextHandle: contextHandle}
-	fragLength := 24 + util.SizeOfStruct(buffer) // 头固定大小24
-	header.FragLength = uint16(fragLength)
-	return MSRPCRequestHeaderStruct{
-		MSRPCHeaderStruct: header,
-		ContextId:         0,
-		OpNum:             RCloseServiceHandle,
-		Buffer:            buffer,
+// 删除服务，服务在所有句柄关闭后才会被删除
+func (r *RPCClient) RDeleteService(service ndr.ContextHandle) error {
+	var res scmrStatusResponse
+	r.Debug("Sending svcctl RDeleteService request", nil)
+	if err := r.Request(RDeleteService, &rDeleteServiceRequest{Service: service}, &res); err != nil {
+		return err
+	}
+	if res.ReturnCode != dcerpc.RPC_S_OK {
+		return rpcStatusError("RDeleteService", res.ReturnCode)
 	}
+	r.Debug("Completed RDeleteService", nil)
+	return nil
 }
 
-func NewRCloseServiceHandleResponse() RCloseServiceHandleResponseStruct {
-	return RCloseServiceHandleResponseStruct{
-		ContextHandle: make([]byte, 20),
+// 关闭scm或者服务句柄
+func (r *RPCClient) RCloseServiceHandle(handle ndr.ContextHandle) error {
+	var res rCloseServiceHandleResponse
+	r.Debug("Sending svcctl RCloseServiceHandle request", nil)
+	if err := r.Request(RCloseServiceHandle, &rCloseServiceHandleRequest{SCObject: handle}, &res); err != nil {
+		return err
+	}
+	if res.ReturnCode != dcerpc.RPC_S_OK {
+		return rpcStatusError("RCloseServiceHandle", res.ReturnCode)
 	}
+	r.Debug("Completed RCloseServiceHandle", nil)
+	return nil
 }
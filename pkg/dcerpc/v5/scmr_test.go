@@ -0,0 +1,150 @@
+package v5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc/ndr"
+	"testing"
+)
+
+func testHandle() ndr.ContextHandle {
+	h := ndr.ContextHandle{}
+	for i := range h.UUID {
+		h.UUID[i] = byte(0x11 * (i%15 + 1))
+	}
+	return h
+}
+
+func marshalGolden(t *testing.T, v interface{}, want string) {
+	t.Helper()
+	buf, err := ndr.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := unhex(t, want); !bytes.Equal(buf, w) {
+		t.Errorf("Marshal =\n%x\nwant\n%x", buf, w)
+	}
+}
+
+func TestROpenSCManagerWRequest(t *testing.T) {
+	marshalGolden(t, &rOpenSCManagerWRequest{
+		MachineName:   "DUMMY",
+		DatabaseName:  "ServicesActive",
+		DesiredAccess: SC_MANAGER_CREATE_SERVICE | SC_MANAGER_CONNECT,
+	}, "04000200 06000000 00000000 06000000 44005500 4d004d00 59000000"+
+		"08000200 0f000000 00000000 0f000000"+
+		"53006500 72007600 69006300 65007300 41006300 74006900 76006500 0000 0000"+
+		"03000000")
+}
+
+func TestRCreateServiceWRequest(t *testing.T) {
+	handle := testHandle()
+	marshalGolden(t, &rCreateServiceWRequest{
+		SCManager:      handle,
+		ServiceName:    "svc",
+		DisplayName:    "svc",
+		DesiredAccess:  SERVICE_ALL_ACCESS,
+		ServiceType:    SERVICE_WIN32_OWN_PROCESS,
+		StartType:      SERVICE_DEMAND_START,
+		ErrorControl:   SERVICE_ERROR_IGNORE,
+		BinaryPathName: "C:\\a.exe",
+	}, "00000000 112233445566778899aabbccddeeff11"+
+		"04000000 00000000 04000000 73007600 63000000"+
+		"04000200 04000000 00000000 04000000 73007600 63000000"+
+		"ff010f00 10000000 03000000 00000000"+
+		"09000000 00000000 09000000 43003a00 5c006100 2e006500 78006500 0000 0000"+
+		"00000000 00000000 00000000 00000000 00000000 00000000 00000000")
+}
+
+func TestRStartServiceWRequest(t *testing.T) {
+	marshalGolden(t, &rStartServiceWRequest{Service: testHandle()},
+		"00000000 112233445566778899aabbccddeeff11 00000000 00000000")
+}
+
+func TestRCreateServiceWResponse(t *testing.T) {
+	var res rCreateServiceWResponse
+	buf := unhex(t, "00000000 00000000 112233445566778899aabbccddeeff11 00000000")
+	if err := ndr.Unmarshal(buf, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.TagId != nil || res.Service != testHandle() || res.ReturnCode != 0 {
+		t.Errorf("RCreateServiceW response = %+v", res)
+	}
+}
+
+// 按顺序返回预设响应的传输层
+type fakeTransport struct {
+	sent      [][]byte
+	responses [][]byte
+}
+
+func (t *fakeTransport) Connect() error { return nil }
+
+func (t *fakeTransport) Send(pdu []byte) error {
+	t.sent = append(t.sent, append([]byte(nil), pdu...))
+	return nil
+}
+
+func (t *fakeTransport) Recv() ([]byte, error) {
+	if len(t.responses) == 0 {
+		return nil, errors.New("no response")
+	}
+	res := t.responses[0]
+	t.responses = t.responses[1:]
+	return res, nil
+}
+
+func (t *fakeTransport) Close() error { return nil }
+
+func (t *fakeTransport) SessionKey() []byte { return nil }
+
+// 单个分片的响应或者fault PDU
+func testPDU(packetType uint8, stub []byte) []byte {
+	return testFragment(packetType, FirstFrag|LastFrag, stub)
+}
+
+func TestROpenSCManagerW(t *testing.T) {
+	handle := testHandle()
+	stub, _ := ndr.Marshal(&rOpenSCManagerWResponse{SCHandle: handle})
+	transport := &fakeTransport{responses: [][]byte{testPDU(PDUResponse, stub)}}
+	res, err := NewRPCClient(transport, false).ROpenSCManagerW(SC_MANAGER_CONNECT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != handle {
+		t.Errorf("handle = %x, want %x", res.UUID, handle.UUID)
+	}
+	if opnum := binary.LittleEndian.Uint16(transport.sent[0][22:]); opnum != ROpenSCManagerW {
+		t.Errorf("opnum = %d, want %d", opnum, ROpenSCManagerW)
+	}
+}
+
+// fault、无法解码的响应以及失败的返回码都需要返回错误
+func TestSCMRErrors(t *testing.T) {
+	var status [4]byte
+	binary.LittleEndian.PutUint32(status[:], dcerpc.NCA_S_OP_RNG_ERROR)
+	tests := []struct {
+		name string
+		pdu  []byte
+	}{
+		{"fault", testPDU(PDUFault, append(status[:], 0, 0, 0, 0))},
+		{"truncated", testPDU(PDUResponse, make([]byte, 2))},
+		{"access denied", testPDU(PDUResponse, unhex(t, "05000000"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rpc := NewRPCClient(&fakeTransport{responses: [][]byte{tt.pdu}}, false)
+			if err := rpc.RDeleteService(testHandle()); err == nil {
+				t.Error("RDeleteService succeeded")
+			}
+		})
+	}
+	// fault返回FaultError
+	rpc := NewRPCClient(&fakeTransport{responses: [][]byte{tests[0].pdu}}, false)
+	var fault *FaultError
+	if _, err := rpc.ROpenSCManagerW(SC_MANAGER_CONNECT); !errors.As(err, &fault) || fault.Status != dcerpc.NCA_S_OP_RNG_ERROR {
+		t.Errorf("err = %v, want FaultError", err)
+	}
+}
@@ -0,0 +1,855 @@
+package v5
+
+import (
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+)
+
+// 此文件提供srvsvc服务访问封装
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-srvs/accf23b0-0f57-441c-9185-43041f1b0ee9
+
+// opnum
+const (
+	NetrConnectionEnum = 8
+	NetrFileEnum       = 9
+	NetrSessionEnum    = 12
+	NetrShareEnum      = 15
+	NetrShareGetInfo   = 16
+	NetrServerGetInfo  = 21
+)
+
+// MS-SRVS 2.2.2.4 Share Types
+// 共享类型，低位为基本类型，高位为附加标识
+const (
+	STYPE_DISKTREE     = 0x00000000
+	STYPE_PRINTQ       = 0x00000001
+	STYPE_DEVICE       = 0x00000002
+	STYPE_IPC          = 0x00000003
+	STYPE_CLUSTER_FS   = 0x02000000
+	STYPE_CLUSTER_SOFS = 0x04000000
+	STYPE_CLUSTER_DFS  = 0x08000000
+	STYPE_TEMPORARY    = 0x40000000
+	STYPE_SPECIAL      = 0x80000000
+	STYPE_MASK         = 0x000000FF
+)
+
+// 服务端返回的结果多于请求时返回该错误码
+const ERROR_MORE_DATA = 0x000000EA
+
+// 不限制服务端返回的数据长度
+const MAX_PREFERRED_LENGTH = 0xFFFFFFFF
+
+// 共享信息，字段是否有值取决于查询级别
+// 0：Name
+// 1：Name、Type、Remark
+// 2：1级别的全部字段以及Permissions、MaxUses、CurrentUses、Path、Password
+// 502：2级别的全部字段以及SecurityDescriptor
+type ShareInfo struct {
+	Name               string
+	Type               uint32
+	Remark             string
+	Permissions        uint32
+	MaxUses            uint32
+	CurrentUses        uint32
+	Path               string
+	Password           string
+	SecurityDescriptor []byte
+}
+
+// 共享基本类型名称
+func (s ShareInfo) TypeName() string {
+	switch s.Type & STYPE_MASK {
+	case STYPE_DISKTREE:
+		return "Disk"
+	case STYPE_PRINTQ:
+		return "Printer"
+	case STYPE_DEVICE:
+		return "Device"
+	case STYPE_IPC:
+		return "IPC"
+	}
+	return "Unknown"
+}
+
+// 是否为磁盘共享
+func (s ShareInfo) IsDisk() bool {
+	return s.Type&STYPE_MASK == STYPE_DISKTREE
+}
+
+// MS-SRVS SHARE_INFO_0/SHARE_INFO_1/SHARE_INFO_2/SHARE_INFO_502_I
+type shareInfo0 struct {
+	Name string
+}
+
+type shareInfo1 struct {
+	Name   string
+	Type   uint32
+	Remark string
+}
+
+type shareInfo2 struct {
+	Name        string
+	Type        uint32
+	Remark      string
+	Permissions uint32
+	MaxUses     uint32
+	CurrentUses uint32
+	Path        string
+	Password    string
+}
+
+type shareInfo502 struct {
+	Name               string
+	Type               uint32
+	Remark             string
+	Permissions        uint32
+	MaxUses            uint32
+	CurrentUses        uint32
+	Path               string
+	Password           string
+	Reserved           uint32 // 安全描述符长度
+	SecurityDescriptor []byte
+}
+
+type shareInfo0Container struct {
+	EntriesRead uint32
+	Buffer      []shareInfo0
+}
+
+type shareInfo1Container struct {
+	EntriesRead uint32
+	Buffer      []shareInfo1
+}
+
+type shareInfo2Container struct {
+	EntriesRead uint32
+	Buffer      []shareInfo2
+}
+
+type shareInfo502Container struct {
+	EntriesRead uint32
+	Buffer      []shareInfo502
+}
+
+// SHARE_ENUM_STRUCT
+type shareEnumStruct struct {
+	Level     uint32
+	ShareInfo struct {
+		Level    uint32                 `ndr:"switch"`
+		Level0   *shareInfo0Container   `ndr:"case:0"`
+		Level1   *shareInfo1Container   `ndr:"case:1"`
+		Level2   *shareInfo2Container   `ndr:"case:2"`
+		Level502 *shareInfo502Container `ndr:"case:502"`
+	}
+}
+
+// NetrShareEnum请求
+// NET_API_STATUS NetrShareEnum(
+//
+//	[in, string, unique] SRVSVC_HANDLE ServerName,
+//	[in, out] LPSHARE_ENUM_STRUCT InfoStruct,
+//	[in] DWORD PreferedMaximumLength,
+//	[out] DWORD* TotalEntries,
+//	[in, out, unique] DWORD* ResumeHandle
+//	);
+type netrShareEnumRequest struct {
+	ServerName            string // 为空表示本机
+	InfoStruct            shareEnumStruct
+	PreferedMaximumLength uint32
+	ResumeHandle          *uint32
+}
+
+type netrShareEnumResponse struct {
+	InfoStruct   shareEnumStruct
+	TotalEntries uint32
+	ResumeHandle *uint32
+	ReturnCode   uint32
+}
+
+func newNetrShareEnumRequest(level uint32) (*netrShareEnumRequest, error) {
+	req := netrShareEnumRequest{
+		PreferedMaximumLength: MAX_PREFERRED_LENGTH,
+		ResumeHandle:          new(uint32),
+	}
+	req.InfoStruct.Level = level
+	req.InfoStruct.ShareInfo.Level = level
+	// 请求中的容器不带数据
+	switch level {
+	case 0:
+		req.InfoStruct.ShareInfo.Level0 = &shareInfo0Container{}
+	case 1:
+		req.InfoStruct.ShareInfo.Level1 = &shareInfo1Container{}
+	case 2:
+		req.InfoStruct.ShareInfo.Level2 = &shareInfo2Container{}
+	case 502:
+		req.InfoStruct.ShareInfo.Level502 = &shareInfo502Container{}
+	default:
+		return nil, errors.New("Unsupported NetrShareEnum level")
+	}
+	return &req, nil
+}
+
+// 转换NetrShareEnum响应
+func (res *netrShareEnumResponse) result() ([]ShareInfo, error) {
+	if res.ReturnCode != 0 {
+		return nil, rpcStatusError("NetrShareEnum", res.ReturnCode)
+	}
+	var shares []ShareInfo
+	info := res.InfoStruct.ShareInfo
+	switch {
+	case info.Level0 != nil:
+		for _, s := range info.Level0.Buffer {
+			shares = append(shares, ShareInfo{Name: s.Name})
+		}
+	case info.Level1 != nil:
+		for _, s := range info.Level1.Buffer {
+			shares = append(shares, ShareInfo{Name: s.Name, Type: s.Type, Remark: s.Remark})
+		}
+	case info.Level2 != nil:
+		for _, s := range info.Level2.Buffer {
+			shares = append(shares, ShareInfo{
+				Name:        s.Name,
+				Type:        s.Type,
+				Remark:      s.Remark,
+				Permissions: s.Permissions,
+				MaxUses:     s.MaxUses,
+				CurrentUses: s.CurrentUses,
+				Path:        s.Path,
+				Password:    s.Password,
+			})
+		}
+	case info.Level502 != nil:
+		for _, s := range info.Level502.Buffer {
+			shares = append(shares, ShareInfo{
+				Name:               s.Name,
+				Type:               s.Type,
+				Remark:             s.Remark,
+				Permissions:        s.Permissions,
+				MaxUses:            s.MaxUses,
+				CurrentUses:        s.CurrentUses,
+				Path:               s.Path,
+				Password:           s.Password,
+				SecurityDescriptor: s.SecurityDescriptor,
+			})
+		}
+	}
+	return shares, nil
+}
+
+// 枚举共享，level支持0、1、2、502
+func (r *RPCClient) NetrShareEnum(level uint32) ([]ShareInfo, error) {
+	req, err := newNetrShareEnumRequest(level)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Sending NetrShareEnum request", nil)
+	var res netrShareEnumResponse
+	err = r.Request(NetrShareEnum, req, &res)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	shares, err := res.result()
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Completed NetrShareEnum", nil)
+	return shares, nil
+}
+
+// smb->通过srvsvc管道调用NetrShareEnum
+func (c *SMBClient) NetrShareEnum(level uint32) ([]ShareInfo, error) {
+	rpc, err := c.OpenPipe("srvsvc", ms.SRVSVC_UUID, ms.SRVSVC_VERSION)
+	if err != nil {
+		return nil, err
+	}
+	defer rpc.Close()
+	return rpc.NetrShareEnum(level)
+}
+
+// 会话信息，字段是否有值取决于查询级别
+// 0：ClientName
+// 10：ClientName、UserName、Time、IdleTime
+// 502：10级别的全部字段以及NumOpens、UserFlags、ClientType、Transport
+type SessionInfo struct {
+	ClientName string
+	UserName   string
+	NumOpens   uint32
+	Time       uint32 // 会话已建立的秒数
+	IdleTime   uint32 // 会话空闲的秒数
+	UserFlags  uint32
+	ClientType string
+	Transport  string
+}
+
+// MS-SRVS SESSION_INFO_0/SESSION_INFO_10/SESSION_INFO_502
+type sessionInfo0 struct {
+	ClientName string
+}
+
+type sessionInfo10 struct {
+	ClientName string
+	UserName   string
+	Time       uint32
+	IdleTime   uint32
+}
+
+type sessionInfo502 struct {
+	ClientName string
+	UserName   string
+	NumOpens   uint32
+	Time       uint32
+	IdleTime   uint32
+	UserFlags  uint32
+	ClientType string
+	Transport  string
+}
+
+type sessionInfo0Container struct {
+	EntriesRead uint32
+	Buffer      []sessionInfo0
+}
+
+type sessionInfo10Container struct {
+	EntriesRead uint32
+	Buffer      []sessionInfo10
+}
+
+type sessionInfo502Container struct {
+	EntriesRead uint32
+	Buffer      []sessionInfo502
+}
+
+// SESSION_ENUM_STRUCT
+type sessionEnumStruct struct {
+	Level       uint32
+	SessionInfo struct {
+		Level    uint32                   `ndr:"switch"`
+		Level0   *sessionInfo0Container   `ndr:"case:0"`
+		Level10  *sessionInfo10Container  `ndr:"case:10"`
+		Level502 *sessionInfo502Container `ndr:"case:502"`
+	}
+}
+
+// NetrSessionEnum请求，clientName、userName为空时不过滤
+// NET_API_STATUS NetrSessionEnum(
+//
+//	[in, string, unique] SRVSVC_HANDLE ServerName,
+//	[in, string, unique] WCHAR* ClientName,
+//	[in, string, unique] WCHAR* UserName,
+//	[in, out] PSESSION_ENUM_STRUCT InfoStruct,
+//	[in] DWORD PreferedMaximumLength,
+//	[out] DWORD* TotalEntries,
+//	[in, out, unique] DWORD* ResumeHandle
+//	);
+type netrSessionEnumRequest struct {
+	ServerName            string
+	ClientName            string
+	UserName              string
+	InfoStruct            sessionEnumStruct
+	PreferedMaximumLength uint32
+	ResumeHandle          *uint32
+}
+
+type netrSessionEnumResponse struct {
+	InfoStruct   sessionEnumStruct
+	TotalEntries uint32
+	ResumeHandle *uint32
+	ReturnCode   uint32
+}
+
+func newNetrSessionEnumRequest(clientName, userName string, level uint32) (*netrSessionEnumRequest, error) {
+	req := netrSessionEnumRequest{
+		ClientName:            clientName,
+		UserName:              userName,
+		PreferedMaximumLength: MAX_PREFERRED_LENGTH,
+		ResumeHandle:          new(uint32),
+	}
+	req.InfoStruct.Level = level
+	req.InfoStruct.SessionInfo.Level = level
+	switch level {
+	case 0:
+		req.InfoStruct.SessionInfo.Level0 = &sessionInfo0Container{}
+	case 10:
+		req.InfoStruct.SessionInfo.Level10 = &sessionInfo10Container{}
+	case 502:
+		req.InfoStruct.SessionInfo.Level502 = &sessionInfo502Container{}
+	default:
+		return nil, errors.New("Unsupported NetrSessionEnum level")
+	}
+	return &req, nil
+}
+
+// 转换NetrSessionEnum响应
+func (res *netrSessionEnumResponse) result() ([]SessionInfo, error) {
+	if res.ReturnCode != 0 {
+		return nil, rpcStatusError("NetrSessionEnum", res.ReturnCode)
+	}
+	var sessions []SessionInfo
+	info := res.InfoStruct.SessionInfo
+	switch {
+	case info.Level0 != nil:
+		for _, s := range info.Level0.Buffer {
+			sessions = append(sessions, SessionInfo{ClientName: s.ClientName})
+		}
+	case info.Level10 != nil:
+		for _, s := range info.Level10.Buffer {
+			sessions = append(sessions, SessionInfo{
+				ClientName: s.ClientName,
+				UserName:   s.UserName,
+				Time:       s.Time,
+				IdleTime:   s.IdleTime,
+			})
+		}
+	case info.Level502 != nil:
+		for _, s := range info.Level502.Buffer {
+			sessions = append(sessions, SessionInfo(s))
+		}
+	}
+	return sessions, nil
+}
+
+// 枚举会话，level支持0、10、502，502级别需要管理员权限
+func (r *RPCClient) NetrSessionEnum(clientName, userName string, level uint32) ([]SessionInfo, error) {
+	req, err := newNetrSessionEnumRequest(clientName, userName, level)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Sending NetrSessionEnum request", nil)
+	var res netrSessionEnumResponse
+	err = r.Request(NetrSessionEnum, req, &res)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	sessions, err := res.result()
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Completed NetrSessionEnum", nil)
+	return sessions, nil
+}
+
+// smb->通过srvsvc管道调用NetrSessionEnum
+func (c *SMBClient) NetrSessionEnum(clientName, userName string, level uint32) ([]SessionInfo, error) {
+	rpc, err := c.OpenPipe("srvsvc", ms.SRVSVC_UUID, ms.SRVSVC_VERSION)
+	if err != nil {
+		return nil, err
+	}
+	defer rpc.Close()
+	return rpc.NetrSessionEnum(clientName, userName, level)
+}
+
+// 连接信息，字段是否有值取决于查询级别
+// 0：Id
+// 1：全部字段
+// MS-SRVS CONNECTION_INFO_0/CONNECTION_INFO_1
+type ConnectionInfo struct {
+	Id       uint32
+	Type     uint32
+	NumOpens uint32
+	NumUsers uint32
+	Time     uint32 // 连接已建立的秒数
+	UserName string
+	NetName  string // qualifier为共享名时为客户端名称，为客户端名称时为共享名
+}
+
+type connectionInfo0 struct {
+	Id uint32
+}
+
+type connectionInfo0Container struct {
+	EntriesRead uint32
+	Buffer      []connectionInfo0
+}
+
+type connectionInfo1Container struct {
+	EntriesRead uint32
+	Buffer      []ConnectionInfo // 与CONNECTION_INFO_1结构相同
+}
+
+// CONNECT_ENUM_STRUCT
+type connectEnumStruct struct {
+	Level       uint32
+	ConnectInfo struct {
+		Level  uint32                    `ndr:"switch"`
+		Level0 *connectionInfo0Container `ndr:"case:0"`
+		Level1 *connectionInfo1Container `ndr:"case:1"`
+	}
+}
+
+// NetrConnectionEnum请求，qualifier为共享名或者\\客户端名称
+// NET_API_STATUS NetrConnectionEnum(
+//
+//	[in, string, unique] SRVSVC_HANDLE ServerName,
+//	[in, string, unique] WCHAR* Qualifier,
+//	[in, out] LPCONNECT_ENUM_STRUCT InfoStruct,
+//	[in] DWORD PreferedMaximumLength,
+//	[out] DWORD* TotalEntries,
+//	[in, out, unique] DWORD* ResumeHandle
+//	);
+type netrConnectionEnumRequest struct {
+	ServerName            string
+	Qualifier             string
+	InfoStruct            connectEnumStruct
+	PreferedMaximumLength uint32
+	ResumeHandle          *uint32
+}
+
+type netrConnectionEnumResponse struct {
+	InfoStruct   connectEnumStruct
+	TotalEntries uint32
+	ResumeHandle *uint32
+	ReturnCode   uint32
+}
+
+func newNetrConnectionEnumRequest(qualifier string, level uint32) (*netrConnectionEnumRequest, error) {
+	req := netrConnectionEnumRequest{
+		Qualifier:             qualifier,
+		PreferedMaximumLength: MAX_PREFERRED_LENGTH,
+		ResumeHandle:          new(uint32),
+	}
+	req.InfoStruct.Level = level
+	req.InfoStruct.ConnectInfo.Level = level
+	switch level {
+	case 0:
+		req.InfoStruct.ConnectInfo.Level0 = &connectionInfo0Container{}
+	case 1:
+		req.InfoStruct.ConnectInfo.Level1 = &connectionInfo1Container{}
+	default:
+		return nil, errors.New("Unsupported NetrConnectionEnum level")
+	}
+	return &req, nil
+}
+
+// 转换NetrConnectionEnum响应
+func (res *netrConnectionEnumResponse) result() ([]ConnectionInfo, error) {
+	if res.ReturnCode != 0 {
+		return nil, rpcStatusError("NetrConnectionEnum", res.ReturnCode)
+	}
+	var conns []ConnectionInfo
+	info := res.InfoStruct.ConnectInfo
+	switch {
+	case info.Level0 != nil:
+		for _, conn := range info.Level0.Buffer {
+			conns = append(conns, ConnectionInfo{Id: conn.Id})
+		}
+	case info.Level1 != nil:
+		conns = info.Level1.Buffer
+	}
+	return conns, nil
+}
+
+// 枚举连接到共享或者来自客户端的连接，level支持0、1
+func (r *RPCClient) NetrConnectionEnum(qualifier string, level uint32) ([]ConnectionInfo, error) {
+	req, err := newNetrConnectionEnumRequest(qualifier, level)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Sending NetrConnectionEnum request", nil)
+	var res netrConnectionEnumResponse
+	err = r.Request(NetrConnectionEnum, req, &res)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	conns, err := res.result()
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Completed NetrConnectionEnum", nil)
+	return conns, nil
+}
+
+// smb->通过srvsvc管道调用NetrConnectionEnum
+func (c *SMBClient) NetrConnectionEnum(qualifier string, level uint32) ([]ConnectionInfo, error) {
+	rpc, err := c.OpenPipe("srvsvc", ms.SRVSVC_UUID, ms.SRVSVC_VERSION)
+	if err != nil {
+		return nil, err
+	}
+	defer rpc.Close()
+	return rpc.NetrConnectionEnum(qualifier, level)
+}
+
+// 打开的文件信息，字段是否有值取决于查询级别
+// 2：Id
+// 3：全部字段
+// MS-SRVS FILE_INFO_2/FILE_INFO_3
+type OpenFileInfo struct {
+	Id          uint32
+	Permissions uint32
+	NumLocks    uint32
+	PathName    string
+	UserName    string
+}
+
+type fileInfo2 struct {
+	Id uint32
+}
+
+type fileInfo2Container struct {
+	EntriesRead uint32
+	Buffer      []fileInfo2
+}
+
+type fileInfo3Container struct {
+	EntriesRead uint32
+	Buffer      []OpenFileInfo // 与FILE_INFO_3结构相同
+}
+
+// FILE_ENUM_STRUCT
+type fileEnumStruct struct {
+	Level    uint32
+	FileInfo struct {
+		Level  uint32              `ndr:"switch"`
+		Level2 *fileInfo2Container `ndr:"case:2"`
+		Level3 *fileInfo3Container `ndr:"case:3"`
+	}
+}
+
+// NetrFileEnum请求，basePath、userName为空时不过滤
+// NET_API_STATUS NetrFileEnum(
+//
+//	[in, string, unique] SRVSVC_HANDLE ServerName,
+//	[in, string, unique] WCHAR* BasePath,
+//	[in, string, unique] WCHAR* UserName,
+//	[in, out] PFILE_ENUM_STRUCT InfoStruct,
+//	[in] DWORD PreferedMaximumLength,
+//	[out] DWORD* TotalEntries,
+//	[in, out, unique] DWORD* ResumeHandle
+//	);
+type netrFileEnumRequest struct {
+	ServerName            string
+	BasePath              string
+	UserName              string
+	InfoStruct            fileEnumStruct
+	PreferedMaximumLength uint32
+	ResumeHandle          *uint32
+}
+
+type netrFileEnumResponse struct {
+	InfoStruct   fileEnumStruct
+	TotalEntries uint32
+	ResumeHandle *uint32
+	ReturnCode   uint32
+}
+
+func newNetrFileEnumRequest(basePath, userName string, level uint32) (*netrFileEnumRequest, error) {
+	req := netrFileEnumRequest{
+		BasePath:              basePath,
+		UserName:              userName,
+		PreferedMaximumLength: MAX_PREFERRED_LENGTH,
+		ResumeHandle:          new(uint32),
+	}
+	req.InfoStruct.Level = level
+	req.InfoStruct.FileInfo.Level = level
+	switch level {
+	case 2:
+		req.InfoStruct.FileInfo.Level2 = &fileInfo2Container{}
+	case 3:
+		req.InfoStruct.FileInfo.Level3 = &fileInfo3Container{}
+	default:
+		return nil, errors.New("Unsupported NetrFileEnum level")
+	}
+	return &req, nil
+}
+
+// 转换NetrFileEnum响应
+func (res *netrFileEnumResponse) result() ([]OpenFileInfo, error) {
+	if res.ReturnCode != 0 {
+		return nil, rpcStatusError("NetrFileEnum", res.ReturnCode)
+	}
+	var files []OpenFileInfo
+	info := res.InfoStruct.FileInfo
+	switch {
+	case info.Level2 != nil:
+		for _, f := range info.Level2.Buffer {
+			files = append(files, OpenFileInfo{Id: f.Id})
+		}
+	case info.Level3 != nil:
+		files = info.Level3.Buffer
+	}
+	return files, nil
+}
+
+// 枚举打开的文件，level支持2、3，需要管理员权限
+func (r *RPCClient) NetrFileEnum(basePath, userName string, level uint32) ([]OpenFileInfo, error) {
+	req, err := newNetrFileEnumRequest(basePath, userName, level)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Sending NetrFileEnum request", nil)
+	var res netrFileEnumResponse
+	err = r.Request(NetrFileEnum, req, &res)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	files, err := res.result()
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Completed NetrFileEnum", nil)
+	return files, nil
+}
+
+// smb->通过srvsvc管道调用NetrFileEnum
+func (c *SMBClient) NetrFileEnum(basePath, userName string, level uint32) ([]OpenFileInfo, error) {
+	rpc, err := c.OpenPipe("srvsvc", ms.SRVSVC_UUID, ms.SRVSVC_VERSION)
+	if err != nil {
+		return nil, err
+	}
+	defer rpc.Close()
+	return rpc.NetrFileEnum(basePath, userName, level)
+}
+
+// MS-SRVS 2.2.2.7 Software Type Flags
+// 服务器类型
+const (
+	SV_TYPE_WORKSTATION    = 0x00000001
+	SV_TYPE_SERVER         = 0x00000002
+	SV_TYPE_SQLSERVER      = 0x00000004
+	SV_TYPE_DOMAIN_CTRL    = 0x00000008
+	SV_TYPE_DOMAIN_BAKCTRL = 0x00000010
+	SV_TYPE_TIME_SOURCE    = 0x00000020
+	SV_TYPE_PRINTQ_SERVER  = 0x00000200
+	SV_TYPE_SERVER_NT      = 0x00008000
+	SV_TYPE_MASTER_BROWSER = 0x00040000
+	SV_TYPE_DOMAIN_MASTER  = 0x00080000
+	SV_TYPE_WINDOWS        = 0x00400000
+	SV_TYPE_TERMINALSERVER = 0x02000000
+	SV_TYPE_CLUSTER_VS_NT  = 0x04000000
+)
+
+// 平台类型
+const (
+	PLATFORM_ID_DOS = 300
+	PLATFORM_ID_OS2 = 400
+	PLATFORM_ID_NT  = 500
+	PLATFORM_ID_OSF = 600
+	PLATFORM_ID_VMS = 700
+)
+
+// 服务器信息，字段是否有值取决于查询级别
+// 101：PlatformId、Name、VersionMajor、VersionMinor、Type、Comment
+// 102：101级别的全部字段以及Users、Disc、Hidden、Announce、AnnDelta、Licenses、UserPath
+type ServerInfo struct {
+	PlatformId   uint32
+	Name         string
+	VersionMajor uint32
+	VersionMinor uint32
+	Type         uint32
+	Comment      string
+	Users        uint32
+	Disc         int32
+	Hidden       uint32
+	Announce     uint32
+	AnnDelta     uint32
+	Licenses     uint32
+	UserPath     string
+}
+
+// 是否为域控
+func (s *ServerInfo) IsDomainController() bool {
+	return s.Type&(SV_TYPE_DOMAIN_CTRL|SV_TYPE_DOMAIN_BAKCTRL) != 0
+}
+
+// MS-SRVS SERVER_INFO_101
+type serverInfo101 struct {
+	PlatformId   uint32
+	Name         string
+	VersionMajor uint32
+	VersionMinor uint32
+	Type         uint32
+	Comment      string
+}
+
+// NetrServerGetInfo请求
+// NET_API_STATUS NetrServerGetInfo(
+//
+//	[in, string, unique] SRVSVC_HANDLE ServerName,
+//	[in] DWORD Level,
+//	[out, switch_is(Level)] LPSERVER_INFO InfoStruct
+//	);
+type netrServerGetInfoRequest struct {
+	ServerName string
+	Level      uint32
+}
+
+type netrServerGetInfoResponse struct {
+	InfoStruct struct {
+		Level   uint32         `ndr:"switch"`
+		Info101 *serverInfo101 `ndr:"case:101"`
+		Info102 *ServerInfo    `ndr:"case:102"` // 与SERVER_INFO_102结构相同
+	}
+	ReturnCode uint32
+}
+
+func newNetrServerGetInfoRequest(level uint32) (*netrServerGetInfoRequest, error) {
+	if level != 101 && level != 102 {
+		return nil, errors.New("Unsupported NetrServerGetInfo level")
+	}
+	return &netrServerGetInfoRequest{Level: level}, nil
+}
+
+// 转换NetrServerGetInfo响应
+func (res *netrServerGetInfoResponse) result() (*ServerInfo, error) {
+	if res.ReturnCode != 0 {
+		return nil, rpcStatusError("NetrServerGetInfo", res.ReturnCode)
+	}
+	switch {
+	case res.InfoStruct.Info101 != nil:
+		info := ServerInfo{}
+		info.PlatformId = res.InfoStruct.Info101.PlatformId
+		info.Name = res.InfoStruct.Info101.Name
+		info.VersionMajor = res.InfoStruct.Info101.VersionMajor
+		info.VersionMinor = res.InfoStruct.Info101.VersionMinor
+		info.Type = res.InfoStruct.Info101.Type
+		info.Comment = res.InfoStruct.Info101.Comment
+		return &info, nil
+	case res.InfoStruct.Info102 != nil:
+		return res.InfoStruct.Info102, nil
+	}
+	return &ServerInfo{}, nil
+}
+
+// 查询服务器信息，level支持101、102
+func (r *RPCClient) NetrServerGetInfo(level uint32) (*ServerInfo, error) {
+	req, err := newNetrServerGetInfoRequest(level)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Sending NetrServerGetInfo request", nil)
+	var res netrServerGetInfoResponse
+	err = r.Request(NetrServerGetInfo, req, &res)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	info, err := res.result()
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Completed NetrServerGetInfo", nil)
+	return info, nil
+}
+
+// smb->通过srvsvc管道调用NetrServerGetInfo
+func (c *SMBClient) NetrServerGetInfo(level uint32) (*ServerInfo, error) {
+	rpc, err := c.OpenPipe("srvsvc", ms.SRVSVC_UUID, ms.SRVSVC_VERSION)
+	if err != nil {
+		return nil, err
+	}
+	defer rpc.Close()
+	return rpc.NetrServerGetInfo(level)
+}
@@ -0,0 +1,129 @@
+package v5
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc/ndr"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestNetrShareEnumRequest(t *testing.T) {
+	req, err := newNetrShareEnumRequest(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ndr.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := unhex(t, "00000000 01000000 01000000 04000200 00000000 00000000 ffffffff 08000200 00000000")
+	if !bytes.Equal(buf, want) {
+		t.Errorf("NetrShareEnum request =\n%x\nwant\n%x", buf, want)
+	}
+}
+
+// 两个共享的level 1响应
+const netrShareEnumLevel1Response = "01000000 01000000 04000200" +
+	"02000000 08000200" +
+	"02000000" +
+	"0c000200 00000080 10000200" +
+	"14000200 03000080 18000200" +
+	"07000000 00000000 07000000 41004400 4d004900 4e002400 0000 0000" + // ADMIN$
+	"0d000000 00000000 0d000000 52006500 6d006f00 74006500 20004100 64006d00 69006e00 0000 0000" + // Remote Admin
+	"05000000 00000000 05000000 49005000 43002400 0000 0000" + // IPC$
+	"0b000000 00000000 0b000000 52006500 6d006f00 74006500 20004900 50004300 0000 0000" + // Remote IPC
+	"02000000" +
+	"1c000200 00000000" +
+	"00000000"
+
+func TestNetrShareEnumResponse(t *testing.T) {
+	buf := unhex(t, netrShareEnumLevel1Response)
+	var res netrShareEnumResponse
+	if err := ndr.Unmarshal(buf, &res); err != nil {
+		t.Fatal(err)
+	}
+	shares, err := res.result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ShareInfo{
+		{Name: "ADMIN$", Type: STYPE_SPECIAL | STYPE_DISKTREE, Remark: "Remote Admin"},
+		{Name: "IPC$", Type: STYPE_SPECIAL | STYPE_IPC, Remark: "Remote IPC"},
+	}
+	if !reflect.DeepEqual(shares, want) {
+		t.Errorf("shares = %+v, want %+v", shares, want)
+	}
+	if res.TotalEntries != 2 || res.ResumeHandle == nil {
+		t.Errorf("TotalEntries = %d, ResumeHandle = %v", res.TotalEntries, res.ResumeHandle)
+	}
+	// 服务端编码结果与原数据一致
+	out, err := ndr.Marshal(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf) {
+		t.Errorf("NetrShareEnum response =\n%x\nwant\n%x", out, buf)
+	}
+}
+
+func TestNetrShareEnumError(t *testing.T) {
+	res := netrShareEnumResponse{ReturnCode: 5}
+	if _, err := res.result(); err == nil {
+		t.Error("ERROR_ACCESS_DENIED not returned")
+	}
+}
+
+// NDR64下SHARE_ENUM_STRUCT以及容器按8字节对齐
+func TestNetrShareEnumRequestNDR64(t *testing.T) {
+	req, err := newNetrShareEnumRequest(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ndr.MarshalWithSyntax(req, ndr.NDR64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := unhex(t, "0000000000000000"+
+		"01000000 00000000 01000000 00000000 0400020000000000"+
+		"00000000 00000000 0000000000000000"+
+		"ffffffff 00000000 0800020000000000 00000000")
+	if !bytes.Equal(buf, want) {
+		t.Errorf("NetrShareEnum request =\n%x\nwant\n%x", buf, want)
+	}
+}
+
+// 响应数据不完整时返回错误
+func TestNetrShareEnumResponseTruncated(t *testing.T) {
+	buf := unhex(t, netrShareEnumLevel1Response)
+	var res netrShareEnumResponse
+	if err := ndr.Unmarshal(buf[:len(buf)-20], &res); err == nil {
+		t.Error("truncated response unmarshalled")
+	}
+}
+
+// NetrShareEnum通过RPCClient发送请求并使用ndr解码响应
+func TestRPCClientNetrShareEnum(t *testing.T) {
+	transport := &fakeTransport{responses: [][]byte{testPDU(PDUResponse, unhex(t, netrShareEnumLevel1Response))}}
+	shares, err := NewRPCClient(transport, false).NetrShareEnum(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 2 || shares[1].Name != "IPC$" {
+		t.Errorf("shares = %+v", shares)
+	}
+	want := unhex(t, "00000000 01000000 01000000 04000200 00000000 00000000 ffffffff 08000200 00000000")
+	if stub := transport.sent[0][MSRPCRequestHeaderSize:]; !bytes.Equal(stub, want) {
+		t.Errorf("NetrShareEnum request =\n%x\nwant\n%x", stub, want)
+	}
+}
@@ -0,0 +1,212 @@
+package v5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"github.com/Amzza0x00/go-impacket/pkg/util"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// 此文件提供协议塔（protocol tower）解析
+// C706 Appendix L Protocol Tower Encoding
+
+// 协议塔floor的协议标识
+const (
+	EPM_PROTOCOL_DNET_NSP   = 0x04
+	EPM_PROTOCOL_OSI_TP4    = 0x05
+	EPM_PROTOCOL_OSI_CLNS   = 0x06
+	EPM_PROTOCOL_TCP        = 0x07
+	EPM_PROTOCOL_UDP        = 0x08
+	EPM_PROTOCOL_IP         = 0x09
+	EPM_PROTOCOL_NCADG      = 0x0a // 无连接rpc
+	EPM_PROTOCOL_NCACN      = 0x0b // 面向连接rpc
+	EPM_PROTOCOL_NCALRPC    = 0x0c // 本地rpc
+	EPM_PROTOCOL_UUID       = 0x0d
+	EPM_PROTOCOL_SMB        = 0x0f // 命名管道
+	EPM_PROTOCOL_NAMED_PIPE = 0x10 // 本地rpc端点
+	EPM_PROTOCOL_NETBIOS    = 0x11
+	EPM_PROTOCOL_NETBEUI    = 0x12
+	EPM_PROTOCOL_SPX        = 0x13
+	EPM_PROTOCOL_NB_IPX     = 0x14
+	EPM_PROTOCOL_HTTP       = 0x1f
+	EPM_PROTOCOL_UNIX_DS    = 0x20
+	EPM_PROTOCOL_NULL       = 0x21
+)
+
+// 协议塔中的一层，Lhs第一个字节为协议标识
+type TowerFloor struct {
+	Lhs []byte
+	Rhs []byte
+}
+
+// 协议标识
+func (f TowerFloor) Protocol() uint8 {
+	if len(f.Lhs) == 0 {
+		return 0
+	}
+	return f.Lhs[0]
+}
+
+// 解析后的协议塔，前两层为接口以及传输语法，之后为协议、端点以及地址
+type Tower struct {
+	InterfaceUUID   string
+	VersionMajor    uint16
+	VersionMinor    uint16
+	TransferSyntax  string
+	TransferVersion uint16
+	Binding         StringBinding
+	Floors          []TowerFloor
+}
+
+// 解析tower_octet_string
+func ParseTower(buf []byte) (*Tower, error) {
+	if len(buf) < 2 {
+		return nil, errors.New("Invalid protocol tower")
+	}
+	count := int(binary.LittleEndian.Uint16(buf))
+	off := 2
+	t := &Tower{}
+	for i := 0; i < count; i++ {
+		var floor TowerFloor
+		for _, side := range []*[]byte{&floor.Lhs, &floor.Rhs} {
+			if off+2 > len(buf) {
+				return nil, errors.New("Invalid protocol tower")
+			}
+			n := int(binary.LittleEndian.Uint16(buf[off:]))
+			off += 2
+			if off+n > len(buf) {
+				return nil, errors.New("Invalid protocol tower")
+			}
+			*side = buf[off : off+n]
+			off += n
+		}
+		t.Floors = append(t.Floors, floor)
+	}
+	if len(t.Floors) < 3 {
+		return nil, errors.New("Invalid protocol tower, too few floors")
+	}
+	var err error
+	if t.InterfaceUUID, t.VersionMajor, t.VersionMinor, err = uuidFloor(t.Floors[0]); err != nil {
+		return nil, err
+	}
+	if t.TransferSyntax, t.TransferVersion, _, err = uuidFloor(t.Floors[1]); err != nil {
+		return nil, err
+	}
+	t.Binding = towerBinding(t.Floors[2:])
+	return t, nil
+}
+
+// 接口以及传输语法层：Lhs为协议标识+uuid+主版本号，Rhs为次版本号
+func uuidFloor(f TowerFloor) (uuid string, major, minor uint16, err error) {
+	if f.Protocol() != EPM_PROTOCOL_UUID || len(f.Lhs) < 19 {
+		return "", 0, 0, errors.New("Invalid protocol tower uuid floor")
+	}
+	uuid = util.PDUUuidToString(f.Lhs[1:17])
+	major = binary.LittleEndian.Uint16(f.Lhs[17:])
+	if len(f.Rhs) >= 2 {
+		minor = binary.LittleEndian.Uint16(f.Rhs)
+	}
+	return uuid, major, minor, nil
+}
+
+// 根据协议层、端点层以及地址层生成字符串绑定
+func towerBinding(floors []TowerFloor) StringBinding {
+	var b StringBinding
+	var rpc uint8
+	for _, f := range floors {
+		switch f.Protocol() {
+		case EPM_PROTOCOL_NCACN, EPM_PROTOCOL_NCADG, EPM_PROTOCOL_NCALRPC:
+			rpc = f.Protocol()
+			if rpc == EPM_PROTOCOL_NCALRPC {
+				b.ProtocolSequence = ProtSeqLRPC
+			}
+		case EPM_PROTOCOL_TCP:
+			b.ProtocolSequence = ProtSeqTCP
+			b.Endpoint = towerPort(f.Rhs)
+		case EPM_PROTOCOL_UDP:
+			b.ProtocolSequence = ProtSeqUDP
+			b.Endpoint = towerPort(f.Rhs)
+		case EPM_PROTOCOL_HTTP:
+			b.ProtocolSequence = ProtSeqHTTP
+			b.Endpoint = towerPort(f.Rhs)
+		case EPM_PROTOCOL_SMB:
+			b.ProtocolSequence = ProtSeqNp
+			b.Endpoint = towerString(f.Rhs)
+		case EPM_PROTOCOL_NAMED_PIPE:
+			b.ProtocolSequence = ProtSeqLRPC
+			b.Endpoint = towerString(f.Rhs)
+		case EPM_PROTOCOL_IP:
+			if len(f.Rhs) == net.IPv4len {
+				b.NetworkAddress = net.IP(f.Rhs).String()
+			}
+		case EPM_PROTOCOL_NETBIOS:
+			b.NetworkAddress = towerString(f.Rhs)
+		}
+	}
+	if b.ProtocolSequence == "" {
+		b.ProtocolSequence = fmt.Sprintf("unknown_0x%02x", rpc)
+	}
+	return b
+}
+
+// 端口为大端序
+func towerPort(rhs []byte) string {
+	if len(rhs) < 2 {
+		return ""
+	}
+	return strconv.Itoa(int(binary.BigEndian.Uint16(rhs)))
+}
+
+func towerString(rhs []byte) string {
+	return strings.TrimRight(string(rhs), "\x00")
+}
+
+// 生成用于ept_map查询的协议塔，传输语法为NDR，端口以及地址为空
+// version与SyntaxIDStruct.Version相同，低16位为主版本号，高16位为次版本号
+func NewTower(uuid string, version uint32, protocolSequence string) ([]byte, error) {
+	floors := []TowerFloor{
+		interfaceFloor(uuid, uint16(version), uint16(version>>16)),
+		interfaceFloor(ms.NDR_UUID, ms.NDR_VERSION, 0),
+	}
+	// 地址以及端点为空，由服务端填充
+	anyPort := []byte{0, 0}
+	anyAddr := []byte{0, 0, 0, 0}
+	switch protocolSequence {
+	case ProtSeqTCP:
+		floors = append(floors, rpcFloor(EPM_PROTOCOL_NCACN), TowerFloor{[]byte{EPM_PROTOCOL_TCP}, anyPort}, TowerFloor{[]byte{EPM_PROTOCOL_IP}, anyAddr})
+	case ProtSeqUDP:
+		floors = append(floors, rpcFloor(EPM_PROTOCOL_NCADG), TowerFloor{[]byte{EPM_PROTOCOL_UDP}, anyPort}, TowerFloor{[]byte{EPM_PROTOCOL_IP}, anyAddr})
+	case ProtSeqHTTP:
+		floors = append(floors, rpcFloor(EPM_PROTOCOL_NCACN), TowerFloor{[]byte{EPM_PROTOCOL_HTTP}, anyPort}, TowerFloor{[]byte{EPM_PROTOCOL_IP}, anyAddr})
+	case ProtSeqNp:
+		floors = append(floors, rpcFloor(EPM_PROTOCOL_NCACN), TowerFloor{[]byte{EPM_PROTOCOL_SMB}, []byte{0}}, TowerFloor{[]byte{EPM_PROTOCOL_NETBIOS}, []byte{0}})
+	case ProtSeqLRPC:
+		floors = append(floors, rpcFloor(EPM_PROTOCOL_NCALRPC), TowerFloor{[]byte{EPM_PROTOCOL_NAMED_PIPE}, []byte{0}})
+	default:
+		return nil, errors.New("Unsupported protocol sequence " + protocolSequence)
+	}
+	buf := make([]byte, 2, 128)
+	binary.LittleEndian.PutUint16(buf, uint16(len(floors)))
+	for _, f := range floors {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(f.Lhs)))
+		buf = append(buf, f.Lhs...)
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(f.Rhs)))
+		buf = append(buf, f.Rhs...)
+	}
+	return buf, nil
+}
+
+func interfaceFloor(uuid string, major, minor uint16) TowerFloor {
+	lhs := append([]byte{EPM_PROTOCOL_UUID}, util.PDUUuidFromBytes(uuid)...)
+	lhs = binary.LittleEndian.AppendUint16(lhs, major)
+	return TowerFloor{Lhs: lhs, Rhs: binary.LittleEndian.AppendUint16(nil, minor)}
+}
+
+// rpc协议层，Rhs为次版本号
+func rpcFloor(protocol uint8) TowerFloor {
+	return TowerFloor{Lhs: []byte{protocol}, Rhs: []byte{0, 0}}
+}
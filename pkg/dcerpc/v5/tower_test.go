@@ -0,0 +1,128 @@
+package v5
+
+import (
+	"encoding/binary"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"reflect"
+	"testing"
+)
+
+// 编码tower_octet_string
+func testTower(floors ...TowerFloor) []byte {
+	buf := binary.LittleEndian.AppendUint16(nil, uint16(len(floors)))
+	for _, f := range floors {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(f.Lhs)))
+		buf = append(buf, f.Lhs...)
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(f.Rhs)))
+		buf = append(buf, f.Rhs...)
+	}
+	return buf
+}
+
+// 接口以及传输语法层
+func testTowerFloors() []TowerFloor {
+	return []TowerFloor{
+		interfaceFloor(ms.SRVSVC_UUID, 3, 0),
+		interfaceFloor(ms.NDR_UUID, 2, 0),
+	}
+}
+
+func TestParseTower(t *testing.T) {
+	tests := []struct {
+		name   string
+		floors []TowerFloor
+		want   StringBinding
+	}{
+		{"tcp", []TowerFloor{
+			{Lhs: []byte{EPM_PROTOCOL_NCACN}, Rhs: []byte{0, 0}},
+			{Lhs: []byte{EPM_PROTOCOL_TCP}, Rhs: []byte{0xc2, 0x03}},
+			{Lhs: []byte{EPM_PROTOCOL_IP}, Rhs: []byte{10, 0, 0, 1}},
+		}, StringBinding{ProtocolSequence: ProtSeqTCP, NetworkAddress: "10.0.0.1", Endpoint: "49667"}},
+		{"named pipe", []TowerFloor{
+			{Lhs: []byte{EPM_PROTOCOL_NCACN}, Rhs: []byte{0, 0}},
+			{Lhs: []byte{EPM_PROTOCOL_SMB}, Rhs: []byte("\\PIPE\\srvsvc\x00")},
+			{Lhs: []byte{EPM_PROTOCOL_NETBIOS}, Rhs: []byte("DC01\x00")},
+		}, StringBinding{ProtocolSequence: ProtSeqNp, NetworkAddress: "DC01", Endpoint: "\\PIPE\\srvsvc"}},
+		{"local rpc", []TowerFloor{
+			{Lhs: []byte{EPM_PROTOCOL_NCALRPC}, Rhs: []byte{0, 0}},
+			{Lhs: []byte{EPM_PROTOCOL_NAMED_PIPE}, Rhs: []byte("LRPC-0123\x00")},
+		}, StringBinding{ProtocolSequence: ProtSeqLRPC, Endpoint: "LRPC-0123"}},
+		{"udp", []TowerFloor{
+			{Lhs: []byte{EPM_PROTOCOL_NCADG}, Rhs: []byte{0, 0}},
+			{Lhs: []byte{EPM_PROTOCOL_UDP}, Rhs: []byte{0x00, 0x87}},
+			{Lhs: []byte{EPM_PROTOCOL_IP}, Rhs: []byte{10, 0, 0, 1}},
+		}, StringBinding{ProtocolSequence: ProtSeqUDP, NetworkAddress: "10.0.0.1", Endpoint: "135"}},
+		{"unknown protocol", []TowerFloor{
+			{Lhs: []byte{EPM_PROTOCOL_NCADG}, Rhs: []byte{0, 0}},
+			{Lhs: []byte{EPM_PROTOCOL_SPX}, Rhs: []byte{0, 0}},
+		}, StringBinding{ProtocolSequence: "unknown_0x0a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tower, err := ParseTower(testTower(append(testTowerFloors(), tt.floors...)...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tower.InterfaceUUID != ms.SRVSVC_UUID || tower.VersionMajor != 3 || tower.VersionMinor != 0 {
+				t.Errorf("interface = %s v%d.%d", tower.InterfaceUUID, tower.VersionMajor, tower.VersionMinor)
+			}
+			if tower.TransferSyntax != ms.NDR_UUID || tower.TransferVersion != 2 {
+				t.Errorf("transfer syntax = %s v%d", tower.TransferSyntax, tower.TransferVersion)
+			}
+			if len(tower.Floors) != 2+len(tt.floors) {
+				t.Errorf("got %d floors, want %d", len(tower.Floors), 2+len(tt.floors))
+			}
+			if !reflect.DeepEqual(tower.Binding, tt.want) {
+				t.Errorf("binding = %+v, want %+v", tower.Binding, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTowerErrors(t *testing.T) {
+	tcp := testTower(append(testTowerFloors(), TowerFloor{Lhs: []byte{EPM_PROTOCOL_NCACN}, Rhs: []byte{0, 0}})...)
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", nil},
+		{"too few floors", testTower(testTowerFloors()...)},
+		{"truncated", tcp[:len(tcp)-1]},
+		{"floor count larger than floors", append([]byte{4, 0}, tcp[2:]...)},
+		{"invalid uuid floor", testTower(
+			TowerFloor{Lhs: []byte{EPM_PROTOCOL_TCP}, Rhs: []byte{0, 135}},
+			interfaceFloor(ms.NDR_UUID, 2, 0),
+			TowerFloor{Lhs: []byte{EPM_PROTOCOL_NCACN}, Rhs: []byte{0, 0}},
+		)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tower, err := ParseTower(tt.buf); err == nil {
+				t.Errorf("ParseTower = %+v, want error", tower)
+			}
+		})
+	}
+}
+
+// NewTower生成的协议塔可以重新解析，端点以及地址为空
+func TestNewTower(t *testing.T) {
+	for _, protocolSequence := range []string{ProtSeqTCP, ProtSeqUDP, ProtSeqHTTP, ProtSeqNp, ProtSeqLRPC} {
+		buf, err := NewTower(ms.SRVSVC_UUID, 3|1<<16, protocolSequence)
+		if err != nil {
+			t.Fatalf("%s: %v", protocolSequence, err)
+		}
+		tower, err := ParseTower(buf)
+		if err != nil {
+			t.Fatalf("%s: %v", protocolSequence, err)
+		}
+		if tower.InterfaceUUID != ms.SRVSVC_UUID || tower.VersionMajor != 3 || tower.VersionMinor != 1 || tower.TransferSyntax != ms.NDR_UUID {
+			t.Errorf("%s: tower = %+v", protocolSequence, tower)
+		}
+		if tower.Binding.ProtocolSequence != protocolSequence || tower.Binding.Endpoint != "" && tower.Binding.Endpoint != "0" {
+			t.Errorf("%s: binding = %+v", protocolSequence, tower.Binding)
+		}
+	}
+	if _, err := NewTower(ms.SRVSVC_UUID, 3, "ncacn_spx"); err == nil {
+		t.Error("unsupported protocol sequence accepted")
+	}
+}
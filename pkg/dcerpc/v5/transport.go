@@ -1,18 +1,22 @@
 package v5
 
 import (
-	"fmt"
+	"errors"
 	"github.com/Amzza0x00/go-impacket/pkg/common"
 	"github.com/Amzza0x00/go-impacket/pkg/smb/smb2"
 	"net"
+	"strconv"
+	"time"
 )
 
+// 共享已登录的smb会话，不能复制smb2.Client
 type SMBClient struct {
-	smb2.Client
+	*smb2.Client
 }
 
 type TCPClient struct {
 	common.Client
+	maxXmitFrag uint16 // 绑定时协商的请求分片大小
 }
 
 // 连接封装
@@ -23,8 +27,13 @@ func SMBTransport() (client *SMBClient, err error) {
 
 // tcp连接封装
 func NewTCPSession(opt common.ClientOptions, debug bool) (client *TCPClient, err error) {
-	address := fmt.Sprintf("%s:%d", opt.Host, opt.Port)
-	conn, err := net.Dial("tcp", address)
+	return NewTCPSessionTimeout(opt, 0, debug)
+}
+
+// tcp连接封装，timeout同时作为连接超时以及读取单个PDU的超时，为0时不限制连接时间，读取使用默认超时
+func NewTCPSessionTimeout(opt common.ClientOptions, timeout time.Duration, debug bool) (client *TCPClient, err error) {
+	address := net.JoinHostPort(opt.Host, strconv.Itoa(opt.Port))
+	conn, err := net.DialTimeout("tcp", address, timeout)
 	if err != nil {
 		return
 	}
@@ -32,6 +41,7 @@ func NewTCPSession(opt common.ClientOptions, debug bool) (client *TCPClient, err
 	client.WithOptions(&opt)
 	client.WithConn(conn)
 	client.WithDebug(debug)
+	client.WithTimeout(timeout)
 	return client, nil
 }
 
@@ -39,3 +49,174 @@ func NewTCPSession(opt common.ClientOptions, debug bool) (client *TCPClient, err
 func TCPTransport() (client *TCPClient, err error) {
 	return &TCPClient{}, nil
 }
+
+// DCERPC传输层，Send发送一个完整的PDU，Recv读取一个完整的PDU
+type Transport interface {
+	Connect() error
+	Send(pdu []byte) error
+	Recv() ([]byte, error)
+	Close() error
+	// 传输层认证得到的会话密钥，没有时返回nil
+	SessionKey() []byte
+}
+
+// ncacn_np，smb命名管道传输
+type NpTransport struct {
+	session    *smb2.Client
+	pipename   string
+	owned      bool // 会话由传输层创建，关闭时一并关闭
+	treeId     uint32
+	fileId     []byte
+	disconnect func()
+}
+
+// 在已登录的smb会话上打开命名管道
+func NewNpTransport(session *smb2.Client, pipename string) *NpTransport {
+	return &NpTransport{session: session, pipename: pipename}
+}
+
+func (t *NpTransport) Connect() (err error) {
+	t.treeId, t.disconnect, err = t.session.ConnectIPC()
+	if err != nil {
+		return err
+	}
+	t.fileId, err = t.session.CreatePipeRequest(t.treeId, t.pipename)
+	if err != nil {
+		t.disconnect()
+		return err
+	}
+	return nil
+}
+
+func (t *NpTransport) Send(pdu []byte) error {
+	return t.session.WritePipeRequest(t.treeId, pdu, t.fileId)
+}
+
+func (t *NpTransport) Recv() ([]byte, error) {
+	return t.session.ReadPipe(t.treeId, t.fileId)
+}
+
+func (t *NpTransport) Close() error {
+	if t.fileId != nil {
+		t.session.CloseRequest(t.treeId, t.fileId)
+		t.disconnect()
+		t.fileId = nil
+	}
+	if t.owned {
+		t.session.Close()
+	}
+	return nil
+}
+
+func (t *NpTransport) SessionKey() []byte {
+	return t.session.GetSessionKey()
+}
+
+// ncacn_ip_tcp以及ncalrpc，基于流的传输，按FragLength读取PDU
+type StreamTransport struct {
+	common.Client
+	network string
+	address string
+	host    string // 未指定端口时绑定接口前通过epmapper解析
+}
+
+// ncacn_ip_tcp
+func NewTCPStreamTransport(host string, port int, debug bool) *StreamTransport {
+	t := &StreamTransport{network: "tcp", address: net.JoinHostPort(host, strconv.Itoa(port))}
+	t.WithDebug(debug)
+	return t
+}
+
+// ncalrpc，非windows系统上使用unix socket，endpoint为socket路径
+func NewLocalStreamTransport(endpoint string, debug bool) *StreamTransport {
+	t := &StreamTransport{network: "unix", address: endpoint}
+	t.WithDebug(debug)
+	return t
+}
+
+func (t *StreamTransport) Connect() error {
+	if t.address == "" {
+		// 等待Resolve解析端口后再连接
+		return nil
+	}
+	conn, err := net.DialTimeout(t.network, t.address, common.DefaultTCPTimeout)
+	if err != nil {
+		return err
+	}
+	t.WithConn(conn)
+	return nil
+}
+
+func (t *StreamTransport) Send(pdu []byte) error {
+	return t.TCPWrite(pdu)
+}
+
+func (t *StreamTransport) Recv() ([]byte, error) {
+	return t.TCPRead()
+}
+
+func (t *StreamTransport) SessionKey() []byte {
+	return nil
+}
+
+// 通过epmapper解析接口所在的动态端口并连接，已经指定端口时不做处理
+func (t *StreamTransport) Resolve(uuid string, version uint32) error {
+	if t.address != "" {
+		return nil
+	}
+	binding, err := ResolveEndpoint(t.host, uuid, version, ProtSeqTCP, t.GetDebug())
+	if err != nil {
+		return err
+	}
+	t.address = net.JoinHostPort(t.host, binding.Endpoint)
+	return t.Connect()
+}
+
+// 需要在绑定前根据接口解析端点的传输层
+type endpointResolver interface {
+	Resolve(uuid string, version uint32) error
+}
+
+// 根据字符串绑定创建传输层，ncacn_np使用opt登录smb，未指定端口时使用445
+// ncacn_ip_tcp未指定端口时，在绑定接口时通过epmapper解析动态端口
+func NewTransport(binding string, opt common.ClientOptions, debug bool) (Transport, error) {
+	b, err := ParseStringBinding(binding)
+	if err != nil {
+		return nil, err
+	}
+	switch b.ProtocolSequence {
+	case ProtSeqNp:
+		if b.Endpoint == "" {
+			return nil, errors.New("Missing named pipe in string binding")
+		}
+		opt.Host = b.NetworkAddress
+		if opt.Port == 0 {
+			opt.Port = 445
+		}
+		session, err := smb2.NewSession(opt, debug)
+		if err != nil {
+			return nil, err
+		}
+		t := NewNpTransport(session, b.PipeName())
+		t.owned = true
+		return t, nil
+	case ProtSeqTCP:
+		if b.Endpoint == "" {
+			// 未指定端口时在绑定接口时通过epmapper解析
+			t := &StreamTransport{network: "tcp", host: b.NetworkAddress}
+			t.WithDebug(debug)
+			return t, nil
+		}
+		port, err := strconv.Atoi(b.Endpoint)
+		if err != nil {
+			return nil, errors.New("Invalid tcp port in string binding: " + b.Endpoint)
+		}
+		return NewTCPStreamTransport(b.NetworkAddress, port, debug), nil
+	case ProtSeqLRPC:
+		if b.Endpoint == "" {
+			return nil, errors.New("Missing endpoint in string binding")
+		}
+		return NewLocalStreamTransport(b.Endpoint, debug), nil
+	}
+	return nil, errors.New("Unsupported protocol sequence " + b.ProtocolSequence)
+}
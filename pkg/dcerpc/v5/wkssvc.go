@@ -0,0 +1,268 @@
+package v5
+
+import (
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+)
+
+// 此文件提供wkssvc服务访问封装
+// MS-WKST Workstation Service Remote Protocol
+
+// opnum
+const (
+	NetrWkstaGetInfo  = 0
+	NetrWkstaUserEnum = 2
+)
+
+// 工作站信息，字段是否有值取决于查询级别
+// 100：PlatformId、ComputerName、LanGroup、VersionMajor、VersionMinor
+// 101：100级别的全部字段以及LanRoot
+// 102：101级别的全部字段以及LoggedOnUsers
+type WkstaInfo struct {
+	PlatformId    uint32
+	ComputerName  string
+	LanGroup      string // 所在的域或者工作组
+	VersionMajor  uint32
+	VersionMinor  uint32
+	LanRoot       string
+	LoggedOnUsers uint32
+}
+
+// MS-WKST WKSTA_INFO_100/WKSTA_INFO_101
+type wkstaInfo100 struct {
+	PlatformId   uint32
+	ComputerName string
+	LanGroup     string
+	VersionMajor uint32
+	VersionMinor uint32
+}
+
+type wkstaInfo101 struct {
+	PlatformId   uint32
+	ComputerName string
+	LanGroup     string
+	VersionMajor uint32
+	VersionMinor uint32
+	LanRoot      string
+}
+
+// NetrWkstaGetInfo请求
+// unsigned long NetrWkstaGetInfo(
+//
+//	[in, string, unique] WKSSVC_IDENTIFY_HANDLE ServerName,
+//	[in] unsigned long Level,
+//	[out, switch_is(Level)] LPWKSTA_INFO WkstaInfo
+//	);
+type netrWkstaGetInfoRequest struct {
+	ServerName string // 为空表示本机
+	Level      uint32
+}
+
+type netrWkstaGetInfoResponse struct {
+	WkstaInfo struct {
+		Level   uint32        `ndr:"switch"`
+		Info100 *wkstaInfo100 `ndr:"case:100"`
+		Info101 *wkstaInfo101 `ndr:"case:101"`
+		Info102 *WkstaInfo    `ndr:"case:102"` // 与WKSTA_INFO_102结构相同
+	}
+	ReturnCode uint32
+}
+
+func newNetrWkstaGetInfoRequest(level uint32) (*netrWkstaGetInfoRequest, error) {
+	switch level {
+	case 100, 101, 102:
+	default:
+		return nil, errors.New("Unsupported NetrWkstaGetInfo level")
+	}
+	return &netrWkstaGetInfoRequest{Level: level}, nil
+}
+
+// 转换NetrWkstaGetInfo响应
+func (res *netrWkstaGetInfoResponse) result() (*WkstaInfo, error) {
+	if res.ReturnCode != 0 {
+		return nil, rpcStatusError("NetrWkstaGetInfo", res.ReturnCode)
+	}
+	info := res.WkstaInfo
+	switch {
+	case info.Info100 != nil:
+		return &WkstaInfo{
+			PlatformId:   info.Info100.PlatformId,
+			ComputerName: info.Info100.ComputerName,
+			LanGroup:     info.Info100.LanGroup,
+			VersionMajor: info.Info100.VersionMajor,
+			VersionMinor: info.Info100.VersionMinor,
+		}, nil
+	case info.Info101 != nil:
+		return &WkstaInfo{
+			PlatformId:   info.Info101.PlatformId,
+			ComputerName: info.Info101.ComputerName,
+			LanGroup:     info.Info101.LanGroup,
+			VersionMajor: info.Info101.VersionMajor,
+			VersionMinor: info.Info101.VersionMinor,
+			LanRoot:      info.Info101.LanRoot,
+		}, nil
+	case info.Info102 != nil:
+		return info.Info102, nil
+	}
+	return &WkstaInfo{}, nil
+}
+
+// 查询工作站信息，level支持100、101、102，102级别需要管理员权限
+func (r *RPCClient) NetrWkstaGetInfo(level uint32) (*WkstaInfo, error) {
+	req, err := newNetrWkstaGetInfoRequest(level)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Sending NetrWkstaGetInfo request", nil)
+	var res netrWkstaGetInfoResponse
+	err = r.Request(NetrWkstaGetInfo, req, &res)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	info, err := res.result()
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Completed NetrWkstaGetInfo", nil)
+	return info, nil
+}
+
+// smb->通过wkssvc管道调用NetrWkstaGetInfo
+func (c *SMBClient) NetrWkstaGetInfo(level uint32) (*WkstaInfo, error) {
+	rpc, err := c.OpenPipe("wkssvc", ms.WKSSVC_UUID, ms.WKSSVC_VERSION)
+	if err != nil {
+		return nil, err
+	}
+	defer rpc.Close()
+	return rpc.NetrWkstaGetInfo(level)
+}
+
+// 登录用户信息，字段是否有值取决于查询级别
+// 0：UserName
+// 1：全部字段
+type WkstaUserInfo struct {
+	UserName     string
+	LogonDomain  string
+	OtherDomains string
+	LogonServer  string
+}
+
+// MS-WKST WKSTA_USER_INFO_0
+type wkstaUserInfo0 struct {
+	UserName string
+}
+
+type wkstaUserInfo0Container struct {
+	EntriesRead uint32
+	Buffer      []wkstaUserInfo0
+}
+
+type wkstaUserInfo1Container struct {
+	EntriesRead uint32
+	Buffer      []WkstaUserInfo // 与WKSTA_USER_INFO_1结构相同
+}
+
+// WKSTA_USER_ENUM_STRUCT
+type wkstaUserEnumStruct struct {
+	Level         uint32
+	WkstaUserInfo struct {
+		Level  uint32                   `ndr:"switch"`
+		Level0 *wkstaUserInfo0Container `ndr:"case:0"`
+		Level1 *wkstaUserInfo1Container `ndr:"case:1"`
+	}
+}
+
+// NetrWkstaUserEnum请求
+// unsigned long NetrWkstaUserEnum(
+//
+//	[in, string, unique] WKSSVC_IDENTIFY_HANDLE ServerName,
+//	[in, out] LPWKSTA_USER_ENUM_STRUCT UserInfo,
+//	[in] unsigned long PreferredMaximumLength,
+//	[out] unsigned long* TotalEntries,
+//	[in, out, unique] unsigned long* ResumeHandle
+//	);
+type netrWkstaUserEnumRequest struct {
+	ServerName             string
+	UserInfo               wkstaUserEnumStruct
+	PreferredMaximumLength uint32
+	ResumeHandle           *uint32
+}
+
+type netrWkstaUserEnumResponse struct {
+	UserInfo     wkstaUserEnumStruct
+	TotalEntries uint32
+	ResumeHandle *uint32
+	ReturnCode   uint32
+}
+
+func newNetrWkstaUserEnumRequest(level uint32) (*netrWkstaUserEnumRequest, error) {
+	req := netrWkstaUserEnumRequest{
+		PreferredMaximumLength: MAX_PREFERRED_LENGTH,
+		ResumeHandle:           new(uint32),
+	}
+	req.UserInfo.Level = level
+	req.UserInfo.WkstaUserInfo.Level = level
+	switch level {
+	case 0:
+		req.UserInfo.WkstaUserInfo.Level0 = &wkstaUserInfo0Container{}
+	case 1:
+		req.UserInfo.WkstaUserInfo.Level1 = &wkstaUserInfo1Container{}
+	default:
+		return nil, errors.New("Unsupported NetrWkstaUserEnum level")
+	}
+	return &req, nil
+}
+
+// 转换NetrWkstaUserEnum响应
+func (res *netrWkstaUserEnumResponse) result() ([]WkstaUserInfo, error) {
+	if res.ReturnCode != 0 {
+		return nil, rpcStatusError("NetrWkstaUserEnum", res.ReturnCode)
+	}
+	var users []WkstaUserInfo
+	info := res.UserInfo.WkstaUserInfo
+	switch {
+	case info.Level0 != nil:
+		for _, u := range info.Level0.Buffer {
+			users = append(users, WkstaUserInfo{UserName: u.UserName})
+		}
+	case info.Level1 != nil:
+		users = info.Level1.Buffer
+	}
+	return users, nil
+}
+
+// 枚举登录到工作站的用户，包括交互式登录、服务以及批处理登录，level支持0、1，需要管理员权限
+func (r *RPCClient) NetrWkstaUserEnum(level uint32) ([]WkstaUserInfo, error) {
+	req, err := newNetrWkstaUserEnumRequest(level)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Sending NetrWkstaUserEnum request", nil)
+	var res netrWkstaUserEnumResponse
+	err = r.Request(NetrWkstaUserEnum, req, &res)
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	users, err := res.result()
+	if err != nil {
+		r.Debug("", err)
+		return nil, err
+	}
+	r.Debug("Completed NetrWkstaUserEnum", nil)
+	return users, nil
+}
+
+// smb->通过wkssvc管道调用NetrWkstaUserEnum
+func (c *SMBClient) NetrWkstaUserEnum(level uint32) ([]WkstaUserInfo, error) {
+	rpc, err := c.OpenPipe("wkssvc", ms.WKSSVC_UUID, ms.WKSSVC_VERSION)
+	if err != nil {
+		return nil, err
+	}
+	defer rpc.Close()
+	return rpc.NetrWkstaUserEnum(level)
+}
@@ -20,6 +20,15 @@ func ToUnicode(s string) []byte {
 	return b.Bytes()
 }
 
+// UTF-16LE转换为字符串
+func FromUnicode(buf []byte) string {
+	uints := make([]uint16, len(buf)/2)
+	for i := range uints {
+		uints[i] = binary.LittleEndian.Uint16(buf[i*2:])
+	}
+	return string(utf16.Decode(uints))
+}
+
 // SMB数据包解码
 type BinaryMarshallable interface {
 	MarshalBinary(*Metadata) ([]byte, error)
@@ -344,12 +353,14 @@ func marshal(v interface{}, meta *Metadata) ([]byte, error) {
 			fieldValue := valuev.Field(j)
 			// 处理结构体中的切片类型，用来应对多变情况
 			if fieldValue.Kind() == reflect.Slice {
+				// 切片长度为所有元素长度之和
+				m.Lens[typev.Field(j).Name] = 0
 				for k := 0; k < fieldValue.Len(); k++ {
 					buf, err := marshal(fieldValue.Index(k).Interface(), m)
 					if err != nil {
 						return nil, err
 					}
-					m.Lens[typev.Field(j).Name] = uint64(len(buf))
+					m.Lens[typev.Field(j).Name] += uint64(len(buf))
 					if err := binary.Write(w, binary.LittleEndian, buf); err != nil {
 						return nil, err
 					}
@@ -532,6 +543,13 @@ func unmarshal(buf []byte, v interface{}, meta *Metadata) (interface{}, error) {
 		if err := binary.Read(r, binary.LittleEndian, &ret); err != nil {
 			return nil, err
 		}
+		if meta.Tags.Has("len") {
+			ref, err := meta.Tags.GetString("len")
+			if err != nil {
+				return nil, err
+			}
+			meta.Lens[ref] = uint64(ret)
+		}
 		if meta.Tags.Has("offset") {
 			ref, err := meta.Tags.GetString("offset")
 			if err != nil {
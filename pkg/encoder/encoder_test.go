@@ -0,0 +1,130 @@
+package encoder_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/Amzza0x00/go-impacket/pkg/dcerpc/v5"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"github.com/Amzza0x00/go-impacket/pkg/smb"
+	"github.com/Amzza0x00/go-impacket/pkg/smb/smb2"
+	"testing"
+)
+
+func testHeader() smb.SMB2PacketStruct {
+	return smb.SMB2PacketStruct{
+		ProtocolId:    []byte(smb.ProtocolSMB2),
+		StructureSize: 64,
+		Signature:     make([]byte, 16),
+	}
+}
+
+type offsetStruct struct {
+	Names  []uint16
+	Offset uint16 `smb:"offset:Data"`
+	Length uint16 `smb:"len:Data"`
+	Data   []byte
+}
+
+// 切片字段的长度为全部元素长度之和，之后字段的offset才正确
+func TestMarshalSliceOffset(t *testing.T) {
+	buf, err := encoder.Marshal(offsetStruct{Names: []uint16{1, 2, 3}, Data: []byte{0xaa, 0xbb}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{1, 0, 2, 0, 3, 0, 10, 0, 2, 0, 0xaa, 0xbb}
+	if !bytes.Equal(buf, want) {
+		t.Errorf("Marshal = %x, want %x", buf, want)
+	}
+}
+
+func TestMarshalWriteRequest(t *testing.T) {
+	data := []byte("hello, named pipe")
+	buf, err := encoder.Marshal(smb2.WriteRequestStruct{
+		SMB2PacketStruct: testHeader(),
+		StructureSize:    49,
+		FileId:           make([]byte, 16),
+		Buffer:           data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := buf[smb.SMB2HeaderSize:]
+	// DataOffset从smb2头开始计算，固定部分48字节
+	if offset := binary.LittleEndian.Uint16(body[2:]); offset != smb.SMB2HeaderSize+48 {
+		t.Errorf("DataOffset = %d, want %d", offset, smb.SMB2HeaderSize+48)
+	}
+	if length := binary.LittleEndian.Uint32(body[4:]); length != uint32(len(data)) {
+		t.Errorf("WriteLength = %d, want %d", length, len(data))
+	}
+	if !bytes.Equal(buf[smb.SMB2HeaderSize+48:], data) {
+		t.Errorf("Buffer = %x, want %x", buf[smb.SMB2HeaderSize+48:], data)
+	}
+}
+
+func TestMarshalReadRequest(t *testing.T) {
+	buf, err := encoder.Marshal(smb2.ReadRequestStruct{
+		SMB2PacketStruct: testHeader(),
+		StructureSize:    49,
+		FileOffset:       make([]byte, 8),
+		FileId:           make([]byte, 16),
+		Buffer:           []byte{1, 2, 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// BlobLength位于结构体第46字节
+	if length := binary.LittleEndian.Uint16(buf[smb.SMB2HeaderSize+46:]); length != 3 {
+		t.Errorf("BlobLength = %d, want 3", length)
+	}
+}
+
+// uint32的len标签在解码时记录引用字段的长度，引用字段只读取对应长度的数据
+func TestUnmarshalReadResponse(t *testing.T) {
+	data := []byte("response data")
+	buf, err := encoder.Marshal(smb2.ReadResponseStruct2{
+		SMB2PacketStruct: testHeader(),
+		StructureSize:    17,
+		Info:             data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length := binary.LittleEndian.Uint32(buf[smb.SMB2HeaderSize+4:]); length != uint32(len(data)) {
+		t.Fatalf("BlobLength = %d, want %d", length, len(data))
+	}
+	// 末尾多余的数据不属于Info
+	buf = append(buf, 0xff, 0xff, 0xff, 0xff)
+	var res smb2.ReadResponseStruct2
+	if err = encoder.Unmarshal(buf, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.BlobLength != uint32(len(data)) || !bytes.Equal(res.Info, data) {
+		t.Errorf("BlobLength = %d, Info = %q, want %d, %q", res.BlobLength, res.Info, len(data), data)
+	}
+}
+
+// 请求PDU头长度
+const requestHeaderSize = 24
+
+func TestMarshalAllocHint(t *testing.T) {
+	stub := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	header := v5.NewMSRPCHeader()
+	header.PacketType = v5.PDURequest
+	buf, err := encoder.Marshal(v5.MSRPCRequestHeaderStruct{
+		MSRPCHeaderStruct: header,
+		OpNum:             15,
+		Buffer:            stub,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != requestHeaderSize+len(stub) {
+		t.Fatalf("len = %d, want %d", len(buf), requestHeaderSize+len(stub))
+	}
+	if hint := binary.LittleEndian.Uint32(buf[16:]); hint != uint32(len(stub)) {
+		t.Errorf("AllocHint = %d, want %d", hint, len(stub))
+	}
+	if opnum := binary.LittleEndian.Uint16(buf[22:]); opnum != 15 {
+		t.Errorf("OpNum = %d, want 15", opnum)
+	}
+}
@@ -142,16 +142,18 @@ func ComputeNTLMv2Response(h hash.Hash, clientChallenge, serverChallenge, timest
 	temp = append(temp, serverName...)
 	temp = append(temp, 0, 0, 0, 0)
 	// 计算NT response
+	h.Reset()
 	h.Write(append(serverChallenge, temp...))
 	hmacNT := h.Sum(nil)
 	// 计算LM response
+	h.Reset()
 	h.Write(append(serverChallenge, clientChallenge...))
 	hmacLM := h.Sum(nil)
 	// 计算Session Key
 	// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/5e550938-91d4-459f-b67d-75d70009e3f3
-	// Key = HMAC_MD5(NTLMv2 Hash, HMAC_MD5(NTLMv2 Hash, NTLMv2 Response + Challenge))
 	// Set SessionBaseKey to HMAC_MD5(ResponseKeyNT, NTProofStr)
-	h.Write(append(hmacNT, temp...))
+	h.Reset()
+	h.Write(hmacNT)
 	sessionBaseKey := h.Sum(nil)
 	return append(hmacNT, temp...), append(hmacLM, clientChallenge...), sessionBaseKey
 }
@@ -7,7 +7,9 @@ import (
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/rc4"
 	"encoding/binary"
+	"errors"
 	"github.com/Amzza0x00/go-impacket/pkg/encoder"
 	"hash"
 	"time"
@@ -69,7 +71,8 @@ func NewChallenge() Challenge {
 	}
 }
 
-func NewAuthenticatePass(domain, user, workstation, password string, c Challenge) NTLMv2Authentication {
+// 返回认证消息以及会话基础密钥，未协商密钥交换时会话基础密钥即为导出的会话密钥
+func NewAuthenticatePass(domain, user, workstation, password string, c Challenge) (NTLMv2Authentication, []byte) {
 	// 明文认证
 	//nthash := NTOWFv2(password, user, domain)
 	//lmhash := LMOWFv2(password, user, domain)
@@ -78,7 +81,7 @@ func NewAuthenticatePass(domain, user, workstation, password string, c Challenge
 	return newAuthenticate(h, domain, user, workstation, c)
 }
 
-func NewAuthenticateHash(domain, user, workstation, hash string, c Challenge) NTLMv2Authentication {
+func NewAuthenticateHash(domain, user, workstation, hash string, c Challenge) (NTLMv2Authentication, []byte) {
 	// hash认证
 	//buf := make([]byte, len(hash)/2)
 	//hex.Decode(buf, []byte(hash))
@@ -86,7 +89,7 @@ func NewAuthenticateHash(domain, user, workstation, hash string, c Challenge) NT
 	return newAuthenticate(h, domain, user, workstation, c)
 }
 
-func newAuthenticate(h hash.Hash, domain, user, workstation string, c Challenge) NTLMv2Authentication {
+func newAuthenticate(h hash.Hash, domain, user, workstation string, c Challenge) (NTLMv2Authentication, []byte) {
 	// Assumes domain, user, and workstation are not unicode
 	var timestamp []byte
 	for k, av := range *c.TargetInfo {
@@ -131,8 +134,47 @@ func newAuthenticate(h hash.Hash, domain, user, workstation string, c Challenge)
 			FlgNegNTLMKey |
 			FlgRequestTarget |
 			FlgNegUNICODE,
-		NtChallengeResponse:       ntChallengeResponse,
-		LmChallengeResponse:       lmChallengeResponse,
-		EncryptedRandomSessionKey: sessionBaseKey,
+		NtChallengeResponse: ntChallengeResponse,
+		LmChallengeResponse: lmChallengeResponse,
+		// 未协商NTLMSSP_NEGOTIATE_KEY_EXCH，不发送会话密钥
+		EncryptedRandomSessionKey: []byte{},
+	}, sessionBaseKey
+}
+
+// 需要签名、加密的场景（dcerpc）使用的协商消息
+func NewNegotiateSeal(domainName, workstation string) Negotiate {
+	neg := NewNegotiate(domainName, workstation)
+	neg.NegotiateFlags |= FlgNegSign | FlgNegSeal | FlgNegAlwaysSign | FlgNegKeyExchange
+	return neg
+}
+
+// 根据服务端质询生成认证消息，协商密钥交换时生成随机会话密钥
+// 返回认证消息、协商后的标志位以及导出的会话密钥，hash不为空时使用hash认证
+func NewAuthenticateSeal(domain, user, workstation, password, hash string, c Challenge) (auth NTLMv2Authentication, flags uint32, exportedSessionKey []byte, err error) {
+	var sessionBaseKey []byte
+	if hash != "" {
+		auth, sessionBaseKey = NewAuthenticateHash(domain, user, workstation, hash, c)
+	} else {
+		auth, sessionBaseKey = NewAuthenticatePass(domain, user, workstation, password, c)
+	}
+	flags = c.NegotiateFlags & NewNegotiateSeal(domain, workstation).NegotiateFlags
+	if flags&FlgNegExtendedSecurity == 0 {
+		return auth, 0, nil, errors.New("NTLM extended session security is not negotiated")
+	}
+	auth.NegotiateFlags = flags
+	exportedSessionKey = sessionBaseKey
+	if flags&FlgNegKeyExchange != 0 {
+		// NTLMv2的KeyExchangeKey即为会话基础密钥
+		exportedSessionKey = make([]byte, 16)
+		if _, err = rand.Read(exportedSessionKey); err != nil {
+			return auth, 0, nil, err
+		}
+		cipher, err := rc4.NewCipher(sessionBaseKey)
+		if err != nil {
+			return auth, 0, nil, err
+		}
+		auth.EncryptedRandomSessionKey = make([]byte, 16)
+		cipher.XORKeyStream(auth.EncryptedRandomSessionKey, exportedSessionKey)
 	}
+	return auth, flags, exportedSessionKey, nil
 }
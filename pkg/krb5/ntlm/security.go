@@ -0,0 +1,115 @@
+package ntlm
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+)
+
+// 此文件提供ntlm会话安全，扩展会话安全（ESS）下的签名与加密
+// MS-NLMP 3.4 Session Security
+
+// 签名长度，版本号4字节+校验和8字节+序列号4字节
+const SignatureSize = 16
+
+const (
+	clientSigningMagic = "session key to client-to-server signing key magic constant\x00"
+	serverSigningMagic = "session key to server-to-client signing key magic constant\x00"
+	clientSealingMagic = "session key to client-to-server sealing key magic constant\x00"
+	serverSealingMagic = "session key to server-to-client sealing key magic constant\x00"
+)
+
+// 客户端会话安全上下文，发送与接收分别维护密钥、RC4状态以及序列号
+type Session struct {
+	flags            uint32
+	clientSigningKey []byte
+	serverSigningKey []byte
+	clientHandle     *rc4.Cipher
+	serverHandle     *rc4.Cipher
+	clientSeq        uint32
+	serverSeq        uint32
+}
+
+// 根据协商后的标志位以及导出的会话密钥生成会话安全上下文
+func NewSession(flags uint32, exportedSessionKey []byte) (*Session, error) {
+	if flags&FlgNegExtendedSecurity == 0 {
+		return nil, errors.New("NTLM extended session security is not negotiated")
+	}
+	s := &Session{
+		flags:            flags,
+		clientSigningKey: md5Sum(exportedSessionKey, clientSigningMagic),
+		serverSigningKey: md5Sum(exportedSessionKey, serverSigningMagic),
+	}
+	// 加密密钥长度取决于协商的密钥强度
+	key := exportedSessionKey
+	switch {
+	case flags&FlgNeg128 != 0:
+	case flags&FlgNeg56 != 0:
+		key = key[:7]
+	default:
+		key = key[:5]
+	}
+	var err error
+	if s.clientHandle, err = rc4.NewCipher(md5Sum(key, clientSealingMagic)); err != nil {
+		return nil, err
+	}
+	if s.serverHandle, err = rc4.NewCipher(md5Sum(key, serverSealingMagic)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func md5Sum(key []byte, magic string) []byte {
+	h := md5.New()
+	h.Write(key)
+	h.Write([]byte(magic))
+	return h.Sum(nil)
+}
+
+func mac(flags uint32, handle *rc4.Cipher, signingKey []byte, seq uint32, message []byte) []byte {
+	sig := make([]byte, SignatureSize)
+	binary.LittleEndian.PutUint32(sig[0:], 1)
+	binary.LittleEndian.PutUint32(sig[12:], seq)
+	h := hmac.New(md5.New, signingKey)
+	h.Write(sig[12:])
+	h.Write(message)
+	checksum := h.Sum(nil)[:8]
+	if flags&FlgNegKeyExchange != 0 {
+		handle.XORKeyStream(checksum, checksum)
+	}
+	copy(sig[4:], checksum)
+	return sig
+}
+
+// 对发送的消息签名，每次调用序列号加一
+func (s *Session) Sign(message []byte) []byte {
+	sig := mac(s.flags, s.clientHandle, s.clientSigningKey, s.clientSeq, message)
+	s.clientSeq++
+	return sig
+}
+
+// 加密发送的数据，需要在对同一消息签名之前调用
+func (s *Session) Seal(data []byte) []byte {
+	sealed := make([]byte, len(data))
+	s.clientHandle.XORKeyStream(sealed, data)
+	return sealed
+}
+
+// 解密接收的数据，需要在校验同一消息的签名之前调用
+func (s *Session) Unseal(data []byte) []byte {
+	plain := make([]byte, len(data))
+	s.serverHandle.XORKeyStream(plain, data)
+	return plain
+}
+
+// 校验接收消息的签名，每次调用序列号加一
+func (s *Session) Verify(message, signature []byte) error {
+	expected := mac(s.flags, s.serverHandle, s.serverSigningKey, s.serverSeq, message)
+	s.serverSeq++
+	if !hmac.Equal(expected, signature) {
+		return errors.New("NTLM signature verification failed")
+	}
+	return nil
+}
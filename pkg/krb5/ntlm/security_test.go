@@ -0,0 +1,110 @@
+package ntlm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// MS-NLMP 4.2.4 NTLMv2 Authentication，协商标志为33 82 8a e2，导出的会话密钥为RandomSessionKey
+const testFlags = 0xe28a8233
+
+var testPlaintext = []byte("P\x00l\x00a\x00i\x00n\x00t\x00e\x00x\x00t\x00")
+
+// 服务端会话安全上下文，发送与接收的密钥与客户端相反
+func newServerSession(flags uint32, exportedSessionKey []byte) (*Session, error) {
+	s, err := NewSession(flags, exportedSessionKey)
+	if err != nil {
+		return nil, err
+	}
+	s.clientSigningKey, s.serverSigningKey = s.serverSigningKey, s.clientSigningKey
+	s.clientHandle, s.serverHandle = s.serverHandle, s.clientHandle
+	return s, nil
+}
+
+func testSessionKey(t *testing.T) []byte {
+	return unhex(t, "55555555555555555555555555555555")
+}
+
+// MS-NLMP 4.2.4.4 GSS_WrapEx Examples
+func TestSealSign(t *testing.T) {
+	s, err := NewSession(testFlags, testSessionKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := s.Seal(testPlaintext)
+	if want := unhex(t, "54 e5 01 65 bf 19 36 dc 99 60 20 c1 81 1b 0f 06 fb 5f"); !bytes.Equal(sealed, want) {
+		t.Errorf("Seal = %x, want %x", sealed, want)
+	}
+	sig := s.Sign(testPlaintext)
+	if want := unhex(t, "01 00 00 00 7f b3 8e c5 c5 5d 49 76 00 00 00 00"); !bytes.Equal(sig, want) {
+		t.Errorf("Sign = %x, want %x", sig, want)
+	}
+}
+
+// MS-NLMP 4.2.4.1.4 签名密钥以及加密密钥
+func TestSessionKeys(t *testing.T) {
+	s, err := NewSession(testFlags, testSessionKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := unhex(t, "47 88 dc 86 1b 47 82 f3 5d 43 fd 98 fe 1a 2d 39"); !bytes.Equal(s.clientSigningKey, want) {
+		t.Errorf("client signing key = %x, want %x", s.clientSigningKey, want)
+	}
+	if key, want := md5Sum(testSessionKey(t), clientSealingMagic), unhex(t, "59 f6 00 97 3c c4 96 0a 25 48 0a 7c 19 6e 4c 58"); !bytes.Equal(key, want) {
+		t.Errorf("client sealing key = %x, want %x", key, want)
+	}
+}
+
+// 双方加密签名的消息由对方解密校验，序列号双方同步递增
+func TestSessionRoundTrip(t *testing.T) {
+	client, err := NewSession(testFlags, testSessionKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := newServerSession(testFlags, testSessionKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		sealed := client.Seal(testPlaintext)
+		sig := client.Sign(testPlaintext)
+		plain := server.Unseal(sealed)
+		if !bytes.Equal(plain, testPlaintext) {
+			t.Fatalf("message %d: Unseal = %x, want %x", i, plain, testPlaintext)
+		}
+		if err = server.Verify(plain, sig); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		// 服务端响应
+		sealed = server.Seal(testPlaintext)
+		sig = server.Sign(testPlaintext)
+		if plain = client.Unseal(sealed); !bytes.Equal(plain, testPlaintext) {
+			t.Fatalf("response %d: Unseal = %x, want %x", i, plain, testPlaintext)
+		}
+		if err = client.Verify(plain, sig); err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+	}
+	sig := client.Sign(testPlaintext)
+	sig[4] ^= 1
+	if err = server.Verify(testPlaintext, sig); err == nil {
+		t.Error("tampered signature verified")
+	}
+}
+
+func TestSessionRequiresExtendedSecurity(t *testing.T) {
+	if _, err := NewSession(testFlags&^FlgNegExtendedSecurity, testSessionKey(t)); err == nil {
+		t.Error("NewSession succeeded without extended session security")
+	}
+}
@@ -5,6 +5,7 @@ package ms
 
 const (
 	STATUS_SUCCESS                  = 0x00000000
+	STATUS_PENDING                  = 0x00000103
 	STATUS_MORE_PROCESSING_REQUIRED = 0xC0000016
 	STATUS_ACCESS_DENIED            = 0xC0000022
 	STATUS_LOGON_FAILURE            = 0xC000006D
@@ -15,10 +16,21 @@ const (
 	STATUS_INVALID_PARAMETER        = 0xC000000D
 	STATUS_OBJECT_NAME_NOT_FOUND    = 0xC0000034
 	STATUS_PIPE_BROKEN              = 0xC000014B
+	STATUS_END_OF_FILE              = 0xC0000011
+	STATUS_CANCELLED                = 0xC0000120
+	STATUS_BUFFER_OVERFLOW          = 0x80000005
+	STATUS_NO_MORE_FILES            = 0x80000006
+	STATUS_OBJECT_NAME_COLLISION    = 0xC0000035
+	STATUS_OBJECT_PATH_NOT_FOUND    = 0xC000003A
+	STATUS_SHARING_VIOLATION        = 0xC0000043
+	STATUS_FILE_IS_A_DIRECTORY      = 0xC00000BA
+	STATUS_DIRECTORY_NOT_EMPTY      = 0xC0000101
+	STATUS_NOT_A_DIRECTORY          = 0xC0000103
 )
 
 var StatusMap = map[uint32]string{
 	STATUS_SUCCESS:                  "Requested operation succeeded.",
+	STATUS_PENDING:                  "The operation that was requested is pending completion.",
 	STATUS_MORE_PROCESSING_REQUIRED: "More Processing Required",
 	STATUS_ACCESS_DENIED:            "A process has requested access to an object but has not been granted those access rights.",
 	STATUS_LOGON_FAILURE:            "Authentication failed.",
@@ -29,4 +41,14 @@ var StatusMap = map[uint32]string{
 	STATUS_INVALID_PARAMETER:        "An invalid parameter was passed to a service or function.",
 	STATUS_OBJECT_NAME_NOT_FOUND:    "The object name is not found.",
 	STATUS_PIPE_BROKEN:              "The pipe operation has failed because the other end of the pipe has been closed.",
+	STATUS_END_OF_FILE:              "The end-of-file marker has been reached.",
+	STATUS_CANCELLED:                "The I/O request was canceled.",
+	STATUS_BUFFER_OVERFLOW:          "The data was too large to fit into the specified buffer.",
+	STATUS_NO_MORE_FILES:            "No more files were found which match the file specification.",
+	STATUS_OBJECT_NAME_COLLISION:    "The object name already exists.",
+	STATUS_OBJECT_PATH_NOT_FOUND:    "The path does not exist.",
+	STATUS_SHARING_VIOLATION:        "A file cannot be opened because the share access flags are incompatible.",
+	STATUS_FILE_IS_A_DIRECTORY:      "The file that was specified as a target is a directory.",
+	STATUS_DIRECTORY_NOT_EMPTY:      "The directory is not empty.",
+	STATUS_NOT_A_DIRECTORY:          "A requested opened file is not a directory.",
 }
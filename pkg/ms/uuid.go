@@ -4,24 +4,112 @@ package ms
 
 const (
 	SRVSVC_UUID                 = "4b324fc8-1670-01d3-1278-5a47bf6ee188"
-	SRVSVC_VERSION              = 2
+	SRVSVC_VERSION              = 3
 	NTSVCS_UUID                 = "367abb81-9844-35f1-ad32-98f038001003"
 	NTSVCS_VERSION              = 2
+	WKSSVC_UUID                 = "6bffd098-a112-3610-9833-46c3f87e345a"
+	WKSSVC_VERSION              = 1
 	IID_IObjectExporter         = "99fcfec4-5260-101b-bbcb-00aa0021347a"
 	IID_IObjectExporter_VERSION = 0
 	// NDR 传输标准
 	// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-rpce/b6090c2b-f44a-47a1-a13b-b82ade0137b2
 	NDR_UUID                         = "8a885d04-1ceb-11c9-9fe8-08002b104860"
 	NDR_VERSION                      = 2
+	NDR64_UUID                       = "71710533-beba-4937-8319-b5dbef9ccc36"
+	NDR64_VERSION                    = 1
 	Time_Feature_Negotiation_UUID    = "6cb71c2c-9812-4540-0300-000000000000"
 	Time_Feature_Negotiation_VERSION = 1
 	// epmapper接口
 	EPMv4_UUID    = "e1af8308-5d1f-11c9-91a4-08002b14a0fa"
 	EPMv4_VERSION = 3
+	// 远程管理接口，所有rpc服务端都支持
+	MGMT_UUID    = "afa8bd80-7d8a-11c9-bef4-08002b102989"
+	MGMT_VERSION = 1
 )
 
 var UUIDMap = map[string]string{
 	SRVSVC_UUID:         "\\PIPE\\srvsvc",
 	NTSVCS_UUID:         "\\PIPE\\ntsvcs",
+	WKSSVC_UUID:         "\\PIPE\\wkssvc",
 	IID_IObjectExporter: "IID_IObjectExporter",
 }
+
+// 常见rpc接口
+type KnownInterface struct {
+	UUID     string
+	Version  uint32 // 低16位为主版本号，高16位为次版本号
+	Protocol string
+}
+
+// 常见rpc接口列表，用于rpcdump标注接口以及rpcmap探测未注册的接口
+var KnownInterfaceList = []KnownInterface{
+	{"e1af8308-5d1f-11c9-91a4-08002b14a0fa", 3, "[MS-RPCE]: Endpoint Mapper"},
+	{"afa8bd80-7d8a-11c9-bef4-08002b102989", 1, "[MS-RPCE]: Remote Management Interface"},
+	{"99fcfec4-5260-101b-bbcb-00aa0021347a", 0, "[MS-DCOM]: IObjectExporter"},
+	{"000001a0-0000-0000-c000-000000000046", 0, "[MS-DCOM]: IRemoteSCMActivator"},
+	{"4d9f4ab8-7d1c-11cf-861e-0020af6e7c57", 0, "[MS-DCOM]: IActivation"},
+	{"12345778-1234-abcd-ef00-0123456789ab", 0, "[MS-LSAT]: Local Security Authority (Translation Methods) Remote Protocol"},
+	{"3919286a-b10c-11d0-9ba8-00c04fd92ef5", 0, "[MS-LSAD]: Directory Services Setup Remote Protocol"},
+	{"12345778-1234-abcd-ef00-0123456789ac", 1, "[MS-SAMR]: Security Account Manager (SAM) Remote Protocol"},
+	{"12345678-1234-abcd-ef00-01234567cffb", 1, "[MS-NRPC]: Netlogon Remote Protocol"},
+	{"e3514235-4b06-11d1-ab04-00c04fc2dcd2", 4, "[MS-DRSR]: Directory Replication Service (DRS) Remote Protocol"},
+	{"3dde7c30-165d-11d1-ab8f-00805f14db40", 1, "[MS-BKRP]: BackupKey Remote Protocol"},
+	{"b9785960-524f-11df-8b6d-83dcded72085", 1, "[MS-GKDI]: Group Key Distribution Protocol"},
+	{"4b324fc8-1670-01d3-1278-5a47bf6ee188", 3, "[MS-SRVS]: Server Service Remote Protocol"},
+	{"6bffd098-a112-3610-9833-46c3f87e345a", 1, "[MS-WKST]: Workstation Service Remote Protocol"},
+	{"6bffd098-a112-3610-9833-012892020162", 0, "[MS-BRWSA]: Common Internet File System (CIFS) Browser Auxiliary Protocol"},
+	{"5a7b91f8-ff00-11d0-a9b2-00c04fb6e6fc", 1, "[MS-MSRP]: Messenger Service Remote Protocol"},
+	{"367abb81-9844-35f1-ad32-98f038001003", 2, "[MS-SCMR]: Service Control Manager Remote Protocol"},
+	{"338cd001-2244-31f1-aaaa-900038001003", 1, "[MS-RRP]: Windows Remote Registry Protocol"},
+	{"86d35949-83c9-4044-b424-db363231fd0c", 1, "[MS-TSCH]: Task Scheduler Service Remoting Protocol"},
+	{"378e52b0-c0a9-11cf-822d-00aa0051e40f", 1, "[MS-TSCH]: Task Scheduler Service Remoting Protocol (SASec)"},
+	{"1ff70682-0a51-30e8-076d-740be8cee98b", 1, "[MS-TSCH]: Task Scheduler Service Remoting Protocol (ATSvc)"},
+	{"12345678-1234-abcd-ef00-0123456789ab", 1, "[MS-RPRN]: Print System Remote Protocol"},
+	{"76f03f96-cdfd-44fc-a22c-64950a001209", 1, "[MS-PAR]: Print System Asynchronous Remote Protocol"},
+	{"4a452661-8290-4b36-8fbe-7f4093a94978", 1, "[MS-PAN]: Print System Asynchronous Notification Protocol (IRPCAsyncNotify)"},
+	{"ae33069b-a2a8-46ee-a235-ddfd339be281", 1, "[MS-PAN]: Print System Asynchronous Notification Protocol (IRPCRemoteObject)"},
+	{"c681d488-d850-11d0-8c52-00c04fd90f7e", 1, "[MS-EFSR]: Encrypting File System Remote (EFSRPC) Protocol"},
+	{"df1941c5-fe89-4e79-bf10-463657acf44d", 1, "[MS-EFSR]: Encrypting File System Remote (EFSRPC) Protocol"},
+	{"a8e0653c-2744-4389-a61d-7373df8b2292", 1, "[MS-FSRVP]: File Server Remote VSS Protocol"},
+	{"4fc742e0-4a10-11cf-8273-00aa004ae673", 3, "[MS-DFSNM]: Distributed File System (DFS): Namespace Management Protocol"},
+	{"897e2e5f-93f3-4376-9c9c-fd2277495c27", 1, "[MS-FRS2]: Distributed File System Replication Protocol"},
+	{"f5cc59b4-4264-101a-8c59-08002b2f8426", 1 | 1<<16, "[MS-FRS1]: File Replication Service Protocol"},
+	{"d049b186-814f-11d1-9a3c-00c04fc9b232", 1 | 1<<16, "[MS-FRS1]: File Replication Service Protocol (NtFrsApi)"},
+	{"82273fdc-e32a-18c3-3f78-827929dc23ea", 0, "[MS-EVEN]: EventLog Remoting Protocol"},
+	{"f6beaff7-1e19-4fbb-9f8f-b89e2018337c", 1, "[MS-EVEN6]: EventLog Remoting Protocol Version 6.0"},
+	{"894de0c0-0d55-11d3-a322-00c04fa321a1", 1, "[MS-RSP]: Remote Shutdown Protocol"},
+	{"d95afe70-a6d5-4259-822e-2c84da1ddb0d", 1, "[MS-RSP]: Remote Shutdown Protocol (WindowsShutdown)"},
+	{"8d9f4e40-a03d-11ce-8f69-08003e30051b", 1, "[MS-PNPR]: Plug and Play Remote (PNPR) Protocol"},
+	{"50abc2a4-574d-40b3-9d66-ee4fd5fba076", 5, "[MS-DNSP]: Domain Name Service (DNS) Server Management Protocol"},
+	{"6bffd098-a112-3610-9833-46c3f874532d", 1, "[MS-DHCPM]: Microsoft Dynamic Host Configuration Protocol (DHCP) Server Management Protocol"},
+	{"5b821720-f63b-11d0-aad2-00c04fc324db", 1, "[MS-DHCPM]: Microsoft Dynamic Host Configuration Protocol (DHCP) Server Management Protocol"},
+	{"45f52c28-7f9f-101a-b52b-08002b2efabe", 1, "[MS-RAIW]: Remote Administrative Interface: WINS"},
+	{"8fb6d884-2388-11d0-8c35-00c04fda2795", 4 | 1<<16, "[MS-W32T]: W32Time Remote Protocol"},
+	{"6b5bdd1e-528c-422c-af8c-a4079be4fe48", 1, "[MS-FASP]: Firewall and Advanced Security Protocol"},
+	{"91ae6020-9e3c-11cf-8d7c-00aa00c091be", 0, "[MS-ICPR]: ICertPassage Remote Protocol"},
+	{"d6d70ef0-0e3b-11cb-acc3-08002b1d29c3", 1, "[MS-RPCL]: Remote Procedure Call Location Services Extensions"},
+	{"8f09f000-b7ed-11ce-bbd2-00001a181cad", 0, "[MS-RRASM]: Routing and Remote Access Server (RRAS) Management Protocol (DIMSVC)"},
+	{"20610036-fa22-11cf-9823-00a0c911e5df", 1, "[MS-RRASM]: Routing and Remote Access Server (RRAS) Management Protocol (RASRPC)"},
+	{"ea0a3165-4834-11d2-a6f8-00c04fa346cc", 4, "[MS-FAX]: Fax Server and Client Remote Protocol"},
+	{"5ca4a760-ebb1-11cf-8611-00a0245420ed", 1, "[MS-TSTS]: Terminal Services Terminal Server Runtime Interface Protocol (Legacy)"},
+	{"484809d6-4239-471b-b5bc-61df8c23ac48", 1, "[MS-TSTS]: Terminal Services Terminal Server Runtime Interface Protocol (TermSrvSession)"},
+	{"11899a43-2b68-4a76-92e3-a3d6ad8c26ce", 1, "[MS-TSTS]: Terminal Services Terminal Server Runtime Interface Protocol (TermSrvNotification)"},
+	{"88143fd0-c28d-4b2b-8fef-8d882f6a9390", 1, "[MS-TSTS]: Terminal Services Terminal Server Runtime Interface Protocol (TermSrvEnumeration)"},
+	{"497d95a6-2d27-4bf5-9bbd-a6046957133c", 1, "[MS-TSTS]: Terminal Services Terminal Server Runtime Interface Protocol (RCMListener)"},
+	{"1257b580-ce2f-4109-82d6-a9459d0bf6bc", 1, "[MS-TSTS]: Terminal Services Terminal Server Runtime Interface Protocol (SessEnvPublicRpc)"},
+	{"a4f1db00-ca47-1067-b31f-00dd010662da", 0 | 81<<16, "[MS-OXCRPC]: Wire Format Protocol (EMSMDB)"},
+	{"5261574a-4572-206e-b268-6b199213b4e4", 0 | 1<<16, "[MS-OXCRPC]: Wire Format Protocol (AsyncEMSMDB)"},
+	{"f5cc5a18-4264-101a-8c59-08002b2f8426", 56, "[MS-NSPI]: Name Service Provider Interface (NSPI) Protocol"},
+	{"1544f5e0-613c-11d1-93df-00c04fd7bd09", 1, "[MS-OXABREF]: Address Book Name Service Provider Interface (NSPI) Referral Protocol"},
+}
+
+// 接口uuid对应的协议
+var KnownInterfaces = make(map[string]string)
+
+func init() {
+	for _, i := range KnownInterfaceList {
+		if _, ok := KnownInterfaces[i.UUID]; !ok {
+			KnownInterfaces[i.UUID] = i.Protocol
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package smb
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// AES-CCM认证加密实现，标准库未提供
+// https://www.rfc-editor.org/rfc/rfc3610
+
+type ccm struct {
+	block     cipher.Block
+	nonceSize int
+	tagSize   int
+}
+
+// 创建CCM模式，smb3使用11字节nonce以及16字节认证标签
+func NewCCM(block cipher.Block, nonceSize, tagSize int) (cipher.AEAD, error) {
+	if block.BlockSize() != 16 {
+		return nil, errors.New("CCM requires a 128-bit block cipher")
+	}
+	if nonceSize < 7 || nonceSize > 13 {
+		return nil, errors.New("Invalid CCM nonce size")
+	}
+	if tagSize < 4 || tagSize > 16 || tagSize%2 != 0 {
+		return nil, errors.New("Invalid CCM tag size")
+	}
+	return &ccm{block: block, nonceSize: nonceSize, tagSize: tagSize}, nil
+}
+
+func (c *ccm) NonceSize() int {
+	return c.nonceSize
+}
+
+func (c *ccm) Overhead() int {
+	return c.tagSize
+}
+
+// 计数器块 A_i = Flags | Nonce | i
+func (c *ccm) counter(nonce []byte, i uint64) []byte {
+	a := make([]byte, 16)
+	l := 15 - c.nonceSize
+	a[0] = byte(l - 1)
+	copy(a[1:], nonce)
+	for j := 15; j > c.nonceSize && i > 0; j-- {
+		a[j] = byte(i)
+		i >>= 8
+	}
+	return a
+}
+
+// CBC-MAC计算认证标签
+func (c *ccm) mac(nonce, plaintext, additionalData []byte) []byte {
+	l := 15 - c.nonceSize
+	b0 := make([]byte, 16)
+	b0[0] = byte((c.tagSize-2)/2<<3 | (l - 1))
+	if len(additionalData) > 0 {
+		b0[0] |= 0x40
+	}
+	copy(b0[1:], nonce)
+	n := uint64(len(plaintext))
+	for j := 15; j > c.nonceSize; j-- {
+		b0[j] = byte(n)
+		n >>= 8
+	}
+	x := make([]byte, 16)
+	c.block.Encrypt(x, b0)
+	update := func(data []byte) {
+		for len(data) > 0 {
+			k := copy(make([]byte, 16), data)
+			for j := 0; j < k; j++ {
+				x[j] ^= data[j]
+			}
+			c.block.Encrypt(x, x)
+			data = data[k:]
+		}
+	}
+	if len(additionalData) > 0 {
+		var aad []byte
+		if len(additionalData) < 0xFF00 {
+			aad = make([]byte, 2)
+			binary.BigEndian.PutUint16(aad, uint16(len(additionalData)))
+		} else {
+			aad = make([]byte, 6)
+			aad[0], aad[1] = 0xFF, 0xFE
+			binary.BigEndian.PutUint32(aad[2:], uint32(len(additionalData)))
+		}
+		aad = append(aad, additionalData...)
+		update(aad)
+	}
+	update(plaintext)
+	return x[:c.tagSize]
+}
+
+// CTR模式加解密
+func (c *ccm) ctr(dst, nonce, src []byte) {
+	s := make([]byte, 16)
+	for i := 0; i*16 < len(src); i++ {
+		c.block.Encrypt(s, c.counter(nonce, uint64(i+1)))
+		end := (i + 1) * 16
+		if end > len(src) {
+			end = len(src)
+		}
+		for j := i * 16; j < end; j++ {
+			dst[j] = src[j] ^ s[j-i*16]
+		}
+	}
+}
+
+func (c *ccm) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != c.nonceSize {
+		panic("smb: incorrect CCM nonce length")
+	}
+	tag := c.mac(nonce, plaintext, additionalData)
+	s0 := make([]byte, 16)
+	c.block.Encrypt(s0, c.counter(nonce, 0))
+	out := make([]byte, len(plaintext)+c.tagSize)
+	c.ctr(out, nonce, plaintext)
+	for j := 0; j < c.tagSize; j++ {
+		out[len(plaintext)+j] = tag[j] ^ s0[j]
+	}
+	return append(dst, out...)
+}
+
+func (c *ccm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != c.nonceSize {
+		return nil, errors.New("Incorrect CCM nonce length")
+	}
+	if len(ciphertext) < c.tagSize {
+		return nil, errors.New("CCM ciphertext too short")
+	}
+	n := len(ciphertext) - c.tagSize
+	plaintext := make([]byte, n)
+	c.ctr(plaintext, nonce, ciphertext[:n])
+	s0 := make([]byte, 16)
+	c.block.Encrypt(s0, c.counter(nonce, 0))
+	tag := c.mac(nonce, plaintext, additionalData)
+	for j := 0; j < c.tagSize; j++ {
+		tag[j] ^= s0[j]
+	}
+	if subtle.ConstantTimeCompare(tag, ciphertext[n:]) != 1 {
+		return nil, errors.New("CCM authentication failed")
+	}
+	return append(dst, plaintext...), nil
+}
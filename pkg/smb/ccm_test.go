@@ -0,0 +1,95 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"testing"
+)
+
+// RFC 3610 8. Test Vectors, Packet Vector #1
+func TestCCMPacketVector1(t *testing.T) {
+	key := unhex(t, "C0 C1 C2 C3 C4 C5 C6 C7 C8 C9 CA CB CC CD CE CF")
+	nonce := unhex(t, "00 00 00 03 02 01 00 A0 A1 A2 A3 A4 A5")
+	packet := unhex(t, "00 01 02 03 04 05 06 07 08 09 0A 0B 0C 0D 0E 0F"+
+		"10 11 12 13 14 15 16 17 18 19 1A 1B 1C 1D 1E")
+	want := unhex(t, "58 8C 97 9A 61 C6 63 D2 F0 66 D0 C2 C0 F9 89 80"+
+		"6D 5F 6B 61 DA C3 84 17 E8 D1 2C FD F9 26 E0")
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := NewCCM(block, len(nonce), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, payload := packet[:8], packet[8:]
+	sealed := aead.Seal(nil, nonce, payload, header)
+	if !bytes.Equal(sealed, want) {
+		t.Fatalf("Seal = %x, want %x", sealed, want)
+	}
+	opened, err := aead.Open(nil, nonce, sealed, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, payload) {
+		t.Errorf("Open = %x, want %x", opened, payload)
+	}
+	sealed[0] ^= 1
+	if _, err = aead.Open(nil, nonce, sealed, header); err == nil {
+		t.Error("tampered ciphertext opened")
+	}
+}
+
+func TestTransformRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		cipherId uint16
+		key      string
+	}{
+		{"AES-128-CCM", SMB2_ENCRYPTION_AES128_CCM, "FAD27796665B313EBB578F388632B4F7"},
+		{"AES-128-GCM", SMB2_ENCRYPTION_AES128_GCM, "FAD27796665B313EBB578F388632B4F7"},
+		{"AES-256-CCM", SMB2_ENCRYPTION_AES256_CCM, "FAD27796665B313EBB578F388632B4F7B0F0427F7CEB416D1D9DCC0CD4F99447"},
+		{"AES-256-GCM", SMB2_ENCRYPTION_AES256_GCM, "FAD27796665B313EBB578F388632B4F7B0F0427F7CEB416D1D9DCC0CD4F99447"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := unhex(t, tt.key)
+			msg := testMessage()
+			buf, err := EncryptMessage(tt.cipherId, key, 0x1122334455667788, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsTransformMessage(buf) || len(buf) != SMB2TransformHeaderSize+len(msg) {
+				t.Fatalf("invalid transform message %x", buf)
+			}
+			if bytes.Contains(buf, []byte("payload!")) {
+				t.Error("plaintext found in transform message")
+			}
+			res, err := DecryptMessage(tt.cipherId, key, buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(res, msg) {
+				t.Errorf("DecryptMessage = %x, want %x", res, msg)
+			}
+		})
+	}
+}
+
+// 修改密文、认证标签或者transform头中的认证附加数据都必须解密失败
+func TestTransformTamper(t *testing.T) {
+	key := unhex(t, "FAD27796665B313EBB578F388632B4F7")
+	for _, cipherId := range []uint16{SMB2_ENCRYPTION_AES128_CCM, SMB2_ENCRYPTION_AES128_GCM} {
+		buf, err := EncryptMessage(cipherId, key, 1, testMessage())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, offset := range []int{4, SMB2TransformAADOffset, SMB2TransformHeaderSize - 1, len(buf) - 1} {
+			tampered := append([]byte(nil), buf...)
+			tampered[offset] ^= 1
+			if _, err = DecryptMessage(cipherId, key, tampered); err == nil {
+				t.Errorf("cipher %d: message tampered at offset %d decrypted", cipherId, offset)
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package smb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+)
+
+// 此文件提供SMB3传输加密
+// MS-SMB2 3.1.4.3 Encrypting the Message
+
+// 加密消息协议标识
+const ProtocolSMB2Transform = "\xFDSMB"
+
+// transform头大小，认证附加数据从Nonce开始
+const (
+	SMB2TransformHeaderSize = 52
+	SMB2TransformAADOffset  = 20
+)
+
+// transform头Flags，smb3.0/3.0.2中该字段为EncryptionAlgorithm
+const SMB2_TRANSFORM_FLAG_ENCRYPTED = 0x0001
+
+// MS-SMB2 2.2.41 SMB2 TRANSFORM_HEADER
+// 加密消息头结构
+type SMB2TransformHeaderStruct struct {
+	ProtocolId          []byte `smb:"fixed:4"`  //4字节，必须设置为 0x424D53FD
+	Signature           []byte `smb:"fixed:16"` //16字节，加密认证标签
+	Nonce               []byte `smb:"fixed:16"` //16字节，CCM使用前11字节，GCM使用前12字节
+	OriginalMessageSize uint32 //4字节，原始消息长度
+	Reserved            uint16
+	Flags               uint16
+	SessionId           uint64
+}
+
+// 按加密算法生成密钥派生使用的长度，AES-256使用32字节密钥
+func cipherKeySize(cipherId uint16) int {
+	switch cipherId {
+	case SMB2_ENCRYPTION_AES256_CCM, SMB2_ENCRYPTION_AES256_GCM:
+		return 32
+	default:
+		return 16
+	}
+}
+
+// 根据会话密钥派生加密(客户端到服务端)、解密(服务端到客户端)密钥
+func EncryptionKeys(dialect, cipherId uint16, sessionKey, preauthHash []byte) (encryptionKey, decryptionKey []byte) {
+	size := cipherKeySize(cipherId)
+	if dialect >= SMB3_1_1_Dialect {
+		encryptionKey = KDF(sessionKey, []byte("SMBC2SCipherKey\x00"), preauthHash, size)
+		decryptionKey = KDF(sessionKey, []byte("SMBS2CCipherKey\x00"), preauthHash, size)
+		return
+	}
+	encryptionKey = KDF(sessionKey, []byte("SMB2AESCCM\x00"), []byte("ServerIn \x00"), size)
+	decryptionKey = KDF(sessionKey, []byte("SMB2AESCCM\x00"), []byte("ServerOut\x00"), size)
+	return
+}
+
+func newAEAD(cipherId uint16, key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	switch cipherId {
+	case SMB2_ENCRYPTION_AES128_CCM, SMB2_ENCRYPTION_AES256_CCM:
+		return NewCCM(block, 11, 16)
+	case SMB2_ENCRYPTION_AES128_GCM, SMB2_ENCRYPTION_AES256_GCM:
+		return cipher.NewGCM(block)
+	default:
+		return nil, errors.New("Unsupported encryption algorithm")
+	}
+}
+
+// 加密smb2消息，返回带transform头的数据
+func EncryptMessage(cipherId uint16, key []byte, sessionId uint64, msg []byte) ([]byte, error) {
+	aead, err := newAEAD(cipherId, key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	if _, err = rand.Read(nonce[:aead.NonceSize()]); err != nil {
+		return nil, err
+	}
+	header, err := encoder.Marshal(SMB2TransformHeaderStruct{
+		ProtocolId:          []byte(ProtocolSMB2Transform),
+		Signature:           make([]byte, 16),
+		Nonce:               nonce,
+		OriginalMessageSize: uint32(len(msg)),
+		Flags:               SMB2_TRANSFORM_FLAG_ENCRYPTED,
+		SessionId:           sessionId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nil, nonce[:aead.NonceSize()], msg, header[SMB2TransformAADOffset:])
+	// 密文后附带的认证标签写入Signature字段
+	tag := sealed[len(msg):]
+	copy(header[4:20], tag)
+	return append(header, sealed[:len(msg)]...), nil
+}
+
+// 判断数据是否为加密消息
+func IsTransformMessage(buf []byte) bool {
+	return len(buf) >= SMB2TransformHeaderSize && string(buf[:4]) == ProtocolSMB2Transform
+}
+
+// 解密带transform头的消息
+func DecryptMessage(cipherId uint16, key []byte, buf []byte) ([]byte, error) {
+	if !IsTransformMessage(buf) {
+		return nil, errors.New("Invalid SMB2 transform header")
+	}
+	aead, err := newAEAD(cipherId, key)
+	if err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(buf[SMB2TransformAADOffset+16:])
+	if uint64(size) != uint64(len(buf)-SMB2TransformHeaderSize) {
+		return nil, errors.New("Invalid SMB2 transform message size")
+	}
+	nonce := buf[SMB2TransformAADOffset : SMB2TransformAADOffset+aead.NonceSize()]
+	ciphertext := make([]byte, 0, int(size)+aead.Overhead())
+	ciphertext = append(ciphertext, buf[SMB2TransformHeaderSize:]...)
+	ciphertext = append(ciphertext, buf[4:20]...)
+	msg, err := aead.Open(nil, nonce, ciphertext, buf[SMB2TransformAADOffset:SMB2TransformHeaderSize])
+	if err != nil {
+		return nil, errors.New("SMB2 message decryption failed")
+	}
+	return msg, nil
+}
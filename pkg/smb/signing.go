@@ -0,0 +1,183 @@
+package smb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+// 此文件提供SMB2/SMB3消息签名
+// MS-SMB2 3.1.4.1 Signing An Outgoing Message
+
+// 签名算法，smb3.1.1可通过SMB2_SIGNING_CAPABILITIES协商
+const (
+	SMB2_SIGNING_HMAC_SHA256 = 0x0000
+	SMB2_SIGNING_AES_CMAC    = 0x0001
+	SMB2_SIGNING_AES_GMAC    = 0x0002
+)
+
+var ErrSignatureMismatch = errors.New("SMB2 signature verification failed")
+
+// 按协议版本选择默认签名算法，smb2.x使用HMAC-SHA256，smb3.x使用AES-CMAC
+func SigningAlgorithm(dialect uint16) uint16 {
+	if dialect >= SMB3_0_Dialect {
+		return SMB2_SIGNING_AES_CMAC
+	}
+	return SMB2_SIGNING_HMAC_SHA256
+}
+
+// SP800-108 计数器模式密钥派生，PRF为HMAC-SHA256，length为输出字节数
+// MS-SMB2 3.1.4.2 Generating Cryptographic Keys
+func KDF(key, label, context []byte, length int) []byte {
+	var ret []byte
+	for i := uint32(1); len(ret) < length; i++ {
+		h := hmac.New(sha256.New, key)
+		binary.Write(h, binary.BigEndian, i)
+		h.Write(label)
+		h.Write([]byte{0})
+		h.Write(context)
+		binary.Write(h, binary.BigEndian, uint32(length*8))
+		ret = h.Sum(ret)
+	}
+	return ret[:length]
+}
+
+// 根据会话密钥派生签名密钥
+// smb2.x直接使用会话密钥，smb3.0/3.0.2使用固定上下文，smb3.1.1使用预认证完整性哈希作为上下文
+func SigningKey(dialect uint16, sessionKey, preauthHash []byte) []byte {
+	switch {
+	case dialect >= SMB3_1_1_Dialect:
+		return KDF(sessionKey, []byte("SMBSigningKey\x00"), preauthHash, 16)
+	case dialect >= SMB3_0_Dialect:
+		return KDF(sessionKey, []byte("SMB2AESCMAC\x00"), []byte("SmbSign\x00"), 16)
+	default:
+		return sessionKey
+	}
+}
+
+// 计算消息签名，msg为完整的smb2消息，签名字段会被当作0处理
+func CalculateSignature(algorithm uint16, key, msg []byte) ([]byte, error) {
+	if len(msg) < SMB2HeaderSize {
+		return nil, errors.New("Message too short to sign")
+	}
+	buf := make([]byte, len(msg))
+	copy(buf, msg)
+	copy(buf[SMB2SignatureOffset:SMB2SignatureOffset+SMB2SignatureSize], make([]byte, SMB2SignatureSize))
+	switch algorithm {
+	case SMB2_SIGNING_HMAC_SHA256:
+		h := hmac.New(sha256.New, key)
+		h.Write(buf)
+		return h.Sum(nil)[:SMB2SignatureSize], nil
+	case SMB2_SIGNING_AES_CMAC:
+		return aesCMAC(key, buf)
+	case SMB2_SIGNING_AES_GMAC:
+		return aesGMAC(key, buf)
+	default:
+		return nil, errors.New("Unsupported signing algorithm")
+	}
+}
+
+// 对消息签名，设置SMB2_FLAGS_SIGNED标识并写入签名
+func SignMessage(algorithm uint16, key, msg []byte) error {
+	flags := binary.LittleEndian.Uint32(msg[SMB2FlagsOffset:])
+	binary.LittleEndian.PutUint32(msg[SMB2FlagsOffset:], flags|SMB2_FLAGS_SIGNED)
+	signature, err := CalculateSignature(algorithm, key, msg)
+	if err != nil {
+		return err
+	}
+	copy(msg[SMB2SignatureOffset:], signature)
+	return nil
+}
+
+// 校验消息签名
+func VerifyMessage(algorithm uint16, key, msg []byte) error {
+	signature, err := CalculateSignature(algorithm, key, msg)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(signature, msg[SMB2SignatureOffset:SMB2SignatureOffset+SMB2SignatureSize]) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+// AES-CMAC
+// https://www.rfc-editor.org/rfc/rfc4493
+func aesCMAC(key, msg []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	// 生成子密钥
+	k1 := make([]byte, aes.BlockSize)
+	block.Encrypt(k1, k1)
+	k1 = cmacDouble(k1)
+	k2 := cmacDouble(k1)
+
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	complete := n > 0 && len(msg)%aes.BlockSize == 0
+	if n == 0 {
+		n = 1
+	}
+	last := make([]byte, aes.BlockSize)
+	copy(last, msg[(n-1)*aes.BlockSize:])
+	if complete {
+		xorBytes(last, k1)
+	} else {
+		last[len(msg)-(n-1)*aes.BlockSize] = 0x80
+		xorBytes(last, k2)
+	}
+	x := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		xorBytes(x, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(x, x)
+	}
+	xorBytes(x, last)
+	block.Encrypt(x, x)
+	return x, nil
+}
+
+func cmacDouble(b []byte) []byte {
+	ret := make([]byte, len(b))
+	var carry byte
+	for i := len(b) - 1; i >= 0; i-- {
+		ret[i] = b[i]<<1 | carry
+		carry = b[i] >> 7
+	}
+	if carry != 0 {
+		ret[len(ret)-1] ^= 0x87
+	}
+	return ret
+}
+
+func xorBytes(dst, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
+
+// AES-GMAC，nonce由MessageId、消息方向以及是否为CANCEL请求组成
+func aesGMAC(key, msg []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	copy(nonce, msg[SMB2MessageIdOffset:SMB2MessageIdOffset+8])
+	var role uint32
+	if binary.LittleEndian.Uint32(msg[SMB2FlagsOffset:])&SMB2_FLAGS_SERVER_TO_REDIR != 0 {
+		role |= 1
+	}
+	if binary.LittleEndian.Uint16(msg[SMB2CommandOffset:]) == SMB2_CANCEL {
+		role |= 2
+	}
+	binary.LittleEndian.PutUint32(nonce[8:], role)
+	return gcm.Seal(nil, nonce, nil, msg), nil
+}
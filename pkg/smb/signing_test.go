@@ -0,0 +1,131 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// 测试向量中的十六进制字符串，允许包含空格
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 4493 4. Test Vectors
+func TestAESCMAC(t *testing.T) {
+	key := unhex(t, "2b7e1516 28aed2a6 abf71588 09cf4f3c")
+	msg := unhex(t, "6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51"+
+		"30c81c46 a35ce411 e5fbc119 1a0a52ef f69f2445 df4f9b17 ad2b417b e66c3710")
+	tests := []struct {
+		name string
+		len  int
+		mac  string
+	}{
+		{"Example 1", 0, "bb1d6929 e9593728 7fa37d12 9b756746"},
+		{"Example 2", 16, "070a16b4 6b4d4144 f79bdd9d d04a287c"},
+		{"Example 3", 40, "dfa66747 de9ae630 30ca3261 1497c827"},
+		{"Example 4", 64, "51f0bebf 7e3b9d92 fc497417 79363cfe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mac, err := aesCMAC(key, msg[:tt.len])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := unhex(t, tt.mac); !bytes.Equal(mac, want) {
+				t.Errorf("aesCMAC = %x, want %x", mac, want)
+			}
+		})
+	}
+}
+
+// RFC 4493 2.3 子密钥生成
+func TestCMACSubkeys(t *testing.T) {
+	l := unhex(t, "7df76b0c 1ab899b3 3e42f047 b91b546f")
+	k1 := cmacDouble(l)
+	if want := unhex(t, "fbeed618 35713366 7c85e08f 7236a8de"); !bytes.Equal(k1, want) {
+		t.Errorf("K1 = %x, want %x", k1, want)
+	}
+	if k2, want := cmacDouble(k1), unhex(t, "f7ddac30 6ae266cc f90bc11e e46d513b"); !bytes.Equal(k2, want) {
+		t.Errorf("K2 = %x, want %x", k2, want)
+	}
+}
+
+// smb3.0会话密钥派生示例，来自微软Open Specifications博客
+// "SMB 2 and SMB 3 security in Windows 10: the anatomy of signing and cryptographic keys"
+func TestSMB30KeyDerivation(t *testing.T) {
+	sessionKey := unhex(t, "7CD451825D0450D235424E44BA6E78CC")
+	if key, want := SigningKey(SMB3_0_Dialect, sessionKey, nil), unhex(t, "0B7E9C5CAC36C0F6EA9AB275298CEDCE"); !bytes.Equal(key, want) {
+		t.Errorf("SigningKey = %x, want %x", key, want)
+	}
+	encryptionKey, decryptionKey := EncryptionKeys(SMB3_0_Dialect, SMB2_ENCRYPTION_AES128_CCM, sessionKey, nil)
+	if want := unhex(t, "FAD27796665B313EBB578F388632B4F7"); !bytes.Equal(encryptionKey, want) {
+		t.Errorf("EncryptionKey = %x, want %x", encryptionKey, want)
+	}
+	if want := unhex(t, "B0F0427F7CEB416D1D9DCC0CD4F99447"); !bytes.Equal(decryptionKey, want) {
+		t.Errorf("DecryptionKey = %x, want %x", decryptionKey, want)
+	}
+	if key, want := KDF(sessionKey, []byte("SMB2APP\x00"), []byte("SmbRpc\x00"), 16), unhex(t, "BB23A4575AA26C721AF525AF15A87B4F"); !bytes.Equal(key, want) {
+		t.Errorf("ApplicationKey = %x, want %x", key, want)
+	}
+}
+
+// smb2.x直接使用会话密钥签名
+func TestSMB21SigningKey(t *testing.T) {
+	sessionKey := unhex(t, "7CD451825D0450D235424E44BA6E78CC")
+	if key := SigningKey(SMB2_1_Dialect, sessionKey, nil); !bytes.Equal(key, sessionKey) {
+		t.Errorf("SigningKey = %x, want %x", key, sessionKey)
+	}
+}
+
+func testMessage() []byte {
+	msg := make([]byte, SMB2HeaderSize+8)
+	copy(msg, ProtocolSMB2)
+	binary.LittleEndian.PutUint16(msg[4:], SMB2HeaderSize)
+	binary.LittleEndian.PutUint64(msg[SMB2MessageIdOffset:], 7)
+	copy(msg[SMB2HeaderSize:], "payload!")
+	return msg
+}
+
+func TestSignVerify(t *testing.T) {
+	key := unhex(t, "0B7E9C5CAC36C0F6EA9AB275298CEDCE")
+	for _, algorithm := range []uint16{SMB2_SIGNING_HMAC_SHA256, SMB2_SIGNING_AES_CMAC, SMB2_SIGNING_AES_GMAC} {
+		msg := testMessage()
+		if err := SignMessage(algorithm, key, msg); err != nil {
+			t.Fatalf("algorithm %d: %v", algorithm, err)
+		}
+		if err := VerifyMessage(algorithm, key, msg); err != nil {
+			t.Errorf("algorithm %d: %v", algorithm, err)
+		}
+		msg[len(msg)-1] ^= 1
+		if err := VerifyMessage(algorithm, key, msg); err != ErrSignatureMismatch {
+			t.Errorf("algorithm %d: tampered message verified, err = %v", algorithm, err)
+		}
+	}
+}
+
+// GMAC的nonce为MessageId以及消息方向，服务端响应设置第8字节的最低位
+func TestAESGMACNonce(t *testing.T) {
+	key := unhex(t, "0B7E9C5CAC36C0F6EA9AB275298CEDCE")
+	msg := testMessage()
+	binary.LittleEndian.PutUint32(msg[SMB2FlagsOffset:], SMB2_FLAGS_SERVER_TO_REDIR)
+	mac, err := aesGMAC(key, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	nonce := unhex(t, "0700000000000000 01000000")
+	if want := gcm.Seal(nil, nonce, nil, msg); !bytes.Equal(mac, want) {
+		t.Errorf("aesGMAC = %x, want %x", mac, want)
+	}
+}
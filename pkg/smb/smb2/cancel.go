@@ -0,0 +1,66 @@
+package smb2
+
+import (
+	"context"
+	"github.com/Amzza0x00/go-impacket/pkg/smb"
+)
+
+// 此文件用于取消等待中的请求
+
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/91913fc6-4ec9-4a83-961b-370070067e63
+// 取消请求结构，服务端不会返回响应
+type CancelRequestStruct struct {
+	smb.SMB2PacketStruct
+	StructureSize uint16 //2字节，客户端必须设为4
+	Reserved      uint16
+}
+
+// messageId为需要取消的请求的MessageId
+func (c *Client) NewCancelRequest(messageId uint64) CancelRequestStruct {
+	smb2Header := NewSMB2Packet()
+	smb2Header.Command = smb.SMB2_CANCEL
+	smb2Header.CreditCharge = 0
+	smb2Header.CreditRequestResponse = 0
+	smb2Header.MessageId = messageId
+	smb2Header.SessionId = c.GetSessionId()
+	// 已收到中间响应的异步请求需要使用AsyncId取消，AsyncId占用Reserved、TreeId字段
+	if asyncId, ok := c.GetAsyncId(messageId); ok {
+		smb2Header.Flags = smb.SMB2_FLAGS_ASYNC_COMMAND
+		smb2Header.Reserved = uint32(asyncId)
+		smb2Header.TreeId = uint32(asyncId >> 32)
+	}
+	return CancelRequestStruct{
+		SMB2PacketStruct: smb2Header,
+		StructureSize:    4,
+		Reserved:         0,
+	}
+}
+
+// 取消等待中的请求，被取消的请求会以STATUS_CANCELLED返回
+func (c *Client) Cancel(messageId uint64) error {
+	c.Debug("Sending Cancel request", nil)
+	req := c.NewCancelRequest(messageId)
+	if err := c.SMBSendOnly(req); err != nil {
+		c.Debug("", err)
+		return err
+	}
+	c.Debug("Completed Cancel request", nil)
+	return nil
+}
+
+// 发送请求并等待响应，ctx结束时取消请求，并继续等待服务端返回最终响应
+func (c *Client) SMBSendContext(ctx context.Context, req interface{}) ([]byte, error) {
+	call, err := c.SMBPost(req)
+	if err != nil {
+		c.Debug("", err)
+		return nil, err
+	}
+	select {
+	case <-call.Done():
+	case <-ctx.Done():
+		if err = c.Cancel(call.MessageId); err != nil {
+			return nil, err
+		}
+	}
+	return call.Wait()
+}
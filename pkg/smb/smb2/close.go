@@ -0,0 +1,83 @@
+package smb2
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"github.com/Amzza0x00/go-impacket/pkg/smb"
+)
+
+// 此文件用于关闭文件句柄
+
+// Flags属性
+const SMB2_CLOSE_FLAG_POSTQUERY_ATTRIB = 0x0001
+
+// MS-SMB2 2.2.15 SMB2 CLOSE Request
+// 关闭请求结构
+type CloseRequestStruct struct {
+	smb.SMB2PacketStruct
+	StructureSize uint16 //2字节，客户端必须设为24
+	Flags         uint16
+	Reserved      uint32
+	FileId        []byte `smb:"fixed:16"` //16字节，需要关闭的句柄
+}
+
+// MS-SMB2 2.2.16 SMB2 CLOSE Response
+// 关闭响应结构，设置SMB2_CLOSE_FLAG_POSTQUERY_ATTRIB时返回文件属性
+type CloseResponseStruct struct {
+	smb.SMB2PacketStruct
+	StructureSize  uint16
+	Flags          uint16
+	Reserved       uint32
+	CreationTime   uint64
+	LastAccessTime uint64
+	LastWriteTime  uint64
+	ChangeTime     uint64
+	AllocationSize uint64
+	EndofFile      uint64
+	FileAttributes uint32
+}
+
+func (c *Client) NewCloseRequest(treeId uint32, fileId []byte) CloseRequestStruct {
+	smb2Header := NewSMB2Packet()
+	smb2Header.Command = smb.SMB2_CLOSE
+	smb2Header.CreditCharge = 1
+	smb2Header.MessageId = c.GetMessageId()
+	smb2Header.SessionId = c.GetSessionId()
+	smb2Header.TreeId = treeId
+	return CloseRequestStruct{
+		SMB2PacketStruct: smb2Header,
+		StructureSize:    24,
+		Flags:            0,
+		FileId:           fileId,
+	}
+}
+
+func NewCloseResponse() CloseResponseStruct {
+	smb2Header := NewSMB2Packet()
+	return CloseResponseStruct{
+		SMB2PacketStruct: smb2Header,
+	}
+}
+
+// 关闭文件/管道句柄
+func (c *Client) CloseRequest(treeId uint32, fileId []byte) error {
+	c.Debug("Sending Close request", nil)
+	req := c.NewCloseRequest(treeId, fileId)
+	buf, err := c.SMBSend(req)
+	if err != nil {
+		c.Debug("", err)
+		return err
+	}
+	res := NewCloseResponse()
+	c.Debug("Unmarshalling Close response", nil)
+	if err = encoder.Unmarshal(buf, &res); err != nil {
+		c.Debug("Raw:\n"+hex.Dump(buf), err)
+	}
+	if res.SMB2PacketStruct.Status != ms.STATUS_SUCCESS {
+		return errors.New("Failed to close file: " + ms.StatusMap[res.SMB2PacketStruct.Status])
+	}
+	c.Debug("Completed Close request", nil)
+	return nil
+}
@@ -0,0 +1,71 @@
+package smb2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"github.com/Amzza0x00/go-impacket/pkg/smb"
+)
+
+// 此文件提供复合请求封装
+
+// 相关复合请求中使用的FileId，表示沿用前一个请求打开的句柄
+var RelatedFileId = bytes.Repeat([]byte{0xFF}, 16)
+
+// 发送相关复合请求，后续请求沿用前一个请求的句柄，返回每个请求对应的响应
+func (c *Client) SendRelated(reqs ...interface{}) ([][]byte, error) {
+	return c.SMBSendCompound(reqs, true)
+}
+
+// 发送不相关的复合请求，每个请求独立处理
+func (c *Client) SendUnrelated(reqs ...interface{}) ([][]byte, error) {
+	return c.SMBSendCompound(reqs, false)
+}
+
+// 获取响应状态
+func ResponseStatus(buf []byte) uint32 {
+	return binary.LittleEndian.Uint32(buf[smb.SMB2StatusOffset:])
+}
+
+// 检查复合响应，返回第一个失败的请求
+func compoundError(res [][]byte) error {
+	for i, buf := range res {
+		if status := ResponseStatus(buf); status != ms.STATUS_SUCCESS {
+			return errors.New(fmt.Sprintf("Compound request %d failed: %s", i, ms.StatusMap[status]))
+		}
+	}
+	return nil
+}
+
+// 创建文件、写入数据并关闭句柄
+// 数据不超过单次写入上限时使用一个复合请求完成，否则分块写入
+func (c *Client) CreateWriteClose(treeId uint32, filename string, r CreateRequestStruct, data []byte) error {
+	if uint32(len(data)) > c.MaxWriteChunk() {
+		fileId, err := c.CreateRequest(treeId, filename, r)
+		if err != nil {
+			return err
+		}
+		_, err = c.WriteFile(treeId, fileId, bytes.NewReader(data))
+		if cerr := c.CloseRequest(treeId, fileId); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	c.Debug("Sending Create/Write/Close compound request ["+filename+"]", nil)
+	createReq := c.NewCreateRequest(treeId, filename, r)
+	writeReq := c.NewWriteRequest(treeId, RelatedFileId, data)
+	writeReq.CreditCharge = c.CreditCharge(uint32(len(data)))
+	closeReq := c.NewCloseRequest(treeId, RelatedFileId)
+	res, err := c.SendRelated(createReq, writeReq, closeReq)
+	if err != nil {
+		c.Debug("", err)
+		return err
+	}
+	if err = compoundError(res); err != nil {
+		return errors.New("Failed to write file to [" + filename + "]: " + err.Error())
+	}
+	c.Debug("Completed Create/Write/Close ["+filename+"]", nil)
+	return nil
+}
@@ -0,0 +1,130 @@
+package smb2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/Amzza0x00/go-impacket/pkg/smb"
+	"io"
+	"net"
+	"testing"
+)
+
+var testSigningKey = bytes.Repeat([]byte{0x55}, 16)
+
+// 读取一个NetBIOS会话消息
+func readTestFrame(conn net.Conn) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	_, err := io.ReadFull(conn, buf)
+	return buf, err
+}
+
+func writeTestFrame(conn net.Conn, buf []byte) error {
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(buf)))
+	_, err := conn.Write(append(size, buf...))
+	return err
+}
+
+// 按NextCommand拆分复合请求，检查8字节对齐、相关请求标识以及每个请求的签名
+func checkCompound(t *testing.T, buf []byte) [][]byte {
+	var msgs [][]byte
+	for {
+		next := binary.LittleEndian.Uint32(buf[smb.SMB2NextCommandOffset:])
+		msg := buf
+		if next != 0 {
+			if next%8 != 0 {
+				t.Errorf("NextCommand %d is not 8-byte aligned", next)
+			}
+			msg = buf[:next]
+		}
+		// 签名覆盖包含填充在内的整个请求
+		if err := smb.VerifyMessage(smb.SMB2_SIGNING_HMAC_SHA256, testSigningKey, msg); err != nil {
+			t.Errorf("request %d: %v", len(msgs), err)
+		}
+		flags := binary.LittleEndian.Uint32(msg[smb.SMB2FlagsOffset:])
+		if related := flags&smb.SMB2_FLAGS_RELATED_OPERATIONS != 0; related != (len(msgs) > 0) {
+			t.Errorf("request %d: related flag = %v", len(msgs), related)
+		}
+		msgs = append(msgs, msg)
+		if next == 0 {
+			return msgs
+		}
+		buf = buf[next:]
+	}
+}
+
+// 复合响应，每个响应填充到8字节并单独签名
+func compoundResponse(reqs [][]byte) []byte {
+	var buf []byte
+	for i, req := range reqs {
+		res := make([]byte, smb.SMB2HeaderSize+8)
+		copy(res, req[:smb.SMB2HeaderSize])
+		binary.LittleEndian.PutUint16(res[smb.SMB2CreditRequestOffset:], 1)
+		binary.LittleEndian.PutUint32(res[smb.SMB2FlagsOffset:], smb.SMB2_FLAGS_SERVER_TO_REDIR)
+		binary.LittleEndian.PutUint32(res[smb.SMB2NextCommandOffset:], 0)
+		if i < len(reqs)-1 {
+			binary.LittleEndian.PutUint32(res[smb.SMB2NextCommandOffset:], uint32(len(res)))
+		}
+		smb.SignMessage(smb.SMB2_SIGNING_HMAC_SHA256, testSigningKey, res)
+		buf = append(buf, res...)
+	}
+	return buf
+}
+
+func TestCreateWriteCloseCompound(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &Client{}
+	c.WithConn(client).WithDialect(smb.SMB2_1_Dialect).WithSessionId(1)
+	c.WithSigningKey(testSigningKey, smb.SMB2_SIGNING_HMAC_SHA256)
+	c.IsSigningRequired = true
+
+	done := make(chan [][]byte, 1)
+	go func() {
+		defer close(done)
+		// 第一个请求只用于授予复合请求需要的信用
+		buf, err := readTestFrame(server)
+		if err != nil {
+			return
+		}
+		res := compoundResponse([][]byte{buf})
+		binary.LittleEndian.PutUint16(res[smb.SMB2CreditRequestOffset:], 8)
+		smb.SignMessage(smb.SMB2_SIGNING_HMAC_SHA256, testSigningKey, res)
+		writeTestFrame(server, res)
+		if buf, err = readTestFrame(server); err != nil {
+			return
+		}
+		reqs := checkCompound(t, buf)
+		done <- reqs
+		writeTestFrame(server, compoundResponse(reqs))
+	}()
+	if _, err := c.SMBSend(c.NewCloseRequest(1, RelatedFileId)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 文件名以及数据长度都会使请求需要填充
+	if err := c.CreateWriteClose(1, "a.txt", CreateRequestStruct{}, []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	reqs := <-done
+	if len(reqs) != 3 {
+		t.Fatalf("compound has %d requests, want 3", len(reqs))
+	}
+	for i, command := range []uint16{smb.SMB2_CREATE, smb.SMB2_WRITE, smb.SMB2_CLOSE} {
+		if got := binary.LittleEndian.Uint16(reqs[i][smb.SMB2CommandOffset:]); got != command {
+			t.Errorf("request %d command = 0x%x, want 0x%x", i, got, command)
+		}
+	}
+	// WRITE以及CLOSE沿用CREATE打开的句柄
+	if fileId := reqs[1][smb.SMB2HeaderSize+16 : smb.SMB2HeaderSize+32]; !bytes.Equal(fileId, RelatedFileId) {
+		t.Errorf("WRITE FileId = %x", fileId)
+	}
+	if fileId := reqs[2][smb.SMB2HeaderSize+8 : smb.SMB2HeaderSize+24]; !bytes.Equal(fileId, RelatedFileId) {
+		t.Errorf("CLOSE FileId = %x", fileId)
+	}
+}
@@ -139,6 +139,7 @@ type CreateRequestStruct struct {
 	CreateContextsOffset uint32
 	CreateContextsLength uint32
 	Filename             []byte `smb:"unicode"`
+	Padding              []byte //文件名为空时缓冲区至少需要1字节
 }
 
 // https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/d166aa9e-0b53-410e-b35e-3933d8131927
@@ -178,6 +179,9 @@ func (c *Client) NewCreateRequest(treeId uint32, filename string, r CreateReques
 	r.CreateContextsOffset = 0
 	r.CreateContextsLength = 0
 	r.Filename = encoder.ToUnicode(filename)
+	if filename == "" {
+		r.Padding = []byte{0}
+	}
 	return r
 }
 
@@ -216,6 +220,32 @@ func (c *Client) CreateRequest(treeId uint32, filename string, r CreateRequestSt
 	return res.FileId, nil
 }
 
+// 创建目录
+func (c *Client) Mkdir(treeId uint32, path string) error {
+	path = normalizePath(path)
+	c.Debug("Sending Mkdir request ["+path+"]", nil)
+	createReq := c.NewCreateRequest(treeId, path, CreateRequestStruct{
+		OpLock:             SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel: Impersonation,
+		AccessMask:         FILE_READ_ATTRIBUTES | SYNCHRONIZE,
+		FileAttributes:     FILE_ATTRIBUTE_DIRECTORY,
+		ShareAccess:        FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE,
+		CreateDisposition:  FILE_CREATE,
+		CreateOptions:      FILE_DIRECTORY_FILE,
+	})
+	closeReq := c.NewCloseRequest(treeId, RelatedFileId)
+	res, err := c.SendRelated(createReq, closeReq)
+	if err != nil {
+		c.Debug("", err)
+		return err
+	}
+	if err = compoundError(res); err != nil {
+		return errors.New("Failed to create directory [" + path + "]: " + err.Error())
+	}
+	c.Debug("Completed Mkdir ["+path+"]", nil)
+	return nil
+}
+
 // 打开管道
 func (c *Client) CreatePipeRequest(treeId uint32, pipename string) (fileId []byte, err error) {
 	r := CreateRequestStruct{
@@ -0,0 +1,145 @@
+package smb2
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// 此文件提供文件信息解析
+
+// FileInformationClass属性
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-fscc/4718fc40-e539-4014-8e33-b675af74e3e1
+const (
+	FileDirectoryInformation   = 0x01
+	FileBasicInformation       = 0x04
+	FileStandardInformation    = 0x05
+	FileRenameInformation      = 0x0A
+	FileDispositionInformation = 0x0D
+	FileAllInformation         = 0x12
+	FileEndOfFileInformation   = 0x14
+)
+
+// 文件信息，实现fs.FileInfo
+type FileInfo struct {
+	FileName       string
+	EndOfFile      int64
+	AllocationSize int64
+	FileAttributes uint32
+	CreationTime   time.Time
+	LastAccessTime time.Time
+	LastWriteTime  time.Time
+	ChangeTime     time.Time
+}
+
+func (fi *FileInfo) Name() string {
+	return fi.FileName
+}
+
+func (fi *FileInfo) Size() int64 {
+	return fi.EndOfFile
+}
+
+func (fi *FileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(0644)
+	if fi.FileAttributes&FILE_ATTRIBUTE_READONLY != 0 {
+		mode = 0444
+	}
+	if fi.IsDir() {
+		mode |= fs.ModeDir | 0111
+	}
+	return mode
+}
+
+func (fi *FileInfo) ModTime() time.Time {
+	return fi.LastWriteTime
+}
+
+func (fi *FileInfo) IsDir() bool {
+	return fi.FileAttributes&FILE_ATTRIBUTE_DIRECTORY != 0
+}
+
+func (fi *FileInfo) Sys() interface{} {
+	return fi
+}
+
+// FILETIME转换为time.Time，FILETIME为1601年1月1日起的100纳秒间隔数
+func filetimeToTime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, (int64(ft)-116444736000000000)*100)
+}
+
+// 解析FileDirectoryInformation列表
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-fscc/b38bf518-9057-4c88-9ddd-5e2d3976a64b
+func parseFileDirectoryInformation(buf []byte) ([]FileInfo, error) {
+	var ret []FileInfo
+	for len(buf) > 0 {
+		if len(buf) < 64 {
+			return nil, errors.New("Invalid FileDirectoryInformation")
+		}
+		next := binary.LittleEndian.Uint32(buf)
+		nameLength := binary.LittleEndian.Uint32(buf[60:])
+		if uint64(64+nameLength) > uint64(len(buf)) {
+			return nil, errors.New("Invalid FileDirectoryInformation")
+		}
+		ret = append(ret, FileInfo{
+			FileName:       encoder.FromUnicode(buf[64 : 64+nameLength]),
+			CreationTime:   filetimeToTime(binary.LittleEndian.Uint64(buf[8:])),
+			LastAccessTime: filetimeToTime(binary.LittleEndian.Uint64(buf[16:])),
+			LastWriteTime:  filetimeToTime(binary.LittleEndian.Uint64(buf[24:])),
+			ChangeTime:     filetimeToTime(binary.LittleEndian.Uint64(buf[32:])),
+			EndOfFile:      int64(binary.LittleEndian.Uint64(buf[40:])),
+			AllocationSize: int64(binary.LittleEndian.Uint64(buf[48:])),
+			FileAttributes: binary.LittleEndian.Uint32(buf[56:]),
+		})
+		if next == 0 {
+			break
+		}
+		if uint64(next) > uint64(len(buf)) {
+			return nil, errors.New("Invalid FileDirectoryInformation")
+		}
+		buf = buf[next:]
+	}
+	return ret, nil
+}
+
+// 解析FileAllInformation，只使用FileBasicInformation以及FileStandardInformation部分
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-fscc/95f3056a-ebc1-4f5d-b938-3f68a44677a6
+func parseFileAllInformation(name string, buf []byte) (*FileInfo, error) {
+	if len(buf) < 64 {
+		return nil, errors.New("Invalid FileAllInformation")
+	}
+	return &FileInfo{
+		FileName:       name,
+		CreationTime:   filetimeToTime(binary.LittleEndian.Uint64(buf[0:])),
+		LastAccessTime: filetimeToTime(binary.LittleEndian.Uint64(buf[8:])),
+		LastWriteTime:  filetimeToTime(binary.LittleEndian.Uint64(buf[16:])),
+		ChangeTime:     filetimeToTime(binary.LittleEndian.Uint64(buf[24:])),
+		FileAttributes: binary.LittleEndian.Uint32(buf[32:]),
+		AllocationSize: int64(binary.LittleEndian.Uint64(buf[40:])),
+		EndOfFile:      int64(binary.LittleEndian.Uint64(buf[48:])),
+	}, nil
+}
+
+// smb路径使用反斜杠分隔，并且不能以分隔符开头
+func normalizePath(path string) string {
+	path = strings.ReplaceAll(path, "/", "\\")
+	path = strings.Trim(path, "\\")
+	if path == "." {
+		return ""
+	}
+	return path
+}
+
+// 取路径最后一部分作为文件名
+func baseName(path string) string {
+	if i := strings.LastIndex(path, "\\"); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
@@ -0,0 +1,132 @@
+package smb2
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"github.com/Amzza0x00/go-impacket/pkg/smb"
+)
+
+// 此文件用于列出目录
+
+// Flags属性
+const (
+	SMB2_RESTART_SCANS       = 0x01
+	SMB2_RETURN_SINGLE_ENTRY = 0x02
+	SMB2_INDEX_SPECIFIED     = 0x04
+	SMB2_REOPEN              = 0x10
+)
+
+// MS-SMB2 2.2.33 SMB2 QUERY_DIRECTORY Request
+type QueryDirectoryRequestStruct struct {
+	smb.SMB2PacketStruct
+	StructureSize        uint16 //2字节，客户端必须设为33
+	FileInformationClass uint8
+	Flags                uint8
+	FileIndex            uint32
+	FileId               []byte `smb:"fixed:16"`
+	FileNameOffset       uint16 `smb:"offset:FileName"`
+	FileNameLength       uint16 `smb:"len:FileName"`
+	OutputBufferLength   uint32
+	FileName             []byte //搜索模式，如*
+}
+
+// MS-SMB2 2.2.34 SMB2 QUERY_DIRECTORY Response
+type QueryDirectoryResponseStruct struct {
+	smb.SMB2PacketStruct
+	StructureSize      uint16
+	OutputBufferOffset uint16
+	OutputBufferLength uint32 `smb:"len:Buffer"`
+	Buffer             []byte
+}
+
+func (c *Client) NewQueryDirectoryRequest(treeId uint32, fileId []byte, pattern string) QueryDirectoryRequestStruct {
+	outputLength := c.MaxTransactChunk()
+	smb2Header := NewSMB2Packet()
+	smb2Header.Command = smb.SMB2_QUERY_DIRECTORY
+	smb2Header.CreditCharge = c.CreditCharge(outputLength)
+	smb2Header.MessageId = c.GetMessageId()
+	smb2Header.SessionId = c.GetSessionId()
+	smb2Header.TreeId = treeId
+	return QueryDirectoryRequestStruct{
+		SMB2PacketStruct:     smb2Header,
+		StructureSize:        33,
+		FileInformationClass: FileDirectoryInformation,
+		FileId:               fileId,
+		OutputBufferLength:   outputLength,
+		FileName:             encoder.ToUnicode(pattern),
+	}
+}
+
+// 解析目录查询响应，没有更多文件时返回nil
+func parseQueryDirectoryResponse(buf []byte) ([]FileInfo, error) {
+	switch status := ResponseStatus(buf); status {
+	case ms.STATUS_SUCCESS:
+	case ms.STATUS_NO_MORE_FILES:
+		return nil, nil
+	default:
+		return nil, errors.New(ms.StatusMap[status])
+	}
+	var res QueryDirectoryResponseStruct
+	if err := encoder.Unmarshal(buf, &res); err != nil {
+		return nil, err
+	}
+	return parseFileDirectoryInformation(res.Buffer)
+}
+
+// 列出目录内容，不包含.以及..
+func (c *Client) ListDirectory(treeId uint32, path string) ([]FileInfo, error) {
+	path = normalizePath(path)
+	c.Debug("Sending QueryDirectory request ["+path+"]", nil)
+	// 打开目录以及第一次查询通过复合请求完成
+	createReq := c.NewCreateRequest(treeId, path, CreateRequestStruct{
+		OpLock:             SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel: Impersonation,
+		AccessMask:         FILE_READ_DATA | FILE_READ_ATTRIBUTES | SYNCHRONIZE,
+		ShareAccess:        FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE,
+		CreateDisposition:  FILE_OPEN,
+		CreateOptions:      FILE_DIRECTORY_FILE,
+	})
+	queryReq := c.NewQueryDirectoryRequest(treeId, RelatedFileId, "*")
+	res, err := c.SendRelated(createReq, queryReq)
+	if err != nil {
+		c.Debug("", err)
+		return nil, err
+	}
+	if status := ResponseStatus(res[0]); status != ms.STATUS_SUCCESS {
+		return nil, errors.New("Failed to list directory [" + path + "]: " + ms.StatusMap[status])
+	}
+	createRes := NewCreateResponse()
+	if err = encoder.Unmarshal(res[0], &createRes); err != nil {
+		c.Debug("Raw:\n"+hex.Dump(res[0]), err)
+		return nil, err
+	}
+	defer c.CloseRequest(treeId, createRes.FileId)
+
+	var ret []FileInfo
+	buf := res[1]
+	for {
+		entries, err := parseQueryDirectoryResponse(buf)
+		if err != nil {
+			c.Debug("Raw:\n"+hex.Dump(buf), err)
+			return nil, errors.New("Failed to list directory [" + path + "]: " + err.Error())
+		}
+		if entries == nil {
+			break
+		}
+		for _, entry := range entries {
+			if entry.FileName == "." || entry.FileName == ".." {
+				continue
+			}
+			ret = append(ret, entry)
+		}
+		req := c.NewQueryDirectoryRequest(treeId, createRes.FileId, "*")
+		if buf, err = c.SMBSend(req); err != nil {
+			c.Debug("", err)
+			return nil, err
+		}
+	}
+	c.Debug("Completed QueryDirectory ["+path+"]", nil)
+	return ret, nil
+}
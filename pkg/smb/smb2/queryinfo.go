@@ -0,0 +1,120 @@
+package smb2
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"github.com/Amzza0x00/go-impacket/pkg/smb"
+)
+
+// 此文件用于查询文件信息
+
+// InfoType属性
+const (
+	SMB2_0_INFO_FILE       = 0x01
+	SMB2_0_INFO_FILESYSTEM = 0x02
+	SMB2_0_INFO_SECURITY   = 0x03
+	SMB2_0_INFO_QUOTA      = 0x04
+)
+
+// MS-SMB2 2.2.37 SMB2 QUERY_INFO Request
+type QueryInfoRequestStruct struct {
+	smb.SMB2PacketStruct
+	StructureSize         uint16 //2字节，客户端必须设为41
+	InfoType              uint8
+	FileInfoClass         uint8
+	OutputBufferLength    uint32 //4字节，服务端最多返回的数据长度
+	InputBufferOffset     uint16
+	Reserved              uint16
+	InputBufferLength     uint32
+	AdditionalInformation uint32
+	Flags                 uint32
+	FileId                []byte `smb:"fixed:16"`
+}
+
+// MS-SMB2 2.2.38 SMB2 QUERY_INFO Response
+type QueryInfoResponseStruct struct {
+	smb.SMB2PacketStruct
+	StructureSize      uint16
+	OutputBufferOffset uint16
+	OutputBufferLength uint32 `smb:"len:Buffer"`
+	Buffer             []byte
+}
+
+func (c *Client) NewQueryInfoRequest(treeId uint32, fileId []byte, infoType, fileInfoClass uint8) QueryInfoRequestStruct {
+	outputLength := c.MaxTransactChunk()
+	smb2Header := NewSMB2Packet()
+	smb2Header.Command = smb.SMB2_QUERY_INFO
+	smb2Header.CreditCharge = c.CreditCharge(outputLength)
+	smb2Header.MessageId = c.GetMessageId()
+	smb2Header.SessionId = c.GetSessionId()
+	smb2Header.TreeId = treeId
+	return QueryInfoRequestStruct{
+		SMB2PacketStruct:   smb2Header,
+		StructureSize:      41,
+		InfoType:           infoType,
+		FileInfoClass:      fileInfoClass,
+		OutputBufferLength: outputLength,
+		FileId:             fileId,
+	}
+}
+
+// 解析查询信息响应，返回输出缓冲区
+func parseQueryInfoResponse(buf []byte) ([]byte, error) {
+	if status := ResponseStatus(buf); status != ms.STATUS_SUCCESS {
+		return nil, errors.New(ms.StatusMap[status])
+	}
+	var res QueryInfoResponseStruct
+	if err := encoder.Unmarshal(buf, &res); err != nil {
+		return nil, err
+	}
+	return res.Buffer, nil
+}
+
+// 查询已打开句柄的信息
+func (c *Client) QueryInfoRequest(treeId uint32, fileId []byte, infoType, fileInfoClass uint8) ([]byte, error) {
+	c.Debug("Sending QueryInfo request", nil)
+	req := c.NewQueryInfoRequest(treeId, fileId, infoType, fileInfoClass)
+	buf, err := c.SMBSend(req)
+	if err != nil {
+		c.Debug("", err)
+		return nil, err
+	}
+	info, err := parseQueryInfoResponse(buf)
+	if err != nil {
+		c.Debug("Raw:\n"+hex.Dump(buf), err)
+		return nil, errors.New("Failed to query info: " + err.Error())
+	}
+	c.Debug("Completed QueryInfo request", nil)
+	return info, nil
+}
+
+// 查询文件/目录信息，通过CREATE+QUERY_INFO+CLOSE复合请求完成
+func (c *Client) Stat(treeId uint32, path string) (*FileInfo, error) {
+	path = normalizePath(path)
+	c.Debug("Sending Stat request ["+path+"]", nil)
+	createReq := c.NewCreateRequest(treeId, path, CreateRequestStruct{
+		OpLock:             SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel: Impersonation,
+		AccessMask:         FILE_READ_ATTRIBUTES | SYNCHRONIZE,
+		ShareAccess:        FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE,
+		CreateDisposition:  FILE_OPEN,
+	})
+	queryReq := c.NewQueryInfoRequest(treeId, RelatedFileId, SMB2_0_INFO_FILE, FileAllInformation)
+	closeReq := c.NewCloseRequest(treeId, RelatedFileId)
+	res, err := c.SendRelated(createReq, queryReq, closeReq)
+	if err != nil {
+		c.Debug("", err)
+		return nil, err
+	}
+	if status := ResponseStatus(res[0]); status != ms.STATUS_SUCCESS {
+		return nil, errors.New("Failed to stat [" + path + "]: " + ms.StatusMap[status])
+	}
+	info, err := parseQueryInfoResponse(res[1])
+	if err != nil {
+		return nil, errors.New("Failed to stat [" + path + "]: " + err.Error())
+	}
+	c.Debug("Completed Stat ["+path+"]", nil)
+	return parseFileAllInformation(baseName(path), info)
+}
@@ -1,11 +1,14 @@
 package smb2
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/common"
 	"github.com/Amzza0x00/go-impacket/pkg/encoder"
 	"github.com/Amzza0x00/go-impacket/pkg/ms"
 	"github.com/Amzza0x00/go-impacket/pkg/smb"
+	"io"
 )
 
 // 此文件用于smb2读数据请求
@@ -45,10 +48,10 @@ type ReadResponseStruct2 struct {
 	StructureSize uint16
 	BlobOffset    uint8
 	Reserved      uint8
-	BlobLength    uint32
+	BlobLength    uint32 `smb:"len:Info"`
 	ReadRemaining uint32
 	Reserved2     uint32
-	Info          []byte `smb:"count:BlobLength"` //写入的数据
+	Info          []byte //读取的数据
 }
 
 func (c *Client) NewReadRequest(treeId uint32, fileId []byte) ReadRequestStruct {
@@ -80,24 +83,90 @@ func NewReadResponse() ReadResponseStruct2 {
 	}
 }
 
+// 读取管道数据，单次最多读取一个信用对应的长度
 func (c *Client) ReadRequest(treeId uint32, fileId []byte) (info []byte, err error) {
+	info, err = c.ReadAt(treeId, fileId, 0, common.CreditPayloadSize)
+	if err == io.EOF {
+		return nil, errors.New("Failed to Read response to :" + ms.StatusMap[ms.STATUS_END_OF_FILE])
+	}
+	return info, err
+}
+
+// 从指定偏移读取数据，length超过单次请求上限时会被截断，读到文件末尾返回io.EOF
+func (c *Client) ReadAt(treeId uint32, fileId []byte, offset uint64, length uint32) (info []byte, err error) {
+	res, err := c.read(treeId, fileId, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	switch res.SMB2PacketStruct.Status {
+	case ms.STATUS_SUCCESS:
+	case ms.STATUS_END_OF_FILE:
+		return nil, io.EOF
+	default:
+		return nil, errors.New("Failed to Read response to :" + ms.StatusMap[res.SMB2PacketStruct.Status])
+	}
+	c.Debug("Completed Read response", nil)
+	return res.Info, nil
+}
+
+// 读取一条完整的管道消息，消息长度超过读取长度时服务端返回STATUS_BUFFER_OVERFLOW以及部分数据，继续读取剩余部分
+func (c *Client) ReadPipe(treeId uint32, fileId []byte) (info []byte, err error) {
+	for {
+		res, err := c.read(treeId, fileId, 0, c.MaxReadChunk())
+		if err != nil {
+			return nil, err
+		}
+		switch res.SMB2PacketStruct.Status {
+		case ms.STATUS_SUCCESS:
+			c.Debug("Completed Read pipe response", nil)
+			return append(info, res.Info...), nil
+		case ms.STATUS_BUFFER_OVERFLOW:
+			info = append(info, res.Info...)
+		default:
+			return nil, errors.New("Failed to Read pipe response to :" + ms.StatusMap[res.SMB2PacketStruct.Status])
+		}
+	}
+}
+
+func (c *Client) read(treeId uint32, fileId []byte, offset uint64, length uint32) (res ReadResponseStruct2, err error) {
 	c.Debug("Sending Read request", nil)
+	if max := c.MaxReadChunk(); length > max {
+		length = max
+	}
 	req := c.NewReadRequest(treeId, fileId)
-	req.ReadLength = 1024
+	req.CreditCharge = c.CreditCharge(length)
+	req.ReadLength = length
+	binary.LittleEndian.PutUint64(req.FileOffset, offset)
 	buf, err := c.SMBSend(req)
 	if err != nil {
 		c.Debug("", err)
-		return nil, err
+		return res, err
 	}
-	res := NewReadResponse()
+	res = NewReadResponse()
 	c.Debug("Unmarshalling Read response", nil)
 	if err = encoder.Unmarshal(buf, &res); err != nil {
 		c.Debug("Raw:\n"+hex.Dump(buf), err)
 	}
-	c.Debug("Raw:\n"+hex.Dump(buf), err)
-	if res.SMB2PacketStruct.Status != ms.STATUS_SUCCESS {
-		return nil, errors.New("Failed to Read response to :" + ms.StatusMap[res.SMB2PacketStruct.Status])
+	return res, nil
+}
+
+// 按服务端允许的最大长度分块读取文件，直到文件末尾
+func (c *Client) ReadFile(treeId uint32, fileId []byte, w io.Writer) (n int64, err error) {
+	for {
+		data, err := c.ReadAt(treeId, fileId, uint64(n), c.MaxReadChunk())
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if len(data) == 0 {
+			return n, nil
+		}
+		written, err := w.Write(data)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
 	}
-	c.Debug("Completed Read response", nil)
-	return res.Info, nil
 }
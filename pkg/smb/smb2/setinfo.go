@@ -0,0 +1,124 @@
+package smb2
+
+import (
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"github.com/Amzza0x00/go-impacket/pkg/smb"
+)
+
+// 此文件用于设置文件信息，删除、重命名
+
+// MS-SMB2 2.2.39 SMB2 SET_INFO Request
+type SetInfoRequestStruct struct {
+	smb.SMB2PacketStruct
+	StructureSize         uint16 //2字节，客户端必须设为33
+	InfoType              uint8
+	FileInfoClass         uint8
+	BufferLength          uint32 `smb:"len:Buffer"`
+	BufferOffset          uint16 `smb:"offset:Buffer"`
+	Reserved              uint16
+	AdditionalInformation uint32
+	FileId                []byte `smb:"fixed:16"`
+	Buffer                interface{}
+}
+
+// MS-SMB2 2.2.40 SMB2 SET_INFO Response
+type SetInfoResponseStruct struct {
+	smb.SMB2PacketStruct
+	StructureSize uint16
+}
+
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-fscc/12c3dd1c-14f6-4229-9d29-75fb2cb392f6
+// 删除标记
+type FileDispositionInformationStruct struct {
+	DeletePending uint8
+}
+
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-fscc/52aa0b70-8094-4971-862d-79793f41e6a8
+// 重命名，smb2协议中RootDirectory必须为0
+type FileRenameInformationStruct struct {
+	ReplaceIfExists uint8
+	Reserved        []byte `smb:"fixed:7"`
+	RootDirectory   uint64
+	FileNameLength  uint32 `smb:"len:FileName"`
+	FileName        []byte //相对共享根目录的完整路径
+}
+
+func (c *Client) NewSetInfoRequest(treeId uint32, fileId []byte, infoType, fileInfoClass uint8, info interface{}) SetInfoRequestStruct {
+	smb2Header := NewSMB2Packet()
+	smb2Header.Command = smb.SMB2_SET_INFO
+	smb2Header.CreditCharge = 1
+	smb2Header.MessageId = c.GetMessageId()
+	smb2Header.SessionId = c.GetSessionId()
+	smb2Header.TreeId = treeId
+	return SetInfoRequestStruct{
+		SMB2PacketStruct: smb2Header,
+		StructureSize:    33,
+		InfoType:         infoType,
+		FileInfoClass:    fileInfoClass,
+		FileId:           fileId,
+		Buffer:           info,
+	}
+}
+
+// 以指定权限打开文件后设置信息并关闭，通过CREATE+SET_INFO+CLOSE复合请求完成
+func (c *Client) setInfoByPath(treeId uint32, path string, createOptions uint32, fileInfoClass uint8, info interface{}) error {
+	createReq := c.NewCreateRequest(treeId, path, CreateRequestStruct{
+		OpLock:             SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel: Impersonation,
+		AccessMask:         DELETE | FILE_READ_ATTRIBUTES | SYNCHRONIZE,
+		ShareAccess:        FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE,
+		CreateDisposition:  FILE_OPEN,
+		CreateOptions:      createOptions,
+	})
+	setReq := c.NewSetInfoRequest(treeId, RelatedFileId, SMB2_0_INFO_FILE, fileInfoClass, info)
+	closeReq := c.NewCloseRequest(treeId, RelatedFileId)
+	res, err := c.SendRelated(createReq, setReq, closeReq)
+	if err != nil {
+		c.Debug("", err)
+		return err
+	}
+	return compoundError(res)
+}
+
+// 删除文件
+func (c *Client) Remove(treeId uint32, path string) error {
+	path = normalizePath(path)
+	c.Debug("Sending Remove request ["+path+"]", nil)
+	err := c.setInfoByPath(treeId, path, FILE_NON_DIRECTORY_FILE, FileDispositionInformation, FileDispositionInformationStruct{DeletePending: 1})
+	if err != nil {
+		return errors.New("Failed to remove [" + path + "]: " + err.Error())
+	}
+	c.Debug("Completed Remove ["+path+"]", nil)
+	return nil
+}
+
+// 删除空目录
+func (c *Client) Rmdir(treeId uint32, path string) error {
+	path = normalizePath(path)
+	c.Debug("Sending Rmdir request ["+path+"]", nil)
+	err := c.setInfoByPath(treeId, path, FILE_DIRECTORY_FILE, FileDispositionInformation, FileDispositionInformationStruct{DeletePending: 1})
+	if err != nil {
+		return errors.New("Failed to remove directory [" + path + "]: " + err.Error())
+	}
+	c.Debug("Completed Rmdir ["+path+"]", nil)
+	return nil
+}
+
+// 重命名文件或目录，目标已存在时失败
+func (c *Client) Rename(treeId uint32, oldpath, newpath string) error {
+	oldpath = normalizePath(oldpath)
+	newpath = normalizePath(newpath)
+	c.Debug("Sending Rename request ["+oldpath+"] -> ["+newpath+"]", nil)
+	info := FileRenameInformationStruct{
+		ReplaceIfExists: 0,
+		Reserved:        make([]byte, 7),
+		RootDirectory:   0,
+		FileName:        encoder.ToUnicode(newpath),
+	}
+	if err := c.setInfoByPath(treeId, oldpath, 0, FileRenameInformation, info); err != nil {
+		return errors.New("Failed to rename [" + oldpath + "]: " + err.Error())
+	}
+	c.Debug("Completed Rename ["+oldpath+"]", nil)
+	return nil
+}
@@ -0,0 +1,433 @@
+package smb2
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 此文件提供远程共享的文件系统适配，路径使用io/fs格式，如dir/file.txt，共享根目录为.
+
+// 远程共享，实现fs.FS、fs.ReadDirFS、fs.StatFS
+type Share struct {
+	client *Client
+	name   string
+	treeId uint32
+}
+
+var (
+	_ fs.ReadDirFS       = (*Share)(nil)
+	_ fs.StatFS          = (*Share)(nil)
+	_ fs.ReadDirFile     = (*File)(nil)
+	_ io.ReadWriteSeeker = (*File)(nil)
+	_ io.ReaderAt        = (*File)(nil)
+	_ io.WriterAt        = (*File)(nil)
+)
+
+// 连接共享，返回可以像本地文件系统一样访问的Share
+func (c *Client) TreeConnectShare(name string) (*Share, error) {
+	treeId, err := c.TreeConnect(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Share{client: c, name: name, treeId: treeId}, nil
+}
+
+func (s *Share) GetName() string {
+	return s.name
+}
+
+func (s *Share) GetTreeId() uint32 {
+	return s.treeId
+}
+
+// 断开共享连接，同名共享的其他连接不受影响
+func (s *Share) Close() error {
+	return s.client.TreeDisconnectId(s.treeId)
+}
+
+// 以只读方式打开文件或目录
+func (s *Share) Open(name string) (fs.File, error) {
+	f, err := s.OpenFile(name, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// 创建文件，文件已存在时清空
+func (s *Share) Create(name string) (*File, error) {
+	return s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+// 按os.O_*标志打开文件，只读打开时可以打开目录
+func (s *Share) OpenFile(name string, flag int) (*File, error) {
+	var access uint32 = FILE_READ_ATTRIBUTES | SYNCHRONIZE
+	var options uint32
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_RDONLY:
+		access |= FILE_READ_DATA
+	case os.O_WRONLY:
+		access |= FILE_WRITE_DATA | FILE_APPEND_DATA | FILE_WRITE_ATTRIBUTES
+		options = FILE_NON_DIRECTORY_FILE
+	case os.O_RDWR:
+		access |= FILE_READ_DATA | FILE_WRITE_DATA | FILE_APPEND_DATA | FILE_WRITE_ATTRIBUTES
+		options = FILE_NON_DIRECTORY_FILE
+	}
+	var disposition uint32
+	switch {
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		disposition = FILE_CREATE
+	case flag&os.O_CREATE != 0 && flag&os.O_TRUNC != 0:
+		disposition = FILE_OVERWRITE_IF
+	case flag&os.O_CREATE != 0:
+		disposition = FILE_OPEN_IF
+	case flag&os.O_TRUNC != 0:
+		disposition = FILE_OVERWRITE
+	default:
+		disposition = FILE_OPEN
+	}
+	f, err := s.open("open", name, access, disposition, options)
+	if err != nil {
+		return nil, err
+	}
+	f.append = flag&os.O_APPEND != 0
+	return f, nil
+}
+
+// 获取文件或目录信息
+func (s *Share) Stat(name string) (fs.FileInfo, error) {
+	f, err := s.open("stat", name, FILE_READ_ATTRIBUTES|SYNCHRONIZE, FILE_OPEN, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// 列出目录，按文件名排序
+func (s *Share) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := s.open("readdir", name, FILE_READ_DATA|FILE_READ_ATTRIBUTES|SYNCHRONIZE, FILE_OPEN, FILE_DIRECTORY_FILE)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries, err := f.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (s *Share) Remove(name string) error {
+	if !validPath(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	return s.client.Remove(s.treeId, name)
+}
+
+func (s *Share) Rename(oldname, newname string) error {
+	if !validPath(oldname) || !validPath(newname) {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrInvalid}
+	}
+	return s.client.Rename(s.treeId, oldname, newname)
+}
+
+func (s *Share) Mkdir(name string) error {
+	if !validPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return s.client.Mkdir(s.treeId, name)
+}
+
+func (s *Share) Rmdir(name string) error {
+	if !validPath(name) {
+		return &fs.PathError{Op: "rmdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return s.client.Rmdir(s.treeId, name)
+}
+
+// 打开文件句柄，错误以fs.PathError返回
+func (s *Share) open(op, name string, access, disposition, options uint32) (*File, error) {
+	if !validPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	c := s.client
+	req := c.NewCreateRequest(s.treeId, normalizePath(name), CreateRequestStruct{
+		OpLock:             SMB2_OPLOCK_LEVEL_NONE,
+		ImpersonationLevel: Impersonation,
+		AccessMask:         access,
+		FileAttributes:     FILE_ATTRIBUTE_NORMAL,
+		ShareAccess:        FILE_SHARE_READ | FILE_SHARE_WRITE | FILE_SHARE_DELETE,
+		CreateDisposition:  disposition,
+		CreateOptions:      options,
+	})
+	buf, err := c.SMBSend(req)
+	if err != nil {
+		c.Debug("", err)
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	res := NewCreateResponse()
+	if err = encoder.Unmarshal(buf, &res); err != nil {
+		c.Debug("Raw:\n"+hex.Dump(buf), err)
+	}
+	if res.SMB2PacketStruct.Status != ms.STATUS_SUCCESS {
+		return nil, &fs.PathError{Op: op, Path: name, Err: statusError(res.SMB2PacketStruct.Status)}
+	}
+	return &File{
+		share:  s,
+		name:   name,
+		fileId: res.FileId,
+		isDir:  res.FileAttributes&FILE_ATTRIBUTE_DIRECTORY != 0,
+	}, nil
+}
+
+// io/fs路径格式校验，smb使用反斜杠作为分隔符，因此路径中不允许出现反斜杠
+func validPath(name string) bool {
+	return fs.ValidPath(name) && !strings.Contains(name, "\\")
+}
+
+// 将常见的NT状态码转换为io/fs定义的错误，便于使用errors.Is判断
+func statusError(status uint32) error {
+	switch status {
+	case ms.STATUS_OBJECT_NAME_NOT_FOUND, ms.STATUS_OBJECT_PATH_NOT_FOUND:
+		return fs.ErrNotExist
+	case ms.STATUS_OBJECT_NAME_COLLISION:
+		return fs.ErrExist
+	case ms.STATUS_ACCESS_DENIED:
+		return fs.ErrPermission
+	case ms.STATUS_FILE_CLOSED:
+		return fs.ErrClosed
+	}
+	return errors.New(ms.StatusMap[status])
+}
+
+// 远程文件句柄，实现io.Reader、io.Writer、io.Seeker、io.ReaderAt、io.WriterAt、io.Closer以及fs.ReadDirFile
+// 同一个File不能在多个goroutine中同时Read/Write/Seek
+type File struct {
+	share      *Share
+	name       string
+	fileId     []byte
+	isDir      bool
+	append     bool
+	closed     bool
+	offset     int64
+	dirEntries []fs.DirEntry //已查询但未返回的目录项
+	dirEOF     bool
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+func (f *File) GetFileId() []byte {
+	return f.fileId
+}
+
+func (f *File) checkValid(op string) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	}
+	return nil
+}
+
+func (f *File) Stat() (fs.FileInfo, error) {
+	if err := f.checkValid("stat"); err != nil {
+		return nil, err
+	}
+	c := f.share.client
+	buf, err := c.QueryInfoRequest(f.share.treeId, f.fileId, SMB2_0_INFO_FILE, FileAllInformation)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: err}
+	}
+	info, err := parseFileAllInformation(baseName(normalizePath(f.name)), buf)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: err}
+	}
+	if f.name == "." {
+		info.FileName = "."
+	}
+	return info, nil
+}
+
+// 从当前偏移读取，单次最多读取一个READ请求的长度
+func (f *File) Read(p []byte) (n int, err error) {
+	if err = f.checkValid("read"); err != nil {
+		return 0, err
+	}
+	if f.isDir {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errors.New("Is a directory")}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err = f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// 从指定偏移读取直到填满p，不改变当前偏移
+func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
+	if err = f.checkValid("read"); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: errors.New("Negative offset")}
+	}
+	for n < len(p) {
+		m, err := f.readAt(p[n:], off+int64(n))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (f *File) readAt(p []byte, off int64) (int, error) {
+	length := uint32(len(p))
+	if uint64(len(p)) > uint64(^uint32(0)) {
+		length = ^uint32(0)
+	}
+	data, err := f.share.client.ReadAt(f.share.treeId, f.fileId, uint64(off), length)
+	if err == io.EOF {
+		return 0, io.EOF
+	}
+	if err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	if len(data) == 0 {
+		return 0, io.EOF
+	}
+	return copy(p, data), nil
+}
+
+// 从当前偏移写入，O_APPEND打开时总是写入文件末尾
+func (f *File) Write(p []byte) (n int, err error) {
+	if err = f.checkValid("write"); err != nil {
+		return 0, err
+	}
+	if f.append {
+		if _, err = f.Seek(0, io.SeekEnd); err != nil {
+			return 0, err
+		}
+	}
+	n, err = f.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// 向指定偏移写入，按服务端允许的最大长度分块
+func (f *File) WriteAt(p []byte, off int64) (n int, err error) {
+	if err = f.checkValid("write"); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "writeat", Path: f.name, Err: errors.New("Negative offset")}
+	}
+	c := f.share.client
+	for n < len(p) {
+		chunk := p[n:]
+		if max := int(c.MaxWriteChunk()); len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		written, err := c.WriteAt(f.share.treeId, f.fileId, uint64(off)+uint64(n), chunk)
+		n += int(written)
+		if err != nil {
+			return n, &fs.PathError{Op: "write", Path: f.name, Err: err}
+		}
+		if int(written) != len(chunk) {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if err := f.checkValid("seek"); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		info, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		offset += info.Size()
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: errors.New("Negative position")}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// 读取目录项，n<=0时返回剩余全部目录项
+func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
+	if err := f.checkValid("readdir"); err != nil {
+		return nil, err
+	}
+	if !f.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errors.New("Not a directory")}
+	}
+	c := f.share.client
+	for !f.dirEOF && (n <= 0 || len(f.dirEntries) < n) {
+		req := c.NewQueryDirectoryRequest(f.share.treeId, f.fileId, "*")
+		buf, err := c.SMBSend(req)
+		if err != nil {
+			c.Debug("", err)
+			return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: err}
+		}
+		entries, err := parseQueryDirectoryResponse(buf)
+		if err != nil {
+			c.Debug("Raw:\n"+hex.Dump(buf), err)
+			return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: err}
+		}
+		if entries == nil {
+			f.dirEOF = true
+			break
+		}
+		for i := range entries {
+			if entries[i].FileName == "." || entries[i].FileName == ".." {
+				continue
+			}
+			f.dirEntries = append(f.dirEntries, fs.FileInfoToDirEntry(&entries[i]))
+		}
+	}
+	count := len(f.dirEntries)
+	if n > 0 && n < count {
+		count = n
+	}
+	ret := f.dirEntries[:count:count]
+	f.dirEntries = f.dirEntries[count:]
+	if count == 0 && n > 0 {
+		return nil, io.EOF
+	}
+	return ret, nil
+}
+
+// 关闭文件句柄
+func (f *File) Close() error {
+	if err := f.checkValid("close"); err != nil {
+		return err
+	}
+	f.closed = true
+	if err := f.share.client.CloseRequest(f.share.treeId, f.fileId); err != nil {
+		return &fs.PathError{Op: "close", Path: f.name, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package smb2
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/Amzza0x00/go-impacket/pkg/encoder"
+	"github.com/Amzza0x00/go-impacket/pkg/ms"
+	"github.com/Amzza0x00/go-impacket/pkg/smb"
+	"io"
+	"io/fs"
+	"net"
+	"testing"
+)
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		status uint32
+		want   error
+	}{
+		{ms.STATUS_OBJECT_NAME_NOT_FOUND, fs.ErrNotExist},
+		{ms.STATUS_OBJECT_PATH_NOT_FOUND, fs.ErrNotExist},
+		{ms.STATUS_OBJECT_NAME_COLLISION, fs.ErrExist},
+		{ms.STATUS_ACCESS_DENIED, fs.ErrPermission},
+		{ms.STATUS_FILE_CLOSED, fs.ErrClosed},
+	}
+	for _, tt := range tests {
+		if err := statusError(tt.status); !errors.Is(err, tt.want) {
+			t.Errorf("statusError(0x%08x) = %v, want %v", tt.status, err, tt.want)
+		}
+	}
+	err := statusError(ms.STATUS_SHARING_VIOLATION)
+	if err == nil || errors.Is(err, fs.ErrNotExist) || err.Error() != ms.StatusMap[ms.STATUS_SHARING_VIOLATION] {
+		t.Errorf("statusError(STATUS_SHARING_VIOLATION) = %v", err)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	tests := []struct {
+		name    string
+		offset  int64
+		whence  int
+		want    int64
+		wantErr bool
+	}{
+		{"start", 5, io.SeekStart, 5, false},
+		{"current", 3, io.SeekCurrent, 13, false},
+		{"current backwards", -10, io.SeekCurrent, 0, false},
+		{"negative start", -1, io.SeekStart, 10, true},
+		{"negative current", -11, io.SeekCurrent, 10, true},
+		{"invalid whence", 0, 3, 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &File{name: "a.txt", offset: 10}
+			pos, err := f.Seek(tt.offset, tt.whence)
+			if tt.wantErr {
+				var pathErr *fs.PathError
+				if !errors.As(err, &pathErr) {
+					t.Errorf("Seek err = %v, want PathError", err)
+				}
+			} else if err != nil || pos != tt.want {
+				t.Errorf("Seek = %d, %v, want %d", pos, err, tt.want)
+			}
+			// 失败时不改变当前偏移
+			if f.offset != tt.want {
+				t.Errorf("offset = %d, want %d", f.offset, tt.want)
+			}
+		})
+	}
+	f := &File{name: "a.txt", closed: true}
+	if _, err := f.Seek(0, io.SeekStart); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Seek on closed file err = %v", err)
+	}
+}
+
+// FILE_DIRECTORY_INFORMATION条目，按8字节对齐
+func testDirectoryInformation(names ...string) []byte {
+	var buf []byte
+	for i, name := range names {
+		fileName := encoder.ToUnicode(name)
+		entry := make([]byte, 64, 64+len(fileName)+8)
+		binary.LittleEndian.PutUint32(entry[60:], uint32(len(fileName)))
+		entry = append(entry, fileName...)
+		if i < len(names)-1 {
+			entry = append(entry, make([]byte, (8-len(entry)%8)%8)...)
+			binary.LittleEndian.PutUint32(entry, uint32(len(entry)))
+		}
+		buf = append(buf, entry...)
+	}
+	return buf
+}
+
+// QUERY_DIRECTORY响应，pages用完后返回STATUS_NO_MORE_FILES
+func serveQueryDirectory(server net.Conn, pages [][]string) {
+	for {
+		req, err := readTestFrame(server)
+		if err != nil {
+			return
+		}
+		res := make([]byte, smb.SMB2HeaderSize+8)
+		copy(res, req[:smb.SMB2HeaderSize])
+		binary.LittleEndian.PutUint16(res[smb.SMB2CreditRequestOffset:], 1)
+		binary.LittleEndian.PutUint32(res[smb.SMB2FlagsOffset:], smb.SMB2_FLAGS_SERVER_TO_REDIR)
+		if len(pages) == 0 {
+			binary.LittleEndian.PutUint32(res[smb.SMB2StatusOffset:], ms.STATUS_NO_MORE_FILES)
+			binary.LittleEndian.PutUint16(res[smb.SMB2HeaderSize:], 9)
+		} else {
+			info := testDirectoryInformation(pages[0]...)
+			pages = pages[1:]
+			binary.LittleEndian.PutUint16(res[smb.SMB2HeaderSize:], 9)
+			binary.LittleEndian.PutUint16(res[smb.SMB2HeaderSize+2:], smb.SMB2HeaderSize+8)
+			binary.LittleEndian.PutUint32(res[smb.SMB2HeaderSize+4:], uint32(len(info)))
+			res = append(res, info...)
+		}
+		if writeTestFrame(server, res) != nil {
+			return
+		}
+	}
+}
+
+// ReadDir(n)每次最多返回n个目录项，跳过.以及..，读完后返回io.EOF
+func TestReadDirPaging(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go serveQueryDirectory(server, [][]string{{".", "..", "a", "b", "c"}, {"d", "e"}})
+	c := &Client{}
+	c.WithConn(client).WithDialect(smb.SMB2_1_Dialect)
+	f := &File{share: &Share{client: c, treeId: 1}, name: "dir", fileId: make([]byte, 16), isDir: true}
+
+	for _, want := range [][]string{{"a", "b"}, {"c", "d"}, {"e"}} {
+		entries, err := f.ReadDir(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if len(names) != len(want) || names[0] != want[0] || names[len(names)-1] != want[len(want)-1] {
+			t.Errorf("ReadDir(2) = %v, want %v", names, want)
+		}
+	}
+	if entries, err := f.ReadDir(2); err != io.EOF || len(entries) != 0 {
+		t.Errorf("ReadDir(2) at end = %v, %v, want io.EOF", entries, err)
+	}
+	// n<=0时读完不返回错误
+	if entries, err := f.ReadDir(-1); err != nil || len(entries) != 0 {
+		t.Errorf("ReadDir(-1) at end = %v, %v", entries, err)
+	}
+	if _, err := (&File{name: "a.txt"}).ReadDir(1); err == nil {
+		t.Error("ReadDir on a regular file succeeded")
+	}
+}
+
+// 同名共享重复连接时，关闭其中一个Share只断开它自己的树连接
+func TestShareCloseByTreeId(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &Client{}
+	c.WithConn(client).WithDialect(smb.SMB2_1_Dialect)
+	c.WithTree("C$", 2)
+	treeIds := make(chan uint32, 1)
+	go func() {
+		req, err := readTestFrame(server)
+		if err != nil {
+			return
+		}
+		treeIds <- binary.LittleEndian.Uint32(req[smb.SMB2TreeIdOffset:])
+		res := make([]byte, smb.SMB2HeaderSize+4)
+		copy(res, req[:smb.SMB2HeaderSize])
+		binary.LittleEndian.PutUint16(res[smb.SMB2CreditRequestOffset:], 1)
+		binary.LittleEndian.PutUint32(res[smb.SMB2FlagsOffset:], smb.SMB2_FLAGS_SERVER_TO_REDIR)
+		binary.LittleEndian.PutUint16(res[smb.SMB2HeaderSize:], 4)
+		writeTestFrame(server, res)
+	}()
+	share := &Share{client: c, name: "C$", treeId: 1}
+	if err := share.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if treeId := <-treeIds; treeId != 1 {
+		t.Errorf("TreeDisconnect TreeId = %d, want 1", treeId)
+	}
+	if treeId, ok := c.GetTrees()["C$"]; !ok || treeId != 2 {
+		t.Errorf("trees[C$] = %d, %v, want the other connection kept", treeId, ok)
+	}
+}
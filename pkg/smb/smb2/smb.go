@@ -1,6 +1,8 @@
 package smb2
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -11,6 +13,7 @@ import (
 	"github.com/Amzza0x00/go-impacket/pkg/ms"
 	"github.com/Amzza0x00/go-impacket/pkg/smb"
 	"net"
+	"strconv"
 )
 
 // 此文件提供smb连接方法
@@ -37,26 +40,118 @@ func NewSMB2Packet() smb.SMB2PacketStruct {
 	}
 }
 
+// 客户端支持的协议版本
+var clientDialects = []uint16{
+	smb.SMB2_0_2_Dialect,
+	smb.SMB2_1_Dialect,
+	smb.SMB3_0_Dialect,
+	smb.SMB3_0_2_Dialect,
+	smb.SMB3_1_1_Dialect,
+}
+
 // 协商版本请求初始化
-func (c *Client) NewNegotiateRequest() smb.SMB2NegotiateRequestStruct {
+func (c *Client) NewNegotiateRequest() (smb.SMB2NegotiateRequestStruct, error) {
 	// 初始化
 	smb2Header := NewSMB2Packet()
 	smb2Header.Command = smb.SMB2_NEGOTIATE
 	smb2Header.MessageId = c.GetMessageId()
 	smb2Header.CreditCharge = 1
-	return smb.SMB2NegotiateRequestStruct{
-		SMB2PacketStruct: smb2Header,
-		StructureSize:    36,
-		DialectCount:     1,
-		SecurityMode:     smb.SecurityModeSigningEnabled, // 必须开启签名
-		Reserved:         0,
-		Capabilities:     0,
-		ClientGuid:       make([]byte, 16),
-		ClientStartTime:  0,
-		Dialects: []uint16{
-			uint16(smb.SMB2_1_Dialect),
+	clientGuid := make([]byte, 16)
+	rand.Read(clientGuid)
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	// smb3.1.1协商上下文
+	contexts := []smb.SMB2NegotiateContextStruct{
+		{
+			ContextType: smb.SMB2_PREAUTH_INTEGRITY_CAPABILITIES,
+			Data: smb.SMB2PreauthIntegrityCapabilitiesStruct{
+				HashAlgorithmCount: 1,
+				HashAlgorithms:     []uint16{smb.SMB2_PREAUTH_INTEGRITY_SHA512},
+				Salt:               salt,
+			},
+		},
+		{
+			ContextType: smb.SMB2_ENCRYPTION_CAPABILITIES,
+			Data: smb.SMB2EncryptionCapabilitiesStruct{
+				CipherCount: 4,
+				Ciphers: []uint16{
+					smb.SMB2_ENCRYPTION_AES128_GCM,
+					smb.SMB2_ENCRYPTION_AES128_CCM,
+					smb.SMB2_ENCRYPTION_AES256_GCM,
+					smb.SMB2_ENCRYPTION_AES256_CCM,
+				},
+			},
 		},
+		{
+			ContextType: smb.SMB2_SIGNING_CAPABILITIES,
+			Data: smb.SMB2SigningCapabilitiesStruct{
+				SigningAlgorithmCount: 3,
+				SigningAlgorithms: []uint16{
+					smb.SMB2_SIGNING_AES_GMAC,
+					smb.SMB2_SIGNING_AES_CMAC,
+					smb.SMB2_SIGNING_HMAC_SHA256,
+				},
+			},
+		},
+	}
+	contextList, err := encodeNegotiateContexts(contexts)
+	if err != nil {
+		return smb.SMB2NegotiateRequestStruct{}, err
+	}
+	// 协商上下文相对smb2头的偏移量，需要8字节对齐
+	contextOffset := smb.SMB2HeaderSize + 36 + 2*len(clientDialects)
+	padding := (8 - contextOffset%8) % 8
+	return smb.SMB2NegotiateRequestStruct{
+		SMB2PacketStruct:       smb2Header,
+		StructureSize:          36,
+		DialectCount:           uint16(len(clientDialects)),
+		SecurityMode:           smb.SecurityModeSigningEnabled, // 必须开启签名
+		Reserved:               0,
+		Capabilities:           smb.SMB2_GLOBAL_CAP_LARGE_MTU | smb.SMB2_GLOBAL_CAP_ENCRYPTION, // smb3.0/3.0.2通过能力位协商加密
+		ClientGuid:             clientGuid,
+		NegotiateContextOffset: uint32(contextOffset + padding),
+		NegotiateContextCount:  uint16(len(contexts)),
+		Dialects:               clientDialects,
+		Padding:                make([]byte, padding),
+		NegotiateContextList:   contextList,
+	}, nil
+}
+
+// 编码协商上下文列表，除最后一个外每个上下文都需要8字节对齐
+func encodeNegotiateContexts(contexts []smb.SMB2NegotiateContextStruct) ([]byte, error) {
+	var ret []byte
+	for i, context := range contexts {
+		buf, err := encoder.Marshal(context)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, buf...)
+		if i < len(contexts)-1 {
+			ret = append(ret, make([]byte, (8-len(ret)%8)%8)...)
+		}
 	}
+	return ret, nil
+}
+
+// 解析协商响应中的协商上下文，返回上下文类型与数据的对应关系
+func parseNegotiateContexts(buf []byte, offset uint32, count uint16) (map[uint16][]byte, error) {
+	contexts := make(map[uint16][]byte)
+	o := int(offset)
+	for i := 0; i < int(count); i++ {
+		// 8字节对齐
+		o += (8 - o%8) % 8
+		if o+8 > len(buf) {
+			return nil, errors.New("Invalid negotiate context list")
+		}
+		contextType := binary.LittleEndian.Uint16(buf[o:])
+		dataLength := int(binary.LittleEndian.Uint16(buf[o+2:]))
+		if o+8+dataLength > len(buf) {
+			return nil, errors.New("Invalid negotiate context length")
+		}
+		contexts[contextType] = buf[o+8 : o+8+dataLength]
+		o += 8 + dataLength
+	}
+	return contexts, nil
 }
 
 // 协商版本响应初始化
@@ -67,7 +162,6 @@ func NewNegotiateResponse() smb.SMB2NegotiateResponseStruct {
 		StructureSize:        0,
 		SecurityMode:         0,
 		DialectRevision:      0,
-		Reserved:             0,
 		ServerGuid:           make([]byte, 16),
 		Capabilities:         0,
 		MaxTransactSize:      0,
@@ -77,7 +171,6 @@ func NewNegotiateResponse() smb.SMB2NegotiateResponseStruct {
 		ServerStartTime:      0,
 		SecurityBufferOffset: 0,
 		SecurityBufferLength: 0,
-		Reserved2:            0,
 		SecurityBlob:         &gss.NegTokenInit{},
 	}
 }
@@ -177,7 +270,11 @@ func (c *Client) NewSessionSetup2Request() (smb.SMB2SessionSetup2RequestStruct,
 func (c *Client) NegotiateProtocol() (err error) {
 	// 第一步 发送协商请求
 	c.Debug("Sending Negotiate request", nil)
-	negReq := c.NewNegotiateRequest()
+	negReq, err := c.NewNegotiateRequest()
+	if err != nil {
+		c.Debug("", err)
+		return err
+	}
 	buf, err := c.SMBSend(negReq)
 	if err != nil {
 		c.Debug("", err)
@@ -219,6 +316,38 @@ func (c *Client) NegotiateProtocol() (err error) {
 	c.WithSecurityMode(negRes.SecurityMode)
 	// 设置会话协议
 	c.WithDialect(negRes.DialectRevision)
+	c.Debug(fmt.Sprintf("Negotiated dialect 0x%04x", negRes.DialectRevision), nil)
+	c.WithMaxSizes(negRes.MaxTransactSize, negRes.MaxReadSize, negRes.MaxWriteSize)
+	// smb2.1及以上版本服务端支持LARGE_MTU时才能发送多信用请求
+	c.WithMultiCredit(negRes.DialectRevision >= smb.SMB2_1_Dialect && negRes.Capabilities&smb.SMB2_GLOBAL_CAP_LARGE_MTU != 0)
+	signingAlgorithm := smb.SigningAlgorithm(negRes.DialectRevision)
+	// smb3.0/3.0.2只支持AES-128-CCM
+	var cipherId uint16
+	if negRes.DialectRevision >= smb.SMB3_0_Dialect && negRes.Capabilities&smb.SMB2_GLOBAL_CAP_ENCRYPTION != 0 {
+		cipherId = smb.SMB2_ENCRYPTION_AES128_CCM
+	}
+	if negRes.DialectRevision == smb.SMB3_1_1_Dialect {
+		contexts, err := parseNegotiateContexts(buf, negRes.NegotiateContextOffset, negRes.NegotiateContextCount)
+		if err != nil {
+			c.Debug("Raw:\n"+hex.Dump(buf), err)
+			return err
+		}
+		// 服务端必须返回预认证完整性哈希算法
+		preauth, ok := contexts[smb.SMB2_PREAUTH_INTEGRITY_CAPABILITIES]
+		if !ok || len(preauth) < 6 || binary.LittleEndian.Uint16(preauth[4:]) != smb.SMB2_PREAUTH_INTEGRITY_SHA512 {
+			return errors.New("Server did not select SHA-512 preauth integrity")
+		}
+		// smb3.1.1通过协商上下文选择加密算法，为0表示服务端不支持
+		cipherId = 0
+		if data, ok := contexts[smb.SMB2_ENCRYPTION_CAPABILITIES]; ok && len(data) >= 4 {
+			cipherId = binary.LittleEndian.Uint16(data[2:])
+			c.Debug(fmt.Sprintf("Server selected cipher 0x%04x", cipherId), nil)
+		}
+		if data, ok := contexts[smb.SMB2_SIGNING_CAPABILITIES]; ok && len(data) >= 4 {
+			signingAlgorithm = binary.LittleEndian.Uint16(data[2:])
+		}
+	}
+	c.WithCipher(cipherId)
 	// 签名开启/关闭
 	mode := c.GetSecurityMode()
 	if mode&smb.SecurityModeSigningEnabled > 0 {
@@ -281,15 +410,18 @@ func (c *Client) NegotiateProtocol() (err error) {
 		return err
 	}
 
-	var auth ntlm2.NTLMv2Authentication
+	var (
+		auth       ntlm2.NTLMv2Authentication
+		sessionKey []byte
+	)
 	if c.GetOptions().Hash != "" {
 		// Hash present, use it for auth
 		c.Debug("Performing hash-based authentication", nil)
-		auth = ntlm2.NewAuthenticateHash(c.GetOptions().Domain, c.GetOptions().User, c.GetOptions().Workstation, c.GetOptions().Hash, challenge)
+		auth, sessionKey = ntlm2.NewAuthenticateHash(c.GetOptions().Domain, c.GetOptions().User, c.GetOptions().Workstation, c.GetOptions().Hash, challenge)
 	} else {
 		// No hash, use password
 		c.Debug("Performing password-based authentication", nil)
-		auth = ntlm2.NewAuthenticatePass(c.GetOptions().Domain, c.GetOptions().User, c.GetOptions().Workstation, c.GetOptions().Password, challenge)
+		auth, sessionKey = ntlm2.NewAuthenticatePass(c.GetOptions().Domain, c.GetOptions().User, c.GetOptions().Workstation, c.GetOptions().Password, challenge)
 	}
 
 	responseToken, err := encoder.Marshal(auth)
@@ -313,7 +445,7 @@ func (c *Client) NegotiateProtocol() (err error) {
 		return err
 	}
 	c.Debug("Unmarshalling SessionSetup2 response", nil)
-	var authResp smb.SMB2PacketStruct
+	var authResp smb.SMB2SessionSetup2ResponseStruct
 	if err = encoder.Unmarshal(buf, &authResp); err != nil {
 		c.Debug("Raw:\n"+hex.Dump(buf), err)
 		return err
@@ -323,15 +455,50 @@ func (c *Client) NegotiateProtocol() (err error) {
 		status, _ := ms.StatusMap[authResp.Status]
 		return errors.New(status)
 	}
+	// 来宾、匿名会话没有会话密钥，不能签名
+	if authResp.Flags&(smb.SMB2_SESSION_FLAG_IS_GUEST|smb.SMB2_SESSION_FLAG_IS_NULL) == 0 {
+		c.WithSessionKey(sessionKey)
+		c.WithSigningKey(smb.SigningKey(negRes.DialectRevision, sessionKey, c.GetPreauthHash()), signingAlgorithm)
+		// 最后一个SessionSetup响应在生成密钥后才能校验
+		if err = c.VerifyResponse(buf); err != nil {
+			c.Debug("", err)
+			return err
+		}
+		if c.GetCipher() != 0 {
+			c.WithEncryptionKeys(smb.EncryptionKeys(negRes.DialectRevision, c.GetCipher(), sessionKey, c.GetPreauthHash()))
+		}
+	} else if c.IsSigningRequired {
+		return errors.New("Server requires signing but the session is guest or anonymous")
+	}
+	// 服务端要求或客户端配置了会话加密
+	if authResp.Flags&smb.SMB2_SESSION_FLAG_ENCRYPT_DATA != 0 || c.GetOptions().RequireEncryption {
+		if err = c.checkEncryption(); err != nil {
+			c.Debug("", err)
+			return err
+		}
+		c.Debug("Session encryption enabled", nil)
+		c.WithSessionEncryption(true)
+	}
 	c.IsAuthenticated = true
 
 	c.Debug("Completed NegotiateProtocol and SessionSetup", nil)
 	return nil
 }
 
+// 检查会话是否能够加密
+func (c *Client) checkEncryption() error {
+	if c.GetCipher() == 0 {
+		return errors.New("Server does not support SMB3 encryption")
+	}
+	if c.GetSessionKey() == nil {
+		return errors.New("Encryption requires an authenticated session")
+	}
+	return nil
+}
+
 // SMB2连接封装
 func NewSession(opt common.ClientOptions, debug bool) (client *Client, err error) {
-	address := fmt.Sprintf("%s:%d", opt.Host, opt.Port)
+	address := net.JoinHostPort(opt.Host, strconv.Itoa(opt.Port))
 	conn, err := net.Dial("tcp", address)
 	if err != nil {
 		return
@@ -7,6 +7,7 @@ import (
 	"github.com/Amzza0x00/go-impacket/pkg/encoder"
 	"github.com/Amzza0x00/go-impacket/pkg/ms"
 	"github.com/Amzza0x00/go-impacket/pkg/smb"
+	"strings"
 )
 
 // 此文件用于目录树连接/断开
@@ -34,6 +35,20 @@ type TreeConnectResponseStruct struct {
 	MaximalAccess uint32
 }
 
+// ShareType属性
+const (
+	SMB2_SHARE_TYPE_DISK  = 0x01
+	SMB2_SHARE_TYPE_PIPE  = 0x02
+	SMB2_SHARE_TYPE_PRINT = 0x03
+)
+
+// ShareFlags属性
+const (
+	SMB2_SHAREFLAG_DFS          = 0x00000001
+	SMB2_SHAREFLAG_DFS_ROOT     = 0x00000002
+	SMB2_SHAREFLAG_ENCRYPT_DATA = 0x00008000
+)
+
 // https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/8a622ecb-ffee-41b9-b4c4-83ff2d3aba1b
 // 断开树连接请求结构
 type TreeDisconnectRequestStruct struct {
@@ -121,34 +136,35 @@ func (c *Client) TreeConnect(name string) (treeId uint32, err error) {
 		return 0, errors.New("Failed to connect to [" + name + "]: " + ms.StatusMap[res.SMB2PacketStruct.Status])
 	}
 	treeID := res.SMB2PacketStruct.TreeId
-	trees := make(map[string]uint32)
-	trees[name] = treeID
-	c.WithTrees(trees)
+	// 共享要求加密或客户端配置了该共享加密
+	if res.ShareFlags&SMB2_SHAREFLAG_ENCRYPT_DATA != 0 || c.shareRequiresEncryption(name) {
+		if err = c.checkEncryption(); err != nil {
+			c.Debug("", err)
+			return 0, err
+		}
+		c.Debug("Tree encryption enabled ["+name+"]", nil)
+		c.WithTreeEncryption(treeID, true)
+	}
+	c.WithTree(name, treeID)
 	c.Debug("Completed TreeConnect ["+name+"]", nil)
 	return treeID, nil
 }
 
 // 断开树连接
 func (c *Client) TreeDisconnect(name string) error {
-	var (
-		treeid    uint32
-		pathFound bool
-	)
-	trees := c.GetTrees()
-	for k, v := range trees {
-		if k == name {
-			treeid = v
-			pathFound = true
-			break
-		}
-	}
+	treeid, pathFound := c.GetTrees()[name]
 	if !pathFound {
 		err := errors.New("Unable to find tree path for disconnect")
 		c.Debug("", err)
 		return err
 	}
-	c.Debug("Sending TreeDisconnect request ["+name+"]", nil)
-	req, err := c.NewTreeDisconnectRequest(treeid)
+	return c.TreeDisconnectId(treeid)
+}
+
+// 按树id断开连接，同名共享被重复连接时不影响其他连接
+func (c *Client) TreeDisconnectId(treeId uint32) error {
+	c.Debug(fmt.Sprintf("Sending TreeDisconnect request [0x%x]", treeId), nil)
+	req, err := c.NewTreeDisconnectRequest(treeId)
 	if err != nil {
 		c.Debug("", err)
 		return err
@@ -158,17 +174,39 @@ func (c *Client) TreeDisconnect(name string) error {
 		c.Debug("", err)
 		return err
 	}
-	c.Debug("Unmarshalling TreeDisconnect response for ["+name+"]", nil)
+	c.Debug(fmt.Sprintf("Unmarshalling TreeDisconnect response for [0x%x]", treeId), nil)
 	res := NewTreeDisconnectResponse()
 	if err = encoder.Unmarshal(buf, &res); err != nil {
 		c.Debug("Raw:\n"+hex.Dump(buf), err)
 		return err
 	}
-	if res.SMB2PacketStruct.Status != ms.STATUS_MORE_PROCESSING_REQUIRED {
-		return errors.New("Failed to connect to tree: " + ms.StatusMap[res.SMB2PacketStruct.Status])
+	if res.SMB2PacketStruct.Status != ms.STATUS_SUCCESS {
+		return errors.New("Failed to disconnect from tree: " + ms.StatusMap[res.SMB2PacketStruct.Status])
 	}
-	delete(trees, name)
-	c.WithTrees(trees)
-	c.Debug("TreeDisconnect completed ["+name+"]", nil)
+	c.RemoveTreeId(treeId)
+	c.WithTreeEncryption(treeId, false)
+	c.Debug(fmt.Sprintf("TreeDisconnect completed [0x%x]", treeId), nil)
 	return nil
 }
+
+// 判断客户端是否配置了共享加密
+func (c *Client) shareRequiresEncryption(name string) bool {
+	for _, share := range c.GetOptions().EncryptShares {
+		if strings.EqualFold(share, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// 连接IPC$共享，已经连接时直接复用，返回的disconnect只断开本次新建的连接
+func (c *Client) ConnectIPC() (treeId uint32, disconnect func(), err error) {
+	if treeId, ok := c.GetTrees()["IPC$"]; ok {
+		return treeId, func() {}, nil
+	}
+	treeId, err = c.TreeConnect("IPC$")
+	if err != nil {
+		return 0, nil, err
+	}
+	return treeId, func() { c.TreeDisconnect("IPC$") }, nil
+}
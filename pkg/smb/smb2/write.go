@@ -6,6 +6,7 @@ import (
 	"github.com/Amzza0x00/go-impacket/pkg/encoder"
 	"github.com/Amzza0x00/go-impacket/pkg/ms"
 	"github.com/Amzza0x00/go-impacket/pkg/smb"
+	"io"
 	"os"
 )
 
@@ -82,43 +83,62 @@ func (c *Client) WriteRequest(treeId uint32, filepath, filename string, fileId [
 		return err
 	}
 	defer file.Close()
-	// 一次传入1kb数据
-	fileBuf := make([]byte, 10240)
-	fileOffset := 0
-	i := 0
-Loop:
+	if _, err = c.WriteFile(treeId, fileId, file); err != nil {
+		return errors.New("Failed to write file to [" + filename + "]: " + err.Error())
+	}
+	c.Debug("Completed WriteFile ["+filename+"]", nil)
+	return nil
+}
+
+// 向指定偏移写入数据，返回服务端实际写入的长度
+func (c *Client) WriteAt(treeId uint32, fileId []byte, offset uint64, data []byte) (n uint32, err error) {
+	req := c.NewWriteRequest(treeId, fileId, data)
+	req.CreditCharge = c.CreditCharge(uint32(len(data)))
+	req.FileOffset = offset
+	buf, err := c.SMBSend(req)
+	if err != nil {
+		c.Debug("", err)
+		return 0, err
+	}
+	res := NewWriteResponse()
+	c.Debug("Unmarshalling Write response", nil)
+	if err = encoder.Unmarshal(buf, &res); err != nil {
+		c.Debug("Raw:\n"+hex.Dump(buf), err)
+	}
+	if res.SMB2PacketStruct.Status != ms.STATUS_SUCCESS {
+		return 0, errors.New(ms.StatusMap[res.SMB2PacketStruct.Status])
+	}
+	return res.WriteCount, nil
+}
+
+// 按服务端允许的最大长度分块写入文件
+func (c *Client) WriteFile(treeId uint32, fileId []byte, r io.Reader) (n int64, err error) {
+	var fileBuf []byte
 	for {
-		switch nr, _ := file.Read(fileBuf[:]); true {
-		case nr < 0:
-			return errors.New("Failed read file to [" + filepath + filename + "]")
-		case nr == 0: // EOF
-			break Loop
-		case nr > 0:
-			req := c.NewWriteRequest(treeId, fileId, fileBuf)
-			if i == 0 {
-				req.FileOffset = 0
-			} else {
-				req.FileOffset = uint64(fileOffset)
-			}
-			fileOffset += nr
-			i++
-			buf, err := c.SMBSend(req)
+		// 可用信用数变化时调整分块大小
+		size := c.MaxWriteChunk()
+		if uint32(cap(fileBuf)) < size {
+			fileBuf = make([]byte, size)
+		}
+		chunk := fileBuf[:size]
+		nr, err := io.ReadFull(r, chunk)
+		if nr > 0 {
+			written, err := c.WriteAt(treeId, fileId, uint64(n), chunk[:nr])
+			n += int64(written)
 			if err != nil {
-				c.Debug("", err)
-				return err
+				return n, err
 			}
-			res := NewWriteResponse()
-			c.Debug("Unmarshalling Write file response ["+filename+"]", nil)
-			if err = encoder.Unmarshal(buf, &res); err != nil {
-				c.Debug("Raw:\n"+hex.Dump(buf), err)
-			}
-			if res.SMB2PacketStruct.Status != ms.STATUS_SUCCESS {
-				return errors.New("Failed to write file to [" + filename + "]: " + ms.StatusMap[res.SMB2PacketStruct.Status])
+			if int(written) != nr {
+				return n, errors.New("Short write")
 			}
 		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
 	}
-	c.Debug("Completed WriteFile ["+filename+"]", nil)
-	return nil
 }
 
 // 写入管道数据
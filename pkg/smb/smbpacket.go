@@ -44,6 +44,45 @@ const (
 	SMB2_OPLOCK_BREAK    = 0x0012
 )
 
+// SMB2 Flags标识位
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/fb188936-5050-48d3-b350-dc43059638a4
+const (
+	SMB2_FLAGS_SERVER_TO_REDIR    = 0x00000001
+	SMB2_FLAGS_ASYNC_COMMAND      = 0x00000002
+	SMB2_FLAGS_RELATED_OPERATIONS = 0x00000004
+	SMB2_FLAGS_SIGNED             = 0x00000008
+	SMB2_FLAGS_PRIORITY_MASK      = 0x00000070
+	SMB2_FLAGS_DFS_OPERATIONS     = 0x10000000
+	SMB2_FLAGS_REPLAY_OPERATION   = 0x20000000
+)
+
+// SMB2标准头中各字段的偏移量，用于直接修改已编码的数据包
+const (
+	SMB2HeaderSize          = 64
+	SMB2CreditChargeOffset  = 6
+	SMB2StatusOffset        = 8
+	SMB2CommandOffset       = 12
+	SMB2CreditRequestOffset = 14
+	SMB2FlagsOffset         = 16
+	SMB2NextCommandOffset   = 20
+	SMB2MessageIdOffset     = 24
+	SMB2AsyncIdOffset       = 32
+	SMB2TreeIdOffset        = 36
+	SMB2SessionIdOffset     = 40
+	SMB2SignatureOffset     = 48
+	SMB2SignatureSize       = 16
+)
+
+// 服务端主动发送的oplock中断通知使用的MessageId
+const SMB2UnsolicitedMessageId = 0xFFFFFFFFFFFFFFFF
+
+// SessionSetup响应SessionFlags属性
+const (
+	SMB2_SESSION_FLAG_IS_GUEST     = 0x0001
+	SMB2_SESSION_FLAG_IS_NULL      = 0x0002
+	SMB2_SESSION_FLAG_ENCRYPT_DATA = 0x0004
+)
+
 // SMB2标准头结构
 type SMB2PacketStruct struct {
 	ProtocolId            []byte `smb:"fixed:4"` //4字节，协议标识符，必须设置为 0x424D53FE
@@ -71,38 +110,104 @@ const (
 	SMB3_1_1_Dialect = 0x0311
 )
 
+// SMB2 Negotiate Capabilities
+const (
+	SMB2_GLOBAL_CAP_DFS                = 0x00000001
+	SMB2_GLOBAL_CAP_LEASING            = 0x00000002
+	SMB2_GLOBAL_CAP_LARGE_MTU          = 0x00000004
+	SMB2_GLOBAL_CAP_MULTI_CHANNEL      = 0x00000008
+	SMB2_GLOBAL_CAP_PERSISTENT_HANDLES = 0x00000010
+	SMB2_GLOBAL_CAP_DIRECTORY_LEASING  = 0x00000020
+	SMB2_GLOBAL_CAP_ENCRYPTION         = 0x00000040
+)
+
 // SMB2 Negotiate 请求头结构
 type SMB2NegotiateRequestStruct struct {
 	SMB2PacketStruct
-	StructureSize   uint16   //2字节，客户端必须设置36
-	DialectCount    uint16   `smb:"count:Dialects"` //2字节，必须大于0
-	SecurityMode    uint16   //2字节，设置是否启用SMB签名
-	Reserved        uint16   //2字节，必须设置0
-	Capabilities    uint32   //4字节，如果客户端使用SMB3.x，必须使用SMB2_GLOBAL_CAP_*构造，否则设置为0
-	ClientGuid      []byte   `smb:"fixed:16"` //16字节，客户端自身生成
-	ClientStartTime uint64   //8字节，保留字段，归零
-	Dialects        []uint16 //16位整数数组
+	StructureSize          uint16   //2字节，客户端必须设置36
+	DialectCount           uint16   `smb:"count:Dialects"` //2字节，必须大于0
+	SecurityMode           uint16   //2字节，设置是否启用SMB签名
+	Reserved               uint16   //2字节，必须设置0
+	Capabilities           uint32   //4字节，如果客户端使用SMB3.x，必须使用SMB2_GLOBAL_CAP_*构造，否则设置为0
+	ClientGuid             []byte   `smb:"fixed:16"` //16字节，客户端自身生成
+	NegotiateContextOffset uint32   //4字节，smb3.1.1协商上下文偏移量，其他版本为ClientStartTime，归零
+	NegotiateContextCount  uint16   //2字节，协商上下文数量
+	Reserved2              uint16   //2字节，保留字段，归零
+	Dialects               []uint16 //16位整数数组
+	Padding                []byte   //协商上下文需要8字节对齐
+	NegotiateContextList   []byte   //smb3.1.1协商上下文列表
+}
+
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/15332256-522e-4a53-8cd7-0bd17678a2f7
+// 协商上下文类型
+const (
+	SMB2_PREAUTH_INTEGRITY_CAPABILITIES = 0x0001
+	SMB2_ENCRYPTION_CAPABILITIES        = 0x0002
+	SMB2_COMPRESSION_CAPABILITIES       = 0x0003
+	SMB2_NETNAME_NEGOTIATE_CONTEXT_ID   = 0x0005
+	SMB2_TRANSPORT_CAPABILITIES         = 0x0006
+	SMB2_RDMA_TRANSFORM_CAPABILITIES    = 0x0007
+	SMB2_SIGNING_CAPABILITIES           = 0x0008
+)
+
+// 预认证完整性哈希算法
+const SMB2_PREAUTH_INTEGRITY_SHA512 = 0x0001
+
+// 加密算法
+const (
+	SMB2_ENCRYPTION_AES128_CCM = 0x0001
+	SMB2_ENCRYPTION_AES128_GCM = 0x0002
+	SMB2_ENCRYPTION_AES256_CCM = 0x0003
+	SMB2_ENCRYPTION_AES256_GCM = 0x0004
+)
+
+// 协商上下文结构，上下文之间需要8字节对齐
+type SMB2NegotiateContextStruct struct {
+	ContextType uint16
+	DataLength  uint16 `smb:"len:Data"`
+	Reserved    uint32
+	Data        interface{}
+}
+
+// 预认证完整性能力
+type SMB2PreauthIntegrityCapabilitiesStruct struct {
+	HashAlgorithmCount uint16
+	SaltLength         uint16 `smb:"len:Salt"`
+	HashAlgorithms     []uint16
+	Salt               []byte
+}
+
+// 加密能力，按优先级排列
+type SMB2EncryptionCapabilitiesStruct struct {
+	CipherCount uint16
+	Ciphers     []uint16
+}
+
+// 签名能力，按优先级排列
+type SMB2SigningCapabilitiesStruct struct {
+	SigningAlgorithmCount uint16
+	SigningAlgorithms     []uint16
 }
 
 // https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/63abf97c-0d09-47e2-88d6-6bfa552949a5
 // SMB2 Negotiate 响应头结构
 type SMB2NegotiateResponseStruct struct {
 	SMB2PacketStruct
-	StructureSize        uint16            //2字节，客户端必须设置36
-	SecurityMode         uint16            //2字节，设置是否启用SMB签名
-	DialectRevision      uint16            //2字节，SMB协议号
-	Reserved             uint16            //2字节，保留字段，归零
-	ServerGuid           []byte            `smb:"fixed:16"` //16字节，服务器标识符
-	Capabilities         uint32            //4字节，服务器协议作用
-	MaxTransactSize      uint32            //4字节，客户端set_info请求缓冲区大小
-	MaxReadSize          uint32            //4字节，服务器接受smb read请求最大长度
-	MaxWriteSize         uint32            //4字节，服务器接受smb write请求最大长度
-	SystemTime           uint64            //8字节，处理协商请求服务器系统时间
-	ServerStartTime      uint64            //8字节，服务器启动时间
-	SecurityBufferOffset uint16            `smb:"offset:SecurityBlob"` //2字节，smb2表头开始到安全缓存区的偏移量
-	SecurityBufferLength uint16            `smb:"len:SecurityBlob"`    //2字节，安全缓冲区长度
-	Reserved2            uint32            //4字节，协商上下文偏移量
-	SecurityBlob         *gss.NegTokenInit //服务器返回二进制安全对象，遵循RFC2743标准
+	StructureSize          uint16            //2字节，客户端必须设置36
+	SecurityMode           uint16            //2字节，设置是否启用SMB签名
+	DialectRevision        uint16            //2字节，SMB协议号
+	NegotiateContextCount  uint16            //2字节，smb3.1.1协商上下文数量，其他版本保留
+	ServerGuid             []byte            `smb:"fixed:16"` //16字节，服务器标识符
+	Capabilities           uint32            //4字节，服务器协议作用
+	MaxTransactSize        uint32            //4字节，客户端set_info请求缓冲区大小
+	MaxReadSize            uint32            //4字节，服务器接受smb read请求最大长度
+	MaxWriteSize           uint32            //4字节，服务器接受smb write请求最大长度
+	SystemTime             uint64            //8字节，处理协商请求服务器系统时间
+	ServerStartTime        uint64            //8字节，服务器启动时间
+	SecurityBufferOffset   uint16            `smb:"offset:SecurityBlob"` //2字节，smb2表头开始到安全缓存区的偏移量
+	SecurityBufferLength   uint16            `smb:"len:SecurityBlob"`    //2字节，安全缓冲区长度
+	NegotiateContextOffset uint32            //4字节，smb3.1.1协商上下文偏移量，其他版本保留
+	SecurityBlob           *gss.NegTokenInit //服务器返回二进制安全对象，遵循RFC2743标准
 }
 
 // https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-smb2/5a3c2c28-d6b0-48ed-b917-a86b2ca4575f
@@ -144,3 +249,10 @@ type SMB2SessionSetup2RequestStruct struct {
 	PreviousSessionID    uint64 //8字节，会话标识符。服务端用来标识客户端会话
 	SecurityBlob         *gss.NegTokenResp
 }
+
+// 认证响应结构，只需要解析SessionFlags
+type SMB2SessionSetup2ResponseStruct struct {
+	SMB2PacketStruct
+	StructureSize uint16
+	Flags         uint16
+}